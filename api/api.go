@@ -13,8 +13,11 @@ import (
 
 var ErrNoPublicKey = fmt.Errorf("api: no public key for user")
 
+// BaseURL is the keybase.io API endpoint that requests are sent to.
+var BaseURL = "https://keybase.io/_/api/1.0/"
+
 func commandUrl(cmd string) string {
-	return "https://keybase.io/_/api/1.0/" + cmd + ".json"
+	return BaseURL + cmd + ".json"
 }
 
 // IsAPIError returns true if the error is from the Keybase API (and
@@ -28,6 +31,29 @@ func IsAPIError(err error) bool {
 	}
 }
 
+// Status codes returned by the API for failed logins and lookups.
+const (
+	StatusLoginRequired    = 201
+	StatusBadSession       = 202
+	StatusBadLoginUser     = 203
+	StatusBadLoginPassword = 204
+	StatusNotFound         = 205
+)
+
+// IsNotFound returns true if the error is the API reporting that a user
+// or key doesn't exist.
+func IsNotFound(err error) bool {
+	st, ok := err.(*Status)
+	return ok && st.Code == StatusNotFound || err == ErrUserNotFound || err == ErrNoPublicKey
+}
+
+// IsAuthError returns true if the error is the API refusing a login or
+// session.
+func IsAuthError(err error) bool {
+	st, ok := err.(*Status)
+	return ok && st.Code >= StatusLoginRequired && st.Code <= StatusBadLoginPassword
+}
+
 // Status contains the API call status results from keybase.io.
 type Status struct {
 	Desc string `json:"desc"`
@@ -57,39 +83,6 @@ type Session struct {
 	Token   string  `json:"csrf_token"`
 }
 
-// LookupUser returns available user information for the named user.
-func LookupUser(user string) (u *User, err error) {
-	url := commandUrl("user/lookup") + "?username=" + user
-	resp, err := http.Get(url)
-	if err != nil {
-		return
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
-	resp.Body.Close()
-
-	var userResponse struct {
-		Status  *Status `json:"status"`
-		Session string  `json:"session"`
-		User    *User   `json:"them"`
-	}
-
-	err = json.Unmarshal(body, &userResponse)
-	if err != nil {
-		return
-	} else if !userResponse.Status.Success() {
-		err = userResponse.Status
-		return
-	}
-	ioutil.WriteFile("/tmp/user.json", body, 0644)
-
-	u = userResponse.User
-	return
-}
-
 type keyResponse struct {
 	Status  *Status `json:"status"`
 	KeyID   string  `json:"kid"`
@@ -186,7 +179,7 @@ type signatureData struct {
 }
 
 func (s *Session) SignaturePostAuthData() (msg []byte, err error) {
-	pub := s.User.PublicKeys["primary"]
+	pub := s.User.PublicKeys.Primary
 	if pub == nil {
 		err = ErrNoPublicKey
 		return
@@ -315,7 +308,7 @@ type signaturePayload struct {
 }
 
 func (s *Session) serviceBody(svcName, svcUser string) (svcBody *signaturePayload, err error) {
-	pub := s.User.PublicKeys["primary"]
+	pub := s.User.PublicKeys.Primary
 	if pub == nil {
 		err = ErrNoPublicKey
 		return
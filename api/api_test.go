@@ -1,6 +1,7 @@
 package api
 
 import "flag"
+import "os"
 import "testing"
 import "fmt"
 
@@ -12,13 +13,19 @@ var testConfig struct {
 
 var testSession *Session
 
-func init() {
-	lUser := flag.String("api.user", "alice", "test user")
-	lPass := flag.String("api.pass", "password", "Read the password from the console.")
+var (
+	lUser = flag.String("api.user", "alice", "test user")
+	lPass = flag.String("api.pass", "password", "Read the password from the console.")
+)
+
+// TestMain parses the flags once the testing package has added its
+// own.
+func TestMain(m *testing.M) {
 	flag.Parse()
 
 	testConfig.LoginUser = *lUser
 	testConfig.LoginPass = []byte(*lPass)
+	os.Exit(m.Run())
 }
 
 func TestGetSalt(t *testing.T) {
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrOffline = fmt.Errorf("api: not in the cache, and working offline")
+
+// A Cache keeps the users and key bundles that have been looked up on
+// disk, so that looking the same users up again doesn't need to ask
+// keybase.io. Users are kept by their uid, with an index from each
+// lookup (by username, fingerprint or proof) to the uid, and key
+// bundles by their KID.
+type Cache struct {
+	Dir     string
+	TTL     time.Duration // how long a user is used without asking keybase.io
+	KeyTTL  time.Duration // and the same for a key bundle
+	Offline bool          // only use the cache, however old it is
+}
+
+// UserCache is the cache that lookups use; if it's nil, every lookup
+// asks keybase.io.
+var UserCache *Cache
+
+// validators are the ETag and Last-Modified headers of a response,
+// sent back to ask whether it has changed since.
+type validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// A cachedUser is a user as stored in the cache, with the fields that
+// were fetched (all of them if there are none).
+type cachedUser struct {
+	Fetched time.Time `json:"fetched"`
+	validators
+	Fields []string `json:"fields,omitempty"`
+	User   *User    `json:"user"`
+}
+
+// A cachedKey is a key bundle as stored in the cache.
+type cachedKey struct {
+	Fetched  time.Time `json:"fetched"`
+	Username string    `json:"username"`
+	Bundle   string    `json:"bundle,omitempty"`
+}
+
+// Cache subdirectories.
+const (
+	cacheUsers = "users"
+	cacheKeys  = "keys"
+	cacheIndex = "index"
+)
+
+// cacheKey returns the name a lookup is indexed under, e.g.
+// "username=alice".
+func cacheKey(query url.Values) string {
+	return strings.ToLower(query.Encode())
+}
+
+func (c *Cache) path(kind, name string) string {
+	return filepath.Join(c.Dir, kind, url.QueryEscape(name))
+}
+
+func (c *Cache) read(kind, name string, v interface{}) bool {
+	data, err := ioutil.ReadFile(c.path(kind, name))
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+// write stores v in the cache, replacing the file in one go so other
+// readers never see half of it.
+func (c *Cache) write(kind, name string, v interface{}) (err error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	path := c.path(kind, name)
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return
+}
+
+func (c *Cache) offline() bool {
+	return c != nil && c.Offline
+}
+
+// user returns the cached user for a lookup, if there is one with the
+// fields wanted.
+func (c *Cache) user(key string, fields []string) *cachedUser {
+	var uid string
+	if c == nil || !c.read(cacheIndex, key, &uid) {
+		return nil
+	}
+	entry := new(cachedUser)
+	if !c.read(cacheUsers, uid, entry) || entry.User == nil {
+		return nil
+	}
+	// The user may have been renamed since.
+	if query, _ := url.ParseQuery(key); query.Get("username") != "" &&
+		!strings.EqualFold(query.Get("username"), entry.User.Basics.Username) {
+		return nil
+	}
+	if len(entry.Fields) == 0 {
+		return entry
+	} else if len(fields) == 0 {
+		return nil
+	}
+	for _, field := range fields {
+		if !hasField(entry.Fields, field) {
+			return nil
+		}
+	}
+	return entry
+}
+
+func hasField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// serves returns true if a cached entry can be used as it is.
+func (c *Cache) serves(entry *cachedUser) bool {
+	return entry != nil && (c.Offline || time.Since(entry.Fetched) < c.TTL)
+}
+
+// storeUser caches a user that's been looked up, and their primary key
+// bundle. The cache is only an aid, so failing to write it isn't an
+// error.
+func (c *Cache) storeUser(key string, fields []string, u *User, val validators) {
+	if c == nil || u == nil || u.ID == "" {
+		return
+	}
+	entry := &cachedUser{Fetched: time.Now(), validators: val, Fields: fields, User: u}
+	if c.write(cacheUsers, u.ID, entry) == nil {
+		c.write(cacheIndex, key, u.ID)
+	}
+	if pub := u.PublicKeys.Primary; pub != nil && pub.KeyID != "" && pub.Bundle != "" {
+		c.write(cacheKeys, strings.ToLower(pub.KeyID), &cachedKey{time.Now(), u.Basics.Username, pub.Bundle})
+	}
+}
+
+// lookupCached looks up a user with fetch, unless UserCache has a fresh
+// copy. A stale copy is sent with a conditional request, so keybase.io
+// can say it hasn't changed rather than sending it again.
+func lookupCached(query url.Values, fields []string, fetch func(query url.Values, val *validators) (*User, bool, error)) (u *User, err error) {
+	key := cacheKey(query)
+	if len(fields) > 0 {
+		query.Set("fields", strings.Join(fields, ","))
+	}
+
+	c := UserCache
+	entry := c.user(key, fields)
+	if c.serves(entry) {
+		return entry.User, nil
+	} else if c.offline() {
+		return nil, ErrOffline
+	}
+
+	var val validators
+	if entry != nil {
+		val = entry.validators
+	}
+	u, notModified, err := fetch(query, &val)
+	if err != nil {
+		return
+	} else if notModified {
+		if entry == nil {
+			err = fmt.Errorf("api: unexpected 304 Not Modified")
+			return
+		}
+		u = entry.User
+	}
+	c.storeUser(key, fields, u, val)
+	return
+}
+
+// KeyBundle returns the armoured public key with the given KID, and the
+// username of its owner.
+func KeyBundle(kid string) (bundle, username string, err error) {
+	kid, ok := checkHex(kid, 0)
+	if !ok {
+		err = ErrInvalidKID
+		return
+	}
+
+	c := UserCache
+	var entry cachedKey
+	if c != nil && c.read(cacheKeys, kid, &entry) && (c.Offline || time.Since(entry.Fetched) < c.KeyTTL) {
+		return entry.Bundle, entry.Username, nil
+	} else if c.offline() {
+		err = ErrOffline
+		return
+	}
+
+	var keyResponse struct {
+		Status *Status `json:"status"`
+		Keys   []struct {
+			KeyID    string `json:"kid"`
+			Username string `json:"username"`
+			Bundle   string `json:"bundle"`
+		} `json:"keys"`
+	}
+	err = getJSON("key/fetch", url.Values{"kids": {kid}}, &keyResponse)
+	if err != nil {
+		return
+	} else if !keyResponse.Status.Success() {
+		err = keyResponse.Status
+		return
+	} else if len(keyResponse.Keys) == 0 || keyResponse.Keys[0].Username == "" {
+		err = ErrUserNotFound
+		return
+	}
+
+	bundle, username = keyResponse.Keys[0].Bundle, keyResponse.Keys[0].Username
+	if c != nil {
+		c.write(cacheKeys, kid, &cachedKey{time.Now(), username, bundle})
+	}
+	return
+}
+
+// CacheStats describes what's in a Cache.
+type CacheStats struct {
+	Dir        string `json:"dir"`
+	Users      int    `json:"users"`
+	FreshUsers int    `json:"fresh_users"`
+	Keys       int    `json:"keys"`
+	FreshKeys  int    `json:"fresh_keys"`
+	Lookups    int    `json:"lookups"` // the lookups indexed
+	Bytes      int64  `json:"bytes"`
+}
+
+// Stats counts the users and keys in the cache, and how many of them
+// are still fresh.
+func (c *Cache) Stats() (stats *CacheStats, err error) {
+	stats = &CacheStats{Dir: c.Dir}
+	for _, kind := range []string{cacheUsers, cacheKeys, cacheIndex} {
+		var files []os.FileInfo
+		files, err = ioutil.ReadDir(filepath.Join(c.Dir, kind))
+		if os.IsNotExist(err) {
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+
+		for _, fi := range files {
+			if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+				continue
+			}
+			stats.Bytes += fi.Size()
+			name, _ := url.QueryUnescape(fi.Name())
+			switch kind {
+			case cacheUsers:
+				var entry cachedUser
+				stats.Users++
+				if c.read(kind, name, &entry) && time.Since(entry.Fetched) < c.TTL {
+					stats.FreshUsers++
+				}
+			case cacheKeys:
+				var entry cachedKey
+				stats.Keys++
+				if c.read(kind, name, &entry) && time.Since(entry.Fetched) < c.KeyTTL {
+					stats.FreshKeys++
+				}
+			default:
+				stats.Lookups++
+			}
+		}
+	}
+	return
+}
+
+// Clear empties the cache, returning what was in it.
+func (c *Cache) Clear() (stats *CacheStats, err error) {
+	stats, err = c.Stats()
+	if err != nil {
+		return
+	}
+	for _, kind := range []string{cacheUsers, cacheKeys, cacheIndex} {
+		err = os.RemoveAll(filepath.Join(c.Dir, kind))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCache sets up UserCache in a temporary directory, with a server
+// that looks up any user, gives each response the ETag "v1", and
+// answers requests with that ETag with 304 Not Modified. Each request
+// is logged in requests.
+func testCache(t *testing.T) (requests *[]*http.Request, done func()) {
+	requests = new([]*http.Request)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r)
+		if r.Header.Get("If-None-Match") == "v1" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		user := func(name string) *User {
+			u := &User{ID: "id-" + name}
+			u.Basics.Username = name
+			u.PublicKeys.Primary = &Key{KeyID: "0101" + hex.EncodeToString([]byte(name)), Bundle: "bundle for " + name}
+			return u
+		}
+		resp := map[string]interface{}{"status": &Status{Name: "OK"}}
+		if names := r.URL.Query().Get("usernames"); names != "" {
+			var them []*User
+			for _, name := range strings.Split(names, ",") {
+				them = append(them, user(name))
+			}
+			resp["them"] = them
+		} else {
+			resp["them"] = user(r.URL.Query().Get("username"))
+		}
+		w.Header().Set("ETag", "v1")
+		json.NewEncoder(w).Encode(resp)
+	}))
+
+	dir, err := ioutil.TempDir("", "keybase-cache")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	oldURL := BaseURL
+	BaseURL = srv.URL + "/"
+	UserCache = &Cache{Dir: dir, TTL: time.Hour, KeyTTL: time.Hour}
+	return requests, func() {
+		BaseURL, UserCache = oldURL, nil
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestLookupCached validates that a fresh cached user is used without
+// a request, and that a stale one is revalidated with its ETag.
+func TestLookupCached(t *testing.T) {
+	requests, done := testCache(t)
+	defer done()
+
+	for i := 0; i < 2; i++ {
+		u, err := LookupUser("alice")
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if u.Basics.Username != "alice" {
+			t.Fatalf("expected alice, got %s", u.Basics.Username)
+		}
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("expected 1 request, made %d", len(*requests))
+	}
+
+	UserCache.TTL = 0
+	u, err := LookupUser("Alice")
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if u.Basics.Username != "alice" {
+		t.Fatalf("expected alice, got %s", u.Basics.Username)
+	} else if len(*requests) != 2 || (*requests)[1].Header.Get("If-None-Match") != "v1" {
+		t.Fatal("a stale user wasn't revalidated")
+	}
+}
+
+// TestLookupCachedFields validates that a user cached with only some
+// fields isn't used for a lookup wanting others.
+func TestLookupCachedFields(t *testing.T) {
+	requests, done := testCache(t)
+	defer done()
+
+	if _, err := LookupUserFields("alice", []string{"basics"}); err != nil {
+		t.Fatalf("%v", err)
+	} else if _, err = LookupUserFields("alice", []string{"basics"}); err != nil {
+		t.Fatalf("%v", err)
+	} else if len(*requests) != 1 {
+		t.Fatalf("expected 1 request, made %d", len(*requests))
+	}
+
+	if _, err := LookupUserFields("alice", []string{"basics", "profile"}); err != nil {
+		t.Fatalf("%v", err)
+	} else if _, err = LookupUser("alice"); err != nil {
+		t.Fatalf("%v", err)
+	} else if len(*requests) != 3 {
+		t.Fatalf("expected 3 requests, made %d", len(*requests))
+	}
+}
+
+// TestLookupUsersOffline validates that batches only ask for users
+// that aren't cached, and that offline lookups use only the cache.
+func TestLookupUsersOffline(t *testing.T) {
+	requests, done := testCache(t)
+	defer done()
+
+	if _, err := LookupUser("alice"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	results := LookupUsers([]string{"alice", "bob"}, nil)
+	if len(*requests) != 2 || (*requests)[1].URL.Query().Get("usernames") != "bob" {
+		t.Fatal("a cached user was asked for again")
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("%v", result.Err)
+		}
+	}
+
+	UserCache.TTL, UserCache.Offline = 0, true
+	results = LookupUsers([]string{"alice", "carol"}, nil)
+	if results[0].Err != nil {
+		t.Fatalf("%v", results[0].Err)
+	} else if results[1].Err != ErrOffline {
+		t.Fatalf("expected ErrOffline, got %v", results[1].Err)
+	} else if len(*requests) != 2 {
+		t.Fatal("an offline lookup made a request")
+	}
+
+	bundle, username, err := KeyBundle("0101" + hex.EncodeToString([]byte("bob")))
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if username != "bob" || bundle != "bundle for bob" {
+		t.Fatalf("got key bundle %q for %s", bundle, username)
+	}
+}
+
+// TestCacheClear validates that Stats counts the cache and Clear
+// empties it.
+func TestCacheClear(t *testing.T) {
+	_, done := testCache(t)
+	defer done()
+
+	LookupUsers([]string{"alice", "bob"}, nil)
+	stats, err := UserCache.Clear()
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if stats.Users != 2 || stats.FreshUsers != 2 || stats.Keys != 2 || stats.Lookups != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	stats, err = UserCache.Stats()
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if stats.Users != 0 || stats.Keys != 0 || stats.Bytes != 0 {
+		t.Fatalf("cache wasn't cleared: %+v", stats)
+	}
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUserNotFound       = fmt.Errorf("api: user not found")
+	ErrInvalidFingerprint = fmt.Errorf("api: invalid key fingerprint")
+	ErrInvalidKID         = fmt.Errorf("api: invalid KID")
+	ErrUnknownService     = fmt.Errorf("api: unknown proof service")
+	ErrKeyMismatch        = fmt.Errorf("api: the user found doesn't have the key looked up")
+)
+
+// ProofServices maps the services that LookupByProof accepts to the
+// API's lookup parameters. Websites and DNS domains are both looked up
+// by domain.
+var ProofServices = map[string]string{
+	"twitter":    "twitter",
+	"github":     "github",
+	"reddit":     "reddit",
+	"hackernews": "hackernews",
+	"coinbase":   "coinbase",
+	"dns":        "domain",
+	"web":        "domain",
+}
+
+// LookupFields are the parts of a user that LookupUserFields can
+// ask for.
+var LookupFields = []string{
+	"basics",
+	"profile",
+	"public_keys",
+	"proofs_summary",
+	"cryptocurrency_addresses",
+	"pictures",
+	"sigs",
+}
+
+// LookupBatchSize is the most users LookupUsers asks for in one
+// request.
+var LookupBatchSize = 100
+
+// LookupWorkers is how many requests LookupUsers and LookupAll have
+// in flight at once, unless told otherwise.
+var LookupWorkers = 4
+
+// A LookupResult is the result of looking up one user in a batch.
+type LookupResult struct {
+	Query string // the name, or other query, that was looked up
+	User  *User  // nil if the lookup failed
+	Err   error
+}
+
+// getJSON sends a GET request for an API command and decodes the
+// response into v.
+func getJSON(cmd string, query url.Values, v interface{}) (err error) {
+	_, err = getJSONIf(cmd, query, nil, v)
+	return
+}
+
+// getJSONIf is getJSON as a conditional request: if val holds the
+// validators of an earlier response and it hasn't changed since, it
+// returns notModified and leaves v alone. Otherwise val is updated from
+// the new response.
+func getJSONIf(cmd string, query url.Values, val *validators, v interface{}) (notModified bool, err error) {
+	req, err := http.NewRequest("GET", commandUrl(cmd)+"?"+query.Encode(), nil)
+	if err != nil {
+		return
+	}
+	if val != nil && val.ETag != "" {
+		req.Header.Set("If-None-Match", val.ETag)
+	}
+	if val != nil && val.LastModified != "" {
+		req.Header.Set("If-Modified-Since", val.LastModified)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		notModified = true
+		return
+	}
+	if val != nil {
+		val.ETag = resp.Header.Get("ETag")
+		val.LastModified = resp.Header.Get("Last-Modified")
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(body, v)
+	return
+}
+
+// LookupUser returns available user information for the named user.
+func LookupUser(user string) (u *User, err error) {
+	return LookupUserFields(user, nil)
+}
+
+// LookupUserFields looks up the named user as LookupUser does, but
+// only fetches the given LookupFields, or every field if none are
+// given.
+func LookupUserFields(user string, fields []string) (u *User, err error) {
+	return lookupCached(url.Values{"username": {user}}, fields, fetchUser)
+}
+
+func fetchUser(query url.Values, val *validators) (u *User, notModified bool, err error) {
+	var userResponse struct {
+		Status  *Status `json:"status"`
+		Session string  `json:"session"`
+		User    *User   `json:"them"`
+	}
+	notModified, err = getJSONIf("user/lookup", query, val, &userResponse)
+	if err != nil || notModified {
+		return
+	} else if !userResponse.Status.Success() {
+		err = userResponse.Status
+		return
+	}
+
+	u = userResponse.User
+	return
+}
+
+// parallel calls fn for 0 to n-1, running at most workers at once.
+func parallel(n, workers int, fn func(i int)) {
+	if workers <= 0 {
+		workers = LookupWorkers
+	}
+	jobs := make(chan int)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// LookupAll calls lookup for each of the queries, with at most workers
+// (or LookupWorkers if workers is 0) running at once, and returns
+// their results in the same order. It's for lookups the API can only
+// do one at a time, such as by key or by proof.
+func LookupAll(queries []string, workers int, lookup func(query string) (*User, error)) []*LookupResult {
+	results := make([]*LookupResult, len(queries))
+	parallel(len(queries), workers, func(i int) {
+		u, err := lookup(queries[i])
+		results[i] = &LookupResult{Query: queries[i], User: u, Err: err}
+	})
+	return results
+}
+
+// LookupUsers looks up the named users, asking for up to
+// LookupBatchSize of them in each request, and returns a result for
+// each name in the same order. Users with a fresh copy in UserCache
+// aren't asked for. Users that don't exist fail with ErrUserNotFound;
+// if a request fails, each of its names fails with that error.
+func LookupUsers(names, fields []string) []*LookupResult {
+	results := make([]*LookupResult, len(names))
+	var missing []string
+	var missingAt []int
+	for i, name := range names {
+		entry := UserCache.user(cacheKey(url.Values{"username": {name}}), fields)
+		switch {
+		case UserCache.serves(entry):
+			results[i] = &LookupResult{Query: name, User: entry.User}
+		case UserCache.offline():
+			results[i] = &LookupResult{Query: name, Err: ErrOffline}
+		default:
+			missing = append(missing, name)
+			missingAt = append(missingAt, i)
+		}
+	}
+
+	var batches [][]string
+	for len(missing) > LookupBatchSize {
+		batches = append(batches, missing[:LookupBatchSize])
+		missing = missing[LookupBatchSize:]
+	}
+	if len(missing) > 0 {
+		batches = append(batches, missing)
+	}
+
+	batchResults := make([][]*LookupResult, len(batches))
+	parallel(len(batches), 0, func(i int) {
+		batchResults[i] = lookupBatch(batches[i], fields)
+	})
+
+	var fetched []*LookupResult
+	for _, batch := range batchResults {
+		fetched = append(fetched, batch...)
+	}
+	for i, result := range fetched {
+		results[missingAt[i]] = result
+		if result.Err == nil {
+			UserCache.storeUser(cacheKey(url.Values{"username": {result.Query}}), fields, result.User, validators{})
+		}
+	}
+	return results
+}
+
+func lookupBatch(names, fields []string) (results []*LookupResult) {
+	query := url.Values{"usernames": {strings.Join(names, ",")}}
+	if len(fields) > 0 {
+		query.Set("fields", strings.Join(fields, ","))
+	}
+
+	var batchResponse struct {
+		Status *Status `json:"status"`
+		Users  []*User `json:"them"`
+	}
+	err := getJSON("user/lookup", query, &batchResponse)
+	if err == nil && !batchResponse.Status.Success() {
+		err = batchResponse.Status
+	}
+
+	switch {
+	case IsAPIError(err) && len(names) > 1:
+		// The API rejects the whole batch if one name is invalid;
+		// look each one up to find out which.
+		return LookupAll(names, 1, func(name string) (*User, error) {
+			return LookupUserFields(name, fields)
+		})
+	case err == nil && len(batchResponse.Users) != len(names):
+		err = fmt.Errorf("api: asked for %d users but got %d", len(names), len(batchResponse.Users))
+	}
+
+	for i, name := range names {
+		result := &LookupResult{Query: name, Err: err}
+		if err == nil {
+			result.User = batchResponse.Users[i]
+			if result.User == nil {
+				result.Err = ErrUserNotFound
+			}
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+// lookupFirst returns the first user matching query, for lookups that
+// the API answers with a list.
+func lookupFirst(query url.Values, fields []string) (u *User, err error) {
+	return lookupCached(query, fields, fetchFirst)
+}
+
+func fetchFirst(query url.Values, val *validators) (u *User, notModified bool, err error) {
+	var listResponse struct {
+		Status *Status `json:"status"`
+		Users  []*User `json:"them"`
+	}
+	notModified, err = getJSONIf("user/lookup", query, val, &listResponse)
+	if err != nil || notModified {
+		return
+	} else if !listResponse.Status.Success() {
+		err = listResponse.Status
+		return
+	}
+
+	for _, u = range listResponse.Users {
+		if u != nil {
+			return
+		}
+	}
+	err = ErrUserNotFound
+	return
+}
+
+// checkHex lowercases a fingerprint or KID, dropping any spaces, and
+// checks that it's hex of the given length (or any length if n is 0).
+func checkHex(s string, n int) (string, bool) {
+	s = strings.ToLower(strings.Replace(s, " ", "", -1))
+	_, err := hex.DecodeString(s)
+	return s, err == nil && s != "" && (n == 0 || len(s) == n)
+}
+
+// LookupByFingerprint looks up the user whose key has the given
+// fingerprint, fetching only the given LookupFields if any are given.
+func LookupByFingerprint(fpr string, fields ...string) (u *User, err error) {
+	fpr, ok := checkHex(fpr, 40)
+	if !ok {
+		err = ErrInvalidFingerprint
+		return
+	}
+	return lookupFirst(url.Values{"key_fingerprint": {fpr}}, fields)
+}
+
+// LookupByKID looks up the user who owns the key with the given KID,
+// keybase.io's ID for a key. The key is fetched first to find its
+// owner, who must then list it among their keys.
+func LookupByKID(kid string, fields ...string) (u *User, err error) {
+	_, username, err := KeyBundle(kid)
+	if err != nil {
+		return
+	}
+
+	if len(fields) > 0 {
+		fields = append(fields[:len(fields):len(fields)], "public_keys")
+	}
+	u, err = LookupUserFields(username, fields)
+	if err != nil {
+		return
+	} else if kid, _ = checkHex(kid, 0); !u.HasKID(kid) {
+		u, err = nil, ErrKeyMismatch
+	}
+	return
+}
+
+// HasKID returns true if the user's primary key, or one of their
+// other keys, has the given KID.
+func (u *User) HasKID(kid string) bool {
+	keys := u.PublicKeys
+	if keys.Primary != nil && strings.EqualFold(keys.Primary.KeyID, kid) {
+		return true
+	}
+	for _, kids := range [][]string{keys.Sibkeys, keys.Subkeys} {
+		for _, other := range kids {
+			if strings.EqualFold(other, kid) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LookupByProof looks up the user who has proved the named account on
+// one of the ProofServices, e.g. LookupByProof("github", "alice") or
+// LookupByProof("dns", "example.com").
+func LookupByProof(service, name string, fields ...string) (u *User, err error) {
+	param, ok := ProofServices[service]
+	if !ok {
+		err = ErrUnknownService
+		return
+	}
+	return lookupFirst(url.Values{param: {name}}, fields)
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLookupServer serves user/lookup like keybase.io, for the users
+// in known. A batch with a name containing "!" is rejected outright.
+func testLookupServer(t *testing.T, known map[string]bool) (batches *int, done func()) {
+	batches = new(int)
+	mu := new(sync.Mutex)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := func(name string) *User {
+			if !known[name] {
+				return nil
+			}
+			u := &User{ID: "id-" + name}
+			u.Basics.Username = name
+			return u
+		}
+
+		resp := map[string]interface{}{"status": &Status{Name: "OK"}}
+		if names := r.URL.Query().Get("usernames"); names != "" {
+			mu.Lock()
+			*batches++
+			mu.Unlock()
+			var them []*User
+			for _, name := range strings.Split(names, ",") {
+				if strings.Contains(name, "!") {
+					resp["status"] = &Status{Code: 100, Name: "INPUT_ERROR", Desc: "bad username"}
+				}
+				them = append(them, user(name))
+			}
+			resp["them"] = them
+		} else if u := user(r.URL.Query().Get("username")); u != nil {
+			resp["them"] = u
+		} else {
+			resp["status"] = &Status{Code: StatusNotFound, Name: "NOT_FOUND", Desc: "user not found"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+
+	oldURL, oldSize := BaseURL, LookupBatchSize
+	BaseURL, LookupBatchSize = srv.URL+"/", 2
+	return batches, func() {
+		BaseURL, LookupBatchSize = oldURL, oldSize
+		srv.Close()
+	}
+}
+
+// TestLookupUsers validates that users are looked up in batches, that
+// names are escaped, and that missing users are reported by name.
+func TestLookupUsers(t *testing.T) {
+	batches, done := testLookupServer(t, map[string]bool{"alice": true, "bob": true, "c&d": true, "eve": true})
+	defer done()
+
+	names := []string{"alice", "bob", "c&d", "nobody", "eve"}
+	results := LookupUsers(names, nil)
+	if *batches != 3 {
+		t.Fatalf("expected 3 batches, made %d", *batches)
+	} else if len(results) != len(names) {
+		t.Fatalf("expected %d results, got %d", len(names), len(results))
+	}
+	for i, result := range results {
+		if result.Query != names[i] {
+			t.Fatalf("result %d is for %s, expected %s", i, result.Query, names[i])
+		} else if names[i] == "nobody" {
+			if result.Err != ErrUserNotFound || !IsNotFound(result.Err) {
+				t.Fatalf("missing user gave %v", result.Err)
+			}
+		} else if result.Err != nil {
+			t.Fatalf("%v", result.Err)
+		} else if result.User.Basics.Username != names[i] {
+			t.Fatalf("looked up %s, got %s", names[i], result.User.Basics.Username)
+		}
+	}
+}
+
+// TestLookupUsersRejected validates that when the API rejects a batch,
+// its names are looked up one at a time so only the bad one fails.
+func TestLookupUsersRejected(t *testing.T) {
+	_, done := testLookupServer(t, map[string]bool{"alice": true, "bob": true})
+	defer done()
+
+	results := LookupUsers([]string{"alice", "b!", "bob"}, nil)
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Fatal("good names failed with a bad one")
+	} else if !IsNotFound(results[1].Err) {
+		t.Fatalf("bad name gave %v", results[1].Err)
+	}
+}
+
+// TestLookupAll validates that LookupAll keeps its results in order
+// and runs no more than the given number of lookups at once.
+func TestLookupAll(t *testing.T) {
+	var mu sync.Mutex
+	var running, most int
+	queries := []string{"a", "b", "c", "d", "e", "f", "g"}
+	results := LookupAll(queries, 3, func(query string) (*User, error) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		u := new(User)
+		u.Basics.Username = query
+		return u, nil
+	})
+
+	if most > 3 {
+		t.Fatalf("%d lookups ran at once", most)
+	}
+	for i, result := range results {
+		if result.Query != queries[i] || result.User.Basics.Username != queries[i] {
+			t.Fatalf("result %d is for %s", i, result.Query)
+		}
+	}
+}
+
+// testKeyServer serves lookups by fingerprint, domain and github, and
+// key/fetch, for a single user "alice" with the KID 0101ab.
+func testKeyServer(t *testing.T) (queries *[]string, done func()) {
+	queries = new([]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*queries = append(*queries, r.URL.Path+"?"+r.URL.RawQuery)
+		alice := &User{ID: "id-alice"}
+		alice.Basics.Username = "alice"
+		alice.PublicKeys.Primary = &Key{KeyID: "0101ab"}
+
+		q := r.URL.Query()
+		resp := map[string]interface{}{"status": &Status{Name: "OK"}}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "key/fetch.json"):
+			resp["keys"] = []map[string]string{{"kid": q.Get("kids"), "username": "alice"}}
+		case q.Get("username") == "alice":
+			resp["them"] = alice
+		case q.Get("key_fingerprint") != "", q.Get("github") == "alice", q.Get("domain") == "example.com":
+			resp["them"] = []*User{alice}
+		default:
+			resp["them"] = []*User{nil}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+
+	oldURL := BaseURL
+	BaseURL = srv.URL + "/"
+	return queries, func() {
+		BaseURL = oldURL
+		srv.Close()
+	}
+}
+
+// TestLookupByKey validates lookups by fingerprint and KID, and that
+// malformed ones are refused without asking the server.
+func TestLookupByKey(t *testing.T) {
+	queries, done := testKeyServer(t)
+	defer done()
+
+	u, err := LookupByFingerprint("1F72 F8B9 0000 0000 0000 0000 0000 0000 CCAF F8EB")
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if u.Basics.Username != "alice" {
+		t.Fatalf("expected alice, got %s", u.Basics.Username)
+	} else if !strings.Contains((*queries)[0], "key_fingerprint=1f72f8b9") {
+		t.Fatalf("fingerprint wasn't normalised: %s", (*queries)[0])
+	}
+
+	if _, err = LookupByKID("0101AB", "basics"); err != nil {
+		t.Fatalf("%v", err)
+	} else if _, err = LookupByKID("0202cd"); err != ErrKeyMismatch {
+		t.Fatalf("expected a key mismatch, got %v", err)
+	}
+
+	n := len(*queries)
+	if _, err = LookupByFingerprint("1f72f8b9"); err != ErrInvalidFingerprint {
+		t.Fatalf("short fingerprint gave %v", err)
+	} else if _, err = LookupByKID("xyz"); err != ErrInvalidKID {
+		t.Fatalf("bad KID gave %v", err)
+	} else if len(*queries) != n {
+		t.Fatal("invalid lookups were sent to the server")
+	}
+}
+
+// TestLookupByProof validates lookups by proof, including by domain.
+func TestLookupByProof(t *testing.T) {
+	_, done := testKeyServer(t)
+	defer done()
+
+	for _, proof := range [][2]string{{"github", "alice"}, {"dns", "example.com"}, {"web", "example.com"}} {
+		u, err := LookupByProof(proof[0], proof[1])
+		if err != nil {
+			t.Fatalf("%v", err)
+		} else if u.Basics.Username != "alice" {
+			t.Fatalf("expected alice, got %s", u.Basics.Username)
+		}
+	}
+
+	if _, err := LookupByProof("github", "mallory"); err != ErrUserNotFound {
+		t.Fatalf("unknown account gave %v", err)
+	} else if _, err = LookupByProof("myspace", "alice"); err != ErrUnknownService {
+		t.Fatalf("unknown service gave %v", err)
+	}
+}
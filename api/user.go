@@ -1,14 +1,23 @@
 package api
 
+import "fmt"
+
 // User contains information regarding a user.
 type User struct {
-	ID          string          `json:"id"`
-	Basics      Basics          `json:"basics"`
-	Invitations InvitationStats `json:"invitation_stats"`
-	Profile     Profile         `json:"profile"`
-	Emails      Emails          `json:"emails"`
-	PublicKeys  map[string]*Key `json:"public_keys"`
-	PrivateKeys map[string]*Key `json:"private_keys"`
+	ID          string              `json:"id"`
+	Basics      Basics              `json:"basics"`
+	Invitations InvitationStats     `json:"invitation_stats"`
+	Profile     Profile             `json:"profile"`
+	Emails      Emails              `json:"emails"`
+	PublicKeys  PublicKeys          `json:"public_keys"`
+	PrivateKeys map[string]*Key     `json:"private_keys"`
+	Proofs      ProofsSummary       `json:"proofs_summary"`
+	Pictures    map[string]*Picture `json:"pictures"`
+	Sigs        Sigs                `json:"sigs"`
+
+	// CryptoAddresses maps a currency, e.g. bitcoin, to the
+	// user's addresses.
+	CryptoAddresses map[string][]*CryptoAddress `json:"cryptocurrency_addresses"`
 }
 
 // Basics contain basic information about the user.
@@ -68,4 +77,94 @@ type Key struct {
 	Bundle      string  `json:"bundle"`
 	Modified    int     `json:"mtime"`
 	Created     int     `json:"ctime"`
+	Expires     int     `json:"etime"` // 0 if the key doesn't expire
+	Bits        int     `json:"key_bits"`
+	Algorithm   int     `json:"key_algo"` // the OpenPGP public key algorithm
+}
+
+// PublicKeys contains a user's public keys: the primary key, and the
+// armoured bundles of every key on the account.
+type PublicKeys struct {
+	Primary       *Key     `json:"primary"`
+	AllBundles    []string `json:"all_bundles"`
+	PGPPublicKeys []string `json:"pgp_public_keys"`
+	Sibkeys       []string `json:"sibkeys"` // KIDs
+	Subkeys       []string `json:"subkeys"`
+}
+
+// Sigs summarises a user's signature chain.
+type Sigs struct {
+	Last *SigchainLink `json:"last"` // nil if the chain is empty
+}
+
+// A SigchainLink identifies a link in a user's signature chain.
+type SigchainLink struct {
+	SeqNo       int    `json:"seqno"`
+	PayloadHash string `json:"payload_hash"`
+	SigID       string `json:"sig_id"`
+}
+
+// ProofsSummary contains a user's proofs of their accounts elsewhere.
+type ProofsSummary struct {
+	All []*RemoteProof `json:"all"`
+}
+
+// These constants are the states of a remote proof, as last checked
+// by keybase.io.
+const (
+	ProofStateNone        = 0
+	ProofStateOK          = 1
+	ProofStateTempFailure = 2
+	ProofStatePermFailure = 3
+	ProofStateLooking     = 4
+	ProofStateSuperseded  = 5
+	ProofStatePosted      = 6
+	ProofStateRevoked     = 7
+	ProofStateDeleted     = 8
+)
+
+var proofStates = map[int]string{
+	ProofStateNone:        "none",
+	ProofStateOK:          "ok",
+	ProofStateTempFailure: "temporary failure",
+	ProofStatePermFailure: "failed",
+	ProofStateLooking:     "checking",
+	ProofStateSuperseded:  "superseded",
+	ProofStatePosted:      "posted",
+	ProofStateRevoked:     "revoked",
+	ProofStateDeleted:     "deleted",
+}
+
+// A RemoteProof is a user's proof of an account on another service,
+// such as Twitter, GitHub, a website or a DNS domain.
+type RemoteProof struct {
+	Type       string `json:"proof_type"` // e.g. twitter, github, generic_web_site or dns
+	Nametag    string `json:"nametag"`    // the username or domain on the service
+	State      int    `json:"state"`
+	ServiceURL string `json:"service_url"`
+	ProofURL   string `json:"proof_url"`
+	HumanURL   string `json:"human_url"`
+	SigID      string `json:"sig_id"`
+	ProofID    string `json:"proof_id"`
+}
+
+// StateName describes the proof's state, e.g. "ok" or "revoked".
+func (p *RemoteProof) StateName() string {
+	if name, ok := proofStates[p.State]; ok {
+		return name
+	}
+	return fmt.Sprintf("state %d", p.State)
+}
+
+// A Picture is a user's profile picture.
+type Picture struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// A CryptoAddress is a cryptocurrency address that a user has signed.
+type CryptoAddress struct {
+	Address string `json:"address"`
+	SigID   string `json:"sig_id"`
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gokyle/keybase/api"
+	"github.com/gokyle/keybase/openpgp"
+	"github.com/gokyle/keybase/qr"
+)
+
+// backupKey writes the secret parts of the selected key as a paper
+// key: as numbered, checksummed base16 lines ("text"), in binary
+// ("raw"), or as a QR code on the terminal followed by the text
+// ("qr"). Text and raw backups go to outFile if given.
+func backupKey(selector, format, outFile string) {
+	secRing := loadSecRingOrExit()
+	e, err := secRing.Find(selector)
+	if err != nil {
+		failErr(err, "Backup failed")
+	}
+	fpr := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
+	data, err := secRing.PaperKey(fpr)
+	if err != nil {
+		failErr(err, "Backup failed")
+	}
+
+	var out []byte
+	switch format {
+	case "raw":
+		if outFile == "" || jsonOutput && outFile == "-" {
+			fail(exitUsage, "Raw backups are binary; please specify an output file with -out.")
+		}
+		out = data
+	case "text", "qr":
+		text, err := openpgp.EncodePaperKey(data)
+		if err != nil {
+			failErr(err, "Backup failed")
+		}
+		out = []byte(text)
+	default:
+		fail(exitUsage, "Unknown backup format %s; use text, raw or qr.", format)
+	}
+
+	if format == "qr" && !jsonOutput {
+		code, err := qr.Encode(data, qr.M)
+		if err == qr.ErrTooLarge {
+			code, err = qr.Encode(data, qr.L)
+		}
+		if err != nil {
+			failErr(err, "Couldn't draw the QR code")
+		}
+		fmt.Print(code.Terminal(false))
+		fmt.Println()
+	}
+
+	doc := struct {
+		Fingerprint string `json:"fingerprint"`
+		Format      string `json:"format"`
+		PaperKey    string `json:"paper_key,omitempty"`
+		File        string `json:"file,omitempty"`
+	}{Fingerprint: fpr, Format: format}
+	if outFile == "" || outFile == "-" {
+		if !jsonOutput {
+			os.Stdout.Write(out)
+			return
+		}
+		doc.PaperKey = string(out)
+	} else {
+		err = ioutil.WriteFile(outFile, out, 0600)
+		if err != nil {
+			failErr(err, "Couldn't write the backup")
+		}
+		doc.File = outFile
+		info("Wrote the backup to %s.\n", outFile)
+	}
+	if jsonOutput {
+		emit(doc)
+	}
+}
+
+// restorePublicKey finds the public key a paper key was made from: in
+// pubFile if given, then in the public keyring, and finally on the
+// named keybase user's account. Only the last needs the network.
+func restorePublicKey(fpr, pubFile, name string) (armoured string, err error) {
+	if pubFile != "" {
+		var data []byte
+		data, err = ioutil.ReadFile(pubFile)
+		armoured = string(data)
+		return
+	}
+
+	pubRing, err := openpgp.LoadKeyRing(openpgp.PubRingPath)
+	if err == nil && pubRing.Entity(fpr) != nil {
+		return pubRing.Export(fpr)
+	} else if name == "" {
+		err = &statusError{exitNotFound, strings.ToUpper(fpr) + " isn't in the public keyring; give a keybase user or -pub"}
+		return
+	}
+
+	user, err := api.LookupUser(name)
+	if err != nil {
+		return
+	}
+	pub := user.PublicKeys.Primary
+	if pub == nil || pub.Bundle == "" {
+		err = errNoPublicKey(name)
+		return
+	} else if !strings.EqualFold(pub.Fingerprint, fpr) {
+		err = &statusError{exitVerify, name + "'s key on keybase.io isn't the one that was backed up"}
+		return
+	}
+	armoured = pub.Bundle
+	return
+}
+
+// restoreKey reads a paper key from inFile (or standard input),
+// recombines it with its public key and imports the secret key, or
+// writes it to outFile if given.
+func restoreKey(inFile, name, pubFile, outFile string) {
+	text, err := readInput(inFile)
+	if err != nil {
+		failErr(err, "Couldn't read the backup")
+	}
+
+	data, err := openpgp.DecodePaperKey(text)
+	if err != nil {
+		failErr(err, "Couldn't read the backup")
+	}
+	fpr, err := openpgp.PaperKeyFingerprint(data)
+	if err != nil {
+		failErr(err, "Couldn't read the backup")
+	}
+
+	pub, err := restorePublicKey(fpr, pubFile, name)
+	if err != nil {
+		failErr(err, "Couldn't find the public key")
+	}
+	armoured, err := openpgp.RestorePaperKey(data, pub)
+	if err != nil {
+		failErr(err, "Restore failed")
+	}
+
+	doc := struct {
+		Fingerprint string         `json:"fingerprint"`
+		Imported    []*importedKey `json:"imported,omitempty"`
+		Key         string         `json:"key,omitempty"`
+		File        string         `json:"file,omitempty"`
+	}{Fingerprint: fpr}
+	switch outFile {
+	case "":
+		doc.Imported = importArmoured([]byte(armoured))
+		if !jsonOutput {
+			printImported(doc.Imported)
+		}
+	case "-":
+		if !jsonOutput {
+			fmt.Println(armoured)
+			return
+		}
+		doc.Key = armoured
+	default:
+		err = ioutil.WriteFile(outFile, []byte(armoured+"\n"), 0600)
+		if err != nil {
+			failErr(err, "Couldn't write the key")
+		}
+		doc.File = outFile
+		info("Wrote the restored key to %s.\n", outFile)
+	}
+	if jsonOutput {
+		emit(doc)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gokyle/keybase/api"
+)
+
+func printCacheStats(stats *api.CacheStats) {
+	fmt.Printf("Cache directory: %s\n", stats.Dir)
+	fmt.Printf("\t%d users (%d fresh), found by %d lookups\n", stats.Users, stats.FreshUsers, stats.Lookups)
+	fmt.Printf("\t%d key bundles (%d fresh)\n", stats.Keys, stats.FreshKeys)
+	fmt.Printf("\t%d bytes\n", stats.Bytes)
+}
+
+func cacheStatsCommand() *command {
+	cmd := newCommand("cache stats", "", 0, 0,
+		"describe the lookup cache",
+		`Stats prints how many users and key bundles are in the cache, how
+many of them are fresh enough to be used without asking keybase.io
+(see the cache_ttl and cache_key_ttl settings), and how much space
+they take up.`)
+	cmd.run = func(args []string) {
+		stats, err := api.UserCache.Stats()
+		if err != nil {
+			failErr(err, "Couldn't read the cache")
+		}
+		if jsonOutput {
+			emit(stats)
+			return
+		}
+		printCacheStats(stats)
+	}
+	return cmd
+}
+
+func cacheClearCommand() *command {
+	cmd := newCommand("cache clear", "", 0, 0,
+		"empty the lookup cache",
+		`Clear removes every cached user and key bundle, so the next lookup
+of each asks keybase.io.`)
+	cmd.run = func(args []string) {
+		stats, err := api.UserCache.Clear()
+		if err != nil {
+			failErr(err, "Couldn't clear the cache")
+		}
+		if jsonOutput {
+			emit(stats)
+			return
+		}
+		fmt.Printf("Removed %d users and %d key bundles from %s.\n", stats.Users, stats.Keys, stats.Dir)
+	}
+	return cmd
+}
@@ -0,0 +1,719 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gokyle/keybase/api"
+	"github.com/gokyle/keybase/openpgp"
+)
+
+// A command is a keybase subcommand. Each has its own flags, which may
+// be given anywhere after its name.
+type command struct {
+	name    string // e.g. "keys export"
+	args    string // the arguments, for the usage line
+	short   string // a one line description for the command list
+	long    string // the help text
+	minArgs int
+	maxArgs int // -1 for no limit
+	flags   *flag.FlagSet
+	run     func(args []string)
+}
+
+func newCommand(name, args string, minArgs, maxArgs int, short, long string) *command {
+	cmd := &command{
+		name:    name,
+		args:    args,
+		short:   short,
+		long:    long,
+		minArgs: minArgs,
+		maxArgs: maxArgs,
+		flags:   flag.NewFlagSet(name, flag.ContinueOnError),
+	}
+	cmd.flags.SetOutput(os.Stdout)
+	cmd.flags.Usage = func() {}
+	return cmd
+}
+
+// commands holds every command; commandList is the one place commands
+// are added.
+var commands []*command
+
+func commandList() []*command {
+	return []*command{
+		lookupCommand(),
+		fetchCommand(),
+		sshAuthorizedKeysCommand(),
+		watchCommand(),
+		syncCommand(),
+		keysListCommand(),
+		keysImportCommand(),
+		keysExportCommand(),
+		keysDeleteCommand(),
+		genkeyCommand(),
+		signCommand(),
+		certifyCommand(),
+		revokeKeyCommand(),
+		backupCommand(),
+		restoreCommand(),
+		testloginCommand(),
+		uploadCommand(),
+		deleteCommand(),
+		authCommand(),
+		nextseqCommand(),
+		authtwitCommand(),
+		configGetCommand(),
+		configSetCommand(),
+		configListCommand(),
+		cacheStatsCommand(),
+		cacheClearCommand(),
+		pinListCommand(),
+		pinAcceptCommand(),
+		helpCommand(),
+	}
+}
+
+// userFlag adds the -u flag used by commands that log in, which
+// defaults to the configured user.
+func userFlag(fs *flag.FlagSet) *string {
+	return fs.String("u", cfg.value("user"), "keybase.io `username` or email to log in as")
+}
+
+// signingKey returns the key selector given as an argument, or the
+// configured signing key.
+func signingKey(selector string) string {
+	if selector == "" {
+		selector = cfg.value("signing_key")
+	}
+	return selector
+}
+
+// usageLine returns the command's usage, e.g.
+// "keybase sign [flags] <key> [file]".
+func (cmd *command) usageLine() string {
+	line := "keybase " + cmd.name
+	hasFlags := false
+	cmd.flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		line += " [flags]"
+	}
+	if cmd.args != "" {
+		line += " " + cmd.args
+	}
+	return line
+}
+
+// help prints the command's usage, help text and flags.
+func (cmd *command) help() {
+	if jsonOutput {
+		emit(cmd.doc())
+		return
+	}
+	fmt.Printf("Usage: %s\n\n", cmd.usageLine())
+	fmt.Println(strings.TrimSpace(cmd.long))
+	hasFlags := false
+	cmd.flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Println("\nFlags:")
+		cmd.flags.PrintDefaults()
+	}
+}
+
+// A commandDoc describes a command in JSON help.
+type commandDoc struct {
+	Name        string     `json:"name"`
+	Usage       string     `json:"usage"`
+	Summary     string     `json:"summary"`
+	Description string     `json:"description,omitempty"`
+	Flags       []*flagDoc `json:"flags,omitempty"`
+}
+
+type flagDoc struct {
+	Name    string `json:"name"`
+	Default string `json:"default"`
+	Usage   string `json:"usage"`
+}
+
+func (cmd *command) doc() *commandDoc {
+	doc := &commandDoc{
+		Name:        cmd.name,
+		Usage:       cmd.usageLine(),
+		Summary:     cmd.short,
+		Description: strings.TrimSpace(cmd.long),
+	}
+	cmd.flags.VisitAll(func(f *flag.Flag) {
+		_, usage := flag.UnquoteUsage(f)
+		doc.Flags = append(doc.Flags, &flagDoc{f.Name, f.DefValue, usage})
+	})
+	return doc
+}
+
+// parseArgs parses the command's flags, which may come before, after or
+// between its arguments; arguments after "--" aren't read as flags.
+func (cmd *command) parseArgs(args []string) (positional []string, err error) {
+	for {
+		err = cmd.flags.Parse(args)
+		if err != nil {
+			return
+		}
+		rest := cmd.flags.Args()
+		if len(rest) == 0 {
+			return
+		}
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			positional = append(positional, rest...)
+			return
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// argCountOK returns true if the command takes n arguments.
+func (cmd *command) argCountOK(n int) bool {
+	return n >= cmd.minArgs && (cmd.maxArgs < 0 || n <= cmd.maxArgs)
+}
+
+// findCommand returns the command named by the start of args, and the
+// arguments that follow its name.
+func findCommand(args []string) (cmd *command, rest []string) {
+	if len(args) >= 2 {
+		for _, c := range commands {
+			if c.name == args[0]+" "+args[1] {
+				return c, args[2:]
+			}
+		}
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c, args[1:]
+		}
+	}
+	return nil, nil
+}
+
+// printCommands lists the commands whose names start with prefix.
+func printCommands(prefix string) {
+	if jsonOutput {
+		doc := struct {
+			Commands []*commandDoc `json:"commands"`
+		}{}
+		for _, cmd := range commands {
+			if strings.HasPrefix(cmd.name, prefix) {
+				doc.Commands = append(doc.Commands, &commandDoc{Name: cmd.name, Usage: cmd.usageLine(), Summary: cmd.short})
+			}
+		}
+		emit(doc)
+		return
+	}
+	fmt.Println("Commands:")
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd.name, prefix) {
+			fmt.Printf("\t%-20s %s\n", cmd.name, cmd.short)
+		}
+	}
+	fmt.Println("\nRun 'keybase help <command>' for a command's arguments and flags.")
+}
+
+// isGroup returns true if name is the first word of commands such as
+// "keys list".
+func isGroup(name string) bool {
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd.name, name+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// usageError reports a command line mistake and exits with exitUsage,
+// listing the commands starting with prefix unless -json was given.
+func usageError(prefix, format string, args ...interface{}) {
+	if jsonOutput {
+		fail(exitUsage, format, args...)
+	}
+	if format != "" {
+		fmt.Printf(format+"\n", args...)
+	}
+	printCommands(prefix)
+	os.Exit(exitUsage)
+}
+
+// runCommand runs the command named by args, exiting with exitUsage if
+// there's no such command or it's used wrongly.
+func runCommand(args []string) {
+	if len(args) == 0 {
+		usageError("", "No command specified.")
+	}
+
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		if !isGroup(args[0]) {
+			usageError("", "Unknown command %s.", args[0])
+		} else if len(args) > 1 {
+			usageError(args[0]+" ", "Unknown %s command %s.", args[0], args[1])
+		} else if jsonOutput {
+			fail(exitUsage, "No %s command specified.", args[0])
+		}
+		usageError(args[0]+" ", "")
+	}
+
+	positional, err := cmd.parseArgs(rest)
+	if err == flag.ErrHelp {
+		cmd.help()
+		return
+	} else if err != nil {
+		fail(exitUsage, "Usage: %s", cmd.usageLine())
+	}
+	if !cmd.argCountOK(len(positional)) {
+		fail(exitUsage, "Usage: %s\nRun 'keybase help %s' for more information.", cmd.usageLine(), cmd.name)
+	}
+	cmd.run(positional)
+}
+
+// arg returns the i'th argument, or "" if there are fewer.
+func arg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// loginOrExit logs in as user, exiting if that fails.
+func loginOrExit(user string) *api.Session {
+	session, err := login(user)
+	if err != nil {
+		failErr(err, "Login failed")
+	}
+	info("Logged in as %s.\n", session.User.Basics.Username)
+	return session
+}
+
+// loadSecRingOrExit loads the secret keyring, exiting if it can't be
+// read.
+func loadSecRingOrExit() *openpgp.KeyRing {
+	secRing, err := openpgp.LoadKeyRing(openpgp.SecRingPath)
+	if err != nil {
+		failErr(err, "Failed to load GnuPG secret keyring")
+	}
+	return secRing
+}
+
+func lookupCommand() *command {
+	cmd := newCommand("lookup", "<users...>", 1, -1,
+		"print information about keybase users",
+		`Lookup prints information about each of the users: their profile,
+the fingerprints and expiry of their public keys (but not the keys
+themselves), and their proofs of other accounts with the state
+keybase.io last found them in. -fields fetches only some of these,
+as a comma-separated list of basics, profile, public_keys,
+proofs_summary, cryptocurrency_addresses, pictures and sigs (the
+tail of their signature chain).
+
+Users may be given by username, or by one of their keys as
+fingerprint:<fingerprint> or kid:<kid>, or by an account they've
+proved as twitter:, github:, reddit:, hackernews:, coinbase:, dns: or
+web: followed by the username or domain. The user found must have the
+key or proof.
+
+The first time a user is looked up, their key and signature chain
+are pinned; after that, lookup fails if keybase.io serves a different
+key, or a chain that's gone back or been rewritten, until the change
+is accepted with 'keybase pin accept'.`)
+	fieldList := cmd.flags.String("fields", "", "comma-separated `fields` to fetch")
+	cmd.run = func(args []string) {
+		fields, err := checkFields(*fieldList)
+		if err != nil {
+			fail(exitUsage, "Invalid -fields: %v.", err)
+		}
+		for _, arg := range args {
+			if err = checkLookup(arg); err != nil {
+				fail(exitUsage, "Invalid user: %v.", err)
+			}
+		}
+		lookup(args, fields)
+	}
+	return cmd
+}
+
+func fetchCommand() *command {
+	cmd := newCommand("fetch", "<user>", 1, 1,
+		"download a user's public key",
+		`Fetch downloads the user's public key, and saves it in the file
+given by -out, or <user>.pub. If the output file is "-", the key is
+printed to standard output. As with lookup, the key is pinned the
+first time, and fetch fails if it changes.`)
+	outFile := cmd.flags.String("out", "", "`file` to save the key in")
+	cmd.run = func(args []string) {
+		out := *outFile
+		if out == "" {
+			out = args[0] + ".pub"
+		}
+		fetchKey(args[0], out)
+	}
+	return cmd
+}
+
+func sshAuthorizedKeysCommand() *command {
+	cmd := newCommand("ssh authorized-keys", "<users...>", 1, -1,
+		"print an authorized_keys file for keybase users",
+		`Authorized-keys fetches each user's public key, checks it against
+the fingerprint on their account and their pin, and prints an
+authorized_keys file
+with a line for each of their OpenPGP authentication keys (RSA, ECDSA
+or Ed25519), annotated with their keybase username and the key's
+fingerprint. Revoked and expired keys are left out. Users whose keys
+can't be verified are skipped with a warning, and the command exits
+with an error after writing the rest.`)
+	outFile := cmd.flags.String("out", "", "write the authorized_keys to `file`")
+	cmd.run = func(args []string) {
+		authorizedKeys(args, *outFile)
+	}
+	return cmd
+}
+
+func keysListCommand() *command {
+	cmd := newCommand("keys list", "", 0, 0,
+		"list the keys in the keyrings",
+		`List lists the keys in the public keyring, or the secret keyring
+with -secret, in the style of gpg --list-keys. If -u is given, that
+user's keybase key is marked.`)
+	secret := cmd.flags.Bool("secret", false, "list the secret keyring")
+	user := cmd.flags.String("u", cfg.value("user"), "mark this keybase `user`'s key")
+	cmd.run = func(args []string) {
+		listKeys(*secret, *user)
+	}
+	return cmd
+}
+
+func keysImportCommand() *command {
+	cmd := newCommand("keys import", "<file|->", 1, 1,
+		"import keys into the keyrings",
+		`Import imports the armoured keys in a file (or "-" for standard
+input). Secret keys go into the secret keyring, and their public keys
+into the public keyring. Revocation certificates are applied to the
+keys they revoke.`)
+	cmd.run = func(args []string) {
+		importKeys(args[0])
+	}
+	return cmd
+}
+
+func keysExportCommand() *command {
+	cmd := newCommand("keys export", "<key>", 1, 1,
+		"export a key from the keyrings",
+		`Export prints the selected public key, or secret key with -secret,
+or writes it to the file given by -out.`)
+	secret := cmd.flags.Bool("secret", false, "export the secret key")
+	outFile := cmd.flags.String("out", "", "write the key to `file`")
+	cmd.run = func(args []string) {
+		exportKey(args[0], *secret, *outFile)
+	}
+	return cmd
+}
+
+func keysDeleteCommand() *command {
+	cmd := newCommand("keys delete", "<key>", 1, 1,
+		"delete a key from the keyrings",
+		`Delete removes the selected key, and its secret key, after asking
+for confirmation.`)
+	cmd.run = func(args []string) {
+		deleteLocalKey(args[0])
+	}
+	return cmd
+}
+
+func genkeyCommand() *command {
+	cmd := newCommand("genkey", "", 0, 0,
+		"generate a new key",
+		`Genkey generates a new key, protected by a passphrase, and writes
+the secret key to the file given by -out, the public key to -pub (or
+the -out file with .pub added) and a revocation certificate beside
+them. With -import the key is added to the keyrings, and with -upload
+the public key is uploaded to keybase.io.`)
+	outFile := cmd.flags.String("out", "", "write the secret key to `file` (required)")
+	pubFile := cmd.flags.String("pub", "", "write the public key to `file`")
+	algo := cmd.flags.String("algo", openpgp.AlgoRSA, "key algorithm (rsa or ecc)")
+	bits := cmd.flags.Int("bits", openpgp.DefaultRSABits, "RSA key size (2048, 3072 or 4096)")
+	curve := cmd.flags.String("curve", "P-256", "ECC curve (P-256, P-384 or P-521)")
+	expire := cmd.flags.String("expire", "", "key `lifetime`, e.g. 2y, 6m or 30d")
+	comment := cmd.flags.String("comment", "", "user ID comment")
+	doImport := cmd.flags.Bool("import", false, "import the new key into the keyrings")
+	upload := cmd.flags.Bool("upload", false, "upload the new public key to keybase.io")
+	user := userFlag(cmd.flags)
+	cmd.run = func(args []string) {
+		if *outFile == "" {
+			fail(exitUsage, "Please specify an output file with -out.")
+		}
+
+		lifetime, err := parseLifetime(*expire)
+		if err != nil {
+			fail(exitUsage, "Invalid expiry: %v", err)
+		}
+
+		newKey(*outFile, &keyOptions{
+			KeyOptions: openpgp.KeyOptions{
+				Comment:   *comment,
+				Algorithm: *algo,
+				Bits:      *bits,
+				Curve:     *curve,
+				Lifetime:  lifetime,
+			},
+			pubFile:  *pubFile,
+			doImport: *doImport,
+			upload:   *upload,
+			user:     *user,
+		})
+	}
+	return cmd
+}
+
+func signCommand() *command {
+	cmd := newCommand("sign", "[key] [file]", 0, 2,
+		"sign a file",
+		`Sign signs the file (or standard input) with the selected key, or
+the configured signing_key if none is given, writing the signed
+message to -out or standard output.`)
+	outFile := cmd.flags.String("out", "", "write the signed message to `file`")
+	force := cmd.flags.Bool("force", false, "sign with an expired or revoked key")
+	cmd.run = func(args []string) {
+		selector := signingKey(arg(args, 0))
+		if selector == "" {
+			fail(exitUsage, "No key given, and no signing_key is configured.")
+		}
+		signMessage(selector, arg(args, 1), *outFile, *force)
+	}
+	return cmd
+}
+
+func certifyCommand() *command {
+	cmd := newCommand("certify", "<user> [key]", 1, 2,
+		"certify another user's key",
+		`Certify fetches a user's key, checks that it matches the
+fingerprint on their keybase account, and after confirmation
+certifies its user IDs with your secret key (the one given after the
+user name, or the configured signing_key, or your own keybase key if
+-u is given). The certified
+key is stored in the public keyring, and written to -out if given so
+its owner can publish it.`)
+	opts := new(certifyOptions)
+	cmd.flags.IntVar(&opts.level, "level", openpgp.CertGeneric, "certification `level`, from 0 (generic) to 3 (positive)")
+	cmd.flags.StringVar(&opts.user, "u", cfg.value("user"), "certify with this keybase `user`'s key")
+	cmd.flags.StringVar(&opts.outFile, "out", "", "write the certified key to `file`")
+	cmd.flags.BoolVar(&opts.force, "force", false, "certify with an expired or revoked key, or certify one")
+	cmd.run = func(args []string) {
+		opts.signer = signingKey(arg(args, 1))
+		certifyUser(args[0], opts)
+	}
+	return cmd
+}
+
+func revokeKeyCommand() *command {
+	cmd := newCommand("revoke-key", "<key> [text]", 1, 2,
+		"revoke a key",
+		`Revoke-key revokes the selected secret key in both keyrings after
+asking for confirmation. The text after the key explains the
+revocation. The revocation certificate is written to -out if given,
+and -upload publishes the revoked key to keybase.io.`)
+	opts := new(revokeOptions)
+	cmd.flags.StringVar(&opts.reason, "reason", "none", "`reason` (none, superseded, compromised or retired)")
+	cmd.flags.StringVar(&opts.outFile, "out", "", "write the revocation certificate to `file`")
+	cmd.flags.BoolVar(&opts.upload, "upload", false, "upload the revoked public key to keybase.io")
+	cmd.flags.StringVar(&opts.user, "u", cfg.value("user"), "keybase.io `username` or email to log in as")
+	cmd.run = func(args []string) {
+		opts.text = arg(args, 1)
+		revokeKey(args[0], opts)
+	}
+	return cmd
+}
+
+func backupCommand() *command {
+	cmd := newCommand("backup", "<key>", 1, 1,
+		"back up a secret key on paper",
+		`Backup writes the secret parts of the selected key as a paper key,
+in the format used by paperkey: numbered lines of base16 with a
+checksum on each line and one over the whole key, ready to print.
+The secret key stays protected by its passphrase.`)
+	format := cmd.flags.String("format", "text", "paper key `format`: text, raw (binary) or qr (a QR code on the terminal, then the text)")
+	outFile := cmd.flags.String("out", "", "write the backup to `file`")
+	cmd.run = func(args []string) {
+		backupKey(args[0], *format, *outFile)
+	}
+	return cmd
+}
+
+func restoreCommand() *command {
+	cmd := newCommand("restore", "<file|-> [user]", 1, 2,
+		"restore a secret key from a paper backup",
+		`Restore reads a paper key (text or binary, from a file or "-" for
+standard input), recombines it with its public key and imports the
+secret key, or writes it to -out. The public key comes from the file
+given by -pub, or the public keyring; if neither has it, the keybase
+user given after the file is asked for it. Restoring from either of
+the first two works offline.`)
+	pubFile := cmd.flags.String("pub", "", "read the public key from `file`")
+	outFile := cmd.flags.String("out", "", "write the restored key to `file` instead of importing it")
+	cmd.run = func(args []string) {
+		restoreKey(args[0], arg(args, 1), *pubFile, *outFile)
+	}
+	return cmd
+}
+
+func testloginCommand() *command {
+	cmd := newCommand("testlogin", "", 0, 0,
+		"check that logging in works",
+		`Testlogin logs in and prints the session tokens. Your password is
+read from the terminal.`)
+	user := userFlag(cmd.flags)
+	cmd.run = func(args []string) {
+		session := loginOrExit(*user)
+		if jsonOutput {
+			emit(struct {
+				Username  string `json:"username"`
+				UID       string `json:"uid"`
+				Session   string `json:"session"`
+				CSRFToken string `json:"csrf_token"`
+			}{session.User.Basics.Username, session.UID, session.Session, session.Token})
+			return
+		}
+		fmt.Printf("Session token: %s\n", session.Session)
+		fmt.Printf("CSRF token: %s\n", session.Token)
+	}
+	return cmd
+}
+
+func uploadCommand() *command {
+	cmd := newCommand("upload", "[key]", 0, 1,
+		"upload a public key to your account",
+		`Upload uploads the selected public key from the public keyring, or
+the armoured public key in the file given by -pub, to your account,
+replacing any key already there.`)
+	pubFile := cmd.flags.String("pub", "", "upload the armoured public key in `file`")
+	force := cmd.flags.Bool("force", false, "upload an expired or revoked key")
+	user := userFlag(cmd.flags)
+	cmd.run = func(args []string) {
+		uploadKey(arg(args, 0), *pubFile, *user, *force)
+	}
+	return cmd
+}
+
+func deleteCommand() *command {
+	cmd := newCommand("delete", "", 0, 0,
+		"remove the public key from your account",
+		`Delete removes your public key from your account.`)
+	user := userFlag(cmd.flags)
+	cmd.run = func(args []string) {
+		deleteKey(loginOrExit(*user))
+	}
+	return cmd
+}
+
+func authCommand() *command {
+	cmd := newCommand("auth", "[key]", 0, 1,
+		"authenticate with a signature",
+		`Auth signs keybase's authentication challenge with the selected
+secret key, the configured signing_key or your account's key, and
+prints the authentication token.`)
+	force := cmd.flags.Bool("force", false, "sign with an expired or revoked key")
+	user := userFlag(cmd.flags)
+	cmd.run = func(args []string) {
+		secRing := loadSecRingOrExit()
+		session := loginOrExit(*user)
+		secRing.Force = *force
+		postAuth(session, secRing, signingKey(arg(args, 0)))
+	}
+	return cmd
+}
+
+func nextseqCommand() *command {
+	cmd := newCommand("nextseq", "", 0, 0,
+		"print your signature chain's next sequence number",
+		`Nextseq prints the sequence number and previous hash for the next
+link in your signature chain.`)
+	user := userFlag(cmd.flags)
+	cmd.run = func(args []string) {
+		nextSeq(loginOrExit(*user))
+	}
+	return cmd
+}
+
+func authtwitCommand() *command {
+	cmd := newCommand("authtwit", "", 0, 0,
+		"prove a Twitter account",
+		`Authtwit signs a proof of your Twitter account with your account's
+key, for you to tweet.`)
+	user := userFlag(cmd.flags)
+	cmd.run = func(args []string) {
+		secRing := loadSecRingOrExit()
+		authTwitter(loginOrExit(*user), secRing)
+	}
+	return cmd
+}
+
+func helpCommand() *command {
+	cmd := newCommand("help", "[command]", 0, 2,
+		"describe a command",
+		`Help lists the commands, or describes the arguments and flags of
+the named command.`)
+	cmd.run = func(args []string) {
+		if len(args) == 0 {
+			printCommands("")
+			return
+		}
+		c, rest := findCommand(args)
+		if c == nil || len(rest) > 0 {
+			if isGroup(args[0]) && len(args) == 1 {
+				printCommands(args[0] + " ")
+				return
+			}
+			fail(exitUsage, "Unknown command %s.", strings.Join(args, " "))
+		}
+		c.help()
+	}
+	return cmd
+}
+
+// uploadKey uploads the selected key from the public keyring, or the
+// key in pubFile, to user's account.
+func uploadKey(selector, pubFile, user string, force bool) {
+	var armoured string
+	if pubFile == "" {
+		if selector == "" {
+			fail(exitUsage, "No file specified (with -pub) and no key specified.\nCowardly refusing to proceed.")
+		}
+
+		pubRing, err := openpgp.LoadKeyRing(openpgp.PubRingPath)
+		if err != nil {
+			failErr(err, "Couldn't open public keyring")
+		}
+		pubRing.Force = force
+		armoured, err = pubRing.ExportForUpload(selector)
+		if err != nil {
+			failErr(err, "Can't export the key")
+		}
+	} else {
+		pub, err := ioutil.ReadFile(pubFile)
+		if err != nil {
+			failErr(err, "Failed to read the public key")
+		}
+		armoured = string(pub)
+		checkUpload(armoured, force)
+	}
+
+	session := loginOrExit(user)
+	kid, err := session.AddKey(armoured)
+	if err != nil {
+		failErr(err, "Upload failed")
+	}
+	if jsonOutput {
+		emit(struct {
+			KID string `json:"kid"`
+		}{kid})
+		return
+	}
+	fmt.Printf("Successfully uploaded new key with ID %s.\n", kid)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseArgs validates that flags are read wherever they appear
+// among the arguments, and that nothing after "--" is read as a flag.
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args       string
+		positional []string
+		verbose    bool
+		name       string
+	}{
+		{"", nil, false, ""},
+		{"a b", []string{"a", "b"}, false, ""},
+		{"-v a", []string{"a"}, true, ""},
+		{"a -v", []string{"a"}, true, ""},
+		{"a -name x b", []string{"a", "b"}, false, "x"},
+		{"a -name=x -v b", []string{"a", "b"}, true, "x"},
+		{"-- -v", []string{"-v"}, false, ""},
+		{"a -- -v b", []string{"a", "-v", "b"}, false, ""},
+		{"-v a -- -name x", []string{"a", "-name", "x"}, true, ""},
+	}
+
+	for _, test := range tests {
+		cmd := newCommand("test", "", 0, -1, "", "")
+		verbose := cmd.flags.Bool("v", false, "")
+		name := cmd.flags.String("name", "", "")
+
+		positional, err := cmd.parseArgs(strings.Fields(test.args))
+		if err != nil {
+			t.Fatalf("%q: %v", test.args, err)
+		} else if !reflect.DeepEqual(positional, test.positional) {
+			t.Fatalf("%q: expected arguments %q, have %q", test.args, test.positional, positional)
+		} else if *verbose != test.verbose || *name != test.name {
+			t.Fatalf("%q: wrong flags -v=%v -name=%q", test.args, *verbose, *name)
+		}
+	}
+
+	cmd := newCommand("test", "", 0, -1, "", "")
+	cmd.flags.SetOutput(ioutil.Discard)
+	if _, err := cmd.parseArgs([]string{"a", "-unknown"}); err == nil {
+		t.Fatal("an unknown flag was accepted")
+	}
+}
+
+// TestArgCount validates the checks on the number of arguments.
+func TestArgCount(t *testing.T) {
+	tests := []struct {
+		min, max int
+		n        int
+		ok       bool
+	}{
+		{0, 0, 0, true},
+		{0, 0, 1, false},
+		{1, 1, 0, false},
+		{1, 1, 1, true},
+		{1, 1, 2, false},
+		{1, 2, 2, true},
+		{1, 2, 3, false},
+		{1, -1, 0, false},
+		{1, -1, 100, true},
+	}
+
+	for _, test := range tests {
+		cmd := newCommand("test", "", test.min, test.max, "", "")
+		if cmd.argCountOK(test.n) != test.ok {
+			t.Fatalf("%d arguments with min %d and max %d: expected %v", test.n, test.min, test.max, test.ok)
+		}
+	}
+}
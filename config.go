@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gokyle/keybase/api"
+	"github.com/gokyle/keybase/openpgp"
+)
+
+// A configKey is a setting in the config file. Its environment
+// variable, if set, overrides the file.
+type configKey struct {
+	name  string
+	env   string
+	desc  string
+	def   func() string
+	check func(value string) error
+}
+
+func noDefault() string { return "" }
+
+// configKeys lists the settings, in the order config list shows them.
+var configKeys = []*configKey{
+	{"user", "KEYBASE_USER", "keybase.io username or email to log in as", noDefault, nil},
+	{"gnupg_home", "KEYBASE_GNUPG_HOME", "GnuPG home directory holding the keyrings",
+		func() string { return openpgp.DefaultHome }, nil},
+	{"server", "KEYBASE_SERVER", "keybase.io API base URL",
+		func() string { return api.BaseURL }, checkServer},
+	{"signing_key", "KEYBASE_SIGNING_KEY", "key to sign and certify with when none is given", noDefault, nil},
+	{"output", "KEYBASE_OUTPUT", "output format: text or json",
+		func() string { return "text" }, checkOutput},
+	{"cache_dir", "KEYBASE_CACHE_DIR", "directory for cached lookups", defaultCacheDir, nil},
+	{"cache_ttl", "KEYBASE_CACHE_TTL", "how long cached users are used before asking keybase.io again",
+		func() string { return "1h" }, checkDuration},
+	{"cache_key_ttl", "KEYBASE_CACHE_KEY_TTL", "how long cached key bundles are used",
+		func() string { return "24h" }, checkDuration},
+	{"pin_file", "KEYBASE_PIN_FILE", "file of the keys pinned by lookups", defaultPinFile, nil},
+}
+
+func checkServer(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return fmt.Errorf("server must be an http or https URL")
+	}
+	return nil
+}
+
+func checkOutput(value string) error {
+	if value != "text" && value != "json" {
+		return fmt.Errorf("output must be text or json")
+	}
+	return nil
+}
+
+func checkDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return fmt.Errorf("%s isn't a duration such as 30m or 24h", value)
+	}
+	return nil
+}
+
+// xdgDir returns the XDG base directory named by env, or dir under the
+// home directory if it isn't set.
+func xdgDir(env, dir string) string {
+	if path := os.Getenv(env); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), dir)
+}
+
+// dataDir returns the directory for state that isn't a cache, such as
+// pins.
+func dataDir() string {
+	return filepath.Join(xdgDir("XDG_DATA_HOME", filepath.Join(".local", "share")), "keybase-go")
+}
+
+func defaultCacheDir() string {
+	return filepath.Join(xdgDir("XDG_CACHE_HOME", ".cache"), "keybase-go")
+}
+
+// configPath returns the config file's path: $KEYBASE_CONFIG, or
+// keybase-go/config.toml in the XDG config directory.
+func configPath() string {
+	if path := os.Getenv("KEYBASE_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "keybase-go", "config.toml")
+}
+
+func findConfigKey(name string) *configKey {
+	for _, key := range configKeys {
+		if key.name == name {
+			return key
+		}
+	}
+	return nil
+}
+
+// A config holds the settings in the config file, which is a TOML file
+// of string settings, e.g.
+//
+//	user = "alice"
+//	gnupg_home = "~/.gnupg-keybase"
+//
+// Its lines are kept so that setting a value leaves comments and
+// layout alone.
+type config struct {
+	path   string
+	values map[string]string
+	lines  []string
+	index  map[string]int // the line each setting is on
+}
+
+var cfg = &config{values: map[string]string{}, index: map[string]int{}}
+
+var configKeyRegexp = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*=\s*(.*)$`)
+
+// loadConfig reads the config file at path; a missing file is an empty
+// config.
+func loadConfig(path string) (c *config, err error) {
+	c = &config{path: path, values: map[string]string{}, index: map[string]int{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		c.lines = append(c.lines, line)
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		m := configKeyRegexp.FindStringSubmatch(trimmed)
+		if m == nil {
+			err = fmt.Errorf("line %d: expected key = \"value\"", len(c.lines))
+			return
+		}
+		var value string
+		value, err = parseTOMLString(m[2])
+		if err != nil {
+			err = fmt.Errorf("line %d: %v", len(c.lines), err)
+			return
+		}
+		c.values[m[1]] = value
+		c.index[m[1]] = len(c.lines) - 1
+	}
+	err = scanner.Err()
+	return
+}
+
+// parseTOMLString parses a TOML basic ("...") or literal ('...')
+// string, which may be followed by a comment.
+func parseTOMLString(s string) (value string, err error) {
+	if s == "" || s[0] != '"' && s[0] != '\'' {
+		err = fmt.Errorf("value must be a quoted string")
+		return
+	}
+
+	var end int
+	if s[0] == '\'' {
+		end = strings.IndexByte(s[1:], '\'') + 1
+		if end == 0 {
+			err = fmt.Errorf("unterminated string")
+			return
+		}
+		value = s[1:end]
+	} else {
+		for end = 1; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(s) {
+			err = fmt.Errorf("unterminated string")
+			return
+		}
+		// TOML's escapes are a subset of Go's.
+		value, err = strconv.Unquote(s[:end+1])
+		if err != nil {
+			err = fmt.Errorf("invalid string %s", s[:end+1])
+			return
+		}
+	}
+
+	rest := strings.TrimSpace(s[end+1:])
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		err = fmt.Errorf("unexpected %q after value", rest)
+	}
+	return
+}
+
+// quoteTOML quotes s as a TOML basic string.
+func quoteTOML(s string) string {
+	buf := new(bytes.Buffer)
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+			fmt.Fprintf(buf, "\\u%04x", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// expandHome replaces a leading ~ in a path with the home directory.
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
+
+// get returns the named setting and where it came from: the
+// environment, the config file or the default.
+func (c *config) get(name string) (value, source string) {
+	key := findConfigKey(name)
+	if key == nil {
+		return "", ""
+	}
+	if value = os.Getenv(key.env); value != "" {
+		return value, "$" + key.env
+	}
+	if value, ok := c.values[name]; ok {
+		return value, c.path
+	}
+	return key.def(), "default"
+}
+
+// value returns the named setting.
+func (c *config) value(name string) string {
+	value, _ := c.get(name)
+	return value
+}
+
+// set changes a setting in the config file, removing it if value is
+// empty. Call store to write the file.
+func (c *config) set(name, value string) (err error) {
+	key := findConfigKey(name)
+	if key == nil {
+		return fmt.Errorf("unknown setting %s", name)
+	} else if value != "" && key.check != nil {
+		if err = key.check(value); err != nil {
+			return
+		}
+	}
+
+	i, ok := c.index[name]
+	switch {
+	case value == "" && ok:
+		c.lines = append(c.lines[:i], c.lines[i+1:]...)
+		delete(c.values, name)
+		delete(c.index, name)
+		for other, j := range c.index {
+			if j > i {
+				c.index[other] = j - 1
+			}
+		}
+	case value == "":
+	case ok:
+		c.lines[i] = name + " = " + quoteTOML(value)
+		c.values[name] = value
+	default:
+		c.lines = append(c.lines, name+" = "+quoteTOML(value))
+		c.values[name] = value
+		c.index[name] = len(c.lines) - 1
+	}
+	return
+}
+
+// store writes the config file, creating its directory if needed.
+func (c *config) store() (err error) {
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		return
+	}
+	data := strings.Join(c.lines, "\n")
+	if data != "" {
+		data += "\n"
+	}
+	return writeFileAtomic(c.path, []byte(data), 0600)
+}
+
+// writeFileAtomic writes a file by writing a temporary file beside it
+// and renaming that over it, so the file is never left half written.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return
+}
+
+// applyConfig sets up the keyrings, API and cache from the settings,
+// checking any that came from the environment or config file.
+func applyConfig(gpgDir string, offline bool) (err error) {
+	for _, key := range configKeys {
+		value, source := cfg.get(key.name)
+		if key.check != nil && source != "default" {
+			if err = key.check(value); err != nil {
+				return fmt.Errorf("%s (from %s)", err, source)
+			}
+		}
+	}
+
+	if gpgDir == "" {
+		gpgDir = expandHome(cfg.value("gnupg_home"))
+	}
+	openpgp.SetKeyRingDir(gpgDir)
+
+	api.BaseURL = strings.TrimSuffix(cfg.value("server"), "/") + "/"
+
+	// The TTLs have been checked above, or are the defaults.
+	ttl, _ := time.ParseDuration(cfg.value("cache_ttl"))
+	keyTTL, _ := time.ParseDuration(cfg.value("cache_key_ttl"))
+	api.UserCache = &api.Cache{
+		Dir:     expandHome(cfg.value("cache_dir")),
+		TTL:     ttl,
+		KeyTTL:  keyTTL,
+		Offline: offline,
+	}
+	return
+}
+
+// A settingDoc describes a setting in JSON output.
+type settingDoc struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Source      string `json:"source"`
+	Env         string `json:"env"`
+	Description string `json:"description"`
+}
+
+func newSettingDoc(key *configKey) *settingDoc {
+	value, source := cfg.get(key.name)
+	return &settingDoc{key.name, value, source, key.env, key.desc}
+}
+
+func configGetCommand() *command {
+	cmd := newCommand("config get", "<setting>", 1, 1,
+		"print a setting",
+		`Get prints a setting's value: from its environment variable if
+that's set, else the config file, else the default. Run 'keybase
+config list' for the settings.`)
+	cmd.run = func(args []string) {
+		key := findConfigKey(args[0])
+		if key == nil {
+			fail(exitUsage, "Unknown setting %s.", args[0])
+		}
+		if jsonOutput {
+			emit(newSettingDoc(key))
+			return
+		}
+		fmt.Println(cfg.value(args[0]))
+	}
+	return cmd
+}
+
+func configSetCommand() *command {
+	cmd := newCommand("config set", "<setting> <value>", 2, 2,
+		"change a setting in the config file",
+		`Set changes a setting in the config file, creating it if needed;
+an empty value removes the setting. The environment variable for the
+setting still overrides the file.`)
+	cmd.run = func(args []string) {
+		err := cfg.set(args[0], args[1])
+		if err != nil {
+			fail(exitUsage, "Couldn't change %s: %v.", args[0], err)
+		}
+		err = cfg.store()
+		if err != nil {
+			failErr(err, "Couldn't write "+cfg.path)
+		}
+		if jsonOutput {
+			emit(newSettingDoc(findConfigKey(args[0])))
+		}
+	}
+	return cmd
+}
+
+func configListCommand() *command {
+	cmd := newCommand("config list", "", 0, 0,
+		"list the settings",
+		`List prints every setting, its value and where the value came
+from. Settings are read from $KEYBASE_CONFIG, or
+keybase-go/config.toml in $XDG_CONFIG_HOME (~/.config by default),
+and each may be overridden by its environment variable.`)
+	cmd.run = func(args []string) {
+		if jsonOutput {
+			doc := struct {
+				File     string        `json:"file"`
+				Settings []*settingDoc `json:"settings"`
+			}{File: cfg.path}
+			for _, key := range configKeys {
+				doc.Settings = append(doc.Settings, newSettingDoc(key))
+			}
+			emit(doc)
+			return
+		}
+		fmt.Printf("Config file: %s\n", cfg.path)
+		for _, key := range configKeys {
+			value, source := cfg.get(key.name)
+			fmt.Printf("%s = %s\n", key.name, quoteTOML(value))
+			fmt.Printf("\t# %s; from %s (override with $%s)\n", key.desc, source, key.env)
+		}
+	}
+	return cmd
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParseTOMLString validates parsing quoted values, their escapes
+// and trailing comments, and that malformed values are refused.
+func TestParseTOMLString(t *testing.T) {
+	tests := []struct {
+		in    string
+		value string
+		ok    bool
+	}{
+		{`"alice"`, "alice", true},
+		{`""`, "", true},
+		{`'C:\keys'`, `C:\keys`, true},
+		{`"a \"quoted\" word"`, `a "quoted" word`, true},
+		{`"tab\tand\\backslash"`, "tab\tand\\backslash", true},
+		{`"\u00e9"`, "\u00e9", true},
+		{`"alice" # a comment`, "alice", true},
+		{`'alice'   #`, "alice", true},
+		{`"a # b"`, "a # b", true},
+		{`alice`, "", false},
+		{``, "", false},
+		{`"alice`, "", false},
+		{`'alice`, "", false},
+		{`"alice\"`, "", false},
+		{`"\q"`, "", false},
+		{`"alice" bob`, "", false},
+	}
+
+	for _, test := range tests {
+		value, err := parseTOMLString(test.in)
+		if test.ok && err != nil {
+			t.Fatalf("%s: %v", test.in, err)
+		} else if !test.ok && err == nil {
+			t.Fatalf("%s: expected an error, got %q", test.in, value)
+		} else if test.ok && value != test.value {
+			t.Fatalf("%s: expected %q, got %q", test.in, test.value, value)
+		}
+	}
+}
+
+// TestConfigStore validates loading a config file, and that changing a
+// setting leaves the rest of the file alone.
+func TestConfigStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keybase-config")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.toml")
+	original := "# keybase settings\n\nuser = \"alice\" # me\noutput = 'json'\n"
+	if err = ioutil.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if c.values["user"] != "alice" || c.values["output"] != "json" {
+		t.Fatalf("wrong values %v", c.values)
+	}
+
+	if err = c.set("user", "bob \"b\""); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = c.set("output", ""); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = c.set("signing_key", "0xDEADBEEF"); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = c.set("output", "xml"); err == nil {
+		t.Fatal("an invalid setting was accepted")
+	} else if err = c.store(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	expected := "# keybase settings\n\nuser = \"bob \\\"b\\\"\"\nsigning_key = \"0xDEADBEEF\"\n"
+	if string(data) != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, data)
+	}
+
+	c, err = loadConfig(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if c.values["user"] != "bob \"b\"" {
+		t.Fatalf("wrong value %q", c.values["user"])
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(files) != 1 {
+		t.Fatal("a temporary file was left behind")
+	}
+}
+
+// TestLoadConfigMalformed validates that malformed lines are refused
+// with their line number.
+func TestLoadConfigMalformed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keybase-config")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct{ data, err string }{
+		{"user = \"alice\"\n[section]\n", "line 2: expected key = \"value\""},
+		{"# comment\nuser = alice\n", "line 2: value must be a quoted string"},
+		{"user = \"alice\n", "line 1: unterminated string"},
+	} {
+		path := filepath.Join(dir, "config.toml")
+		if err = ioutil.WriteFile(path, []byte(test.data), 0600); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err = loadConfig(path); err == nil || err.Error() != test.err {
+			t.Fatalf("%q: expected error %q, got %v", test.data, test.err, err)
+		}
+	}
+}
@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gokyle/keybase/api"
 	"github.com/gokyle/keybase/openpgp"
 	"github.com/gokyle/readpass"
+	xopenpgp "golang.org/x/crypto/openpgp"
 )
 
 func zero(in []byte) {
@@ -43,298 +45,497 @@ func unixToString(ts int) string {
 	return t.Format(displayTime)
 }
 
-func lookup(name string) {
-	user, err := api.LookupUser(name)
+// expiryWarning is how far ahead of a published key's expiry lookup
+// and fetch start warning about it.
+const expiryWarning = 30 * 24 * time.Hour
+
+// keyWarnings checks an armoured public key, returning warnings about
+// keys and subkeys that are invalid or will expire soon.
+func keyWarnings(armoured string) (warnings []string) {
+	el, err := xopenpgp.ReadArmoredKeyRing(strings.NewReader(armoured))
 	if err != nil {
-		fmt.Printf("Lookup failed: %v\n", err)
-		os.Exit(1)
-	}
-
-	fmt.Printf("Details for user %s:\n", user.Basics.Username)
-	fmt.Printf("\tCreated: %s\n", unixToString(user.Basics.Created))
-	fmt.Printf("\tLast modified: %s\n", unixToString(user.Basics.Modified))
-	fmt.Printf("\tProfile:\n")
-	fmt.Printf("\t\tLast updated: %s\n", unixToString(user.Profile.Modified))
-	fmt.Printf("\t\tFull name: %s\n", user.Profile.FullName)
-	fmt.Printf("\t\tLocation: %s\n", user.Profile.Location)
-	fmt.Printf("\t\tBio:\n")
-	fmt.Printf("\t\t\t%s\n", user.Profile.Bio) // TODO(kyle): wordwrap bio
-
-	if pub, ok := user.PublicKeys["primary"]; ok {
-		fmt.Printf("\tPublic key\n")
-		fmt.Printf("\t\tKey ID: %s\n", pub.KeyID)
-		fmt.Printf("\t\tCreated: %s\n", unixToString(pub.Created))
-		fmt.Printf("\t\tLast modified: %s\n", unixToString(pub.Modified))
-	} else {
-		fmt.Printf("\tNo public key.\n")
+		return []string{fmt.Sprintf("the key couldn't be read: %v", err)}
 	}
 
+	now := time.Now()
+	for _, e := range el {
+		for _, v := range openpgp.CheckEntity(e, now) {
+			if !v.Valid() {
+				warnings = append(warnings, v.String())
+			} else if v.ExpiresWithin(now, expiryWarning) {
+				warnings = append(warnings, v.String()+" (expires soon)")
+			}
+		}
+	}
+	return
 }
 
+// checkUpload refuses to upload a key that has expired or been
+// revoked, unless forced.
+func checkUpload(armoured string, force bool) {
+	el, err := xopenpgp.ReadArmoredKeyRing(strings.NewReader(armoured))
+	if err != nil {
+		failErr(err, "Invalid public key")
+	}
+
+	for _, e := range el {
+		v := openpgp.CheckEntity(e, time.Now())[0]
+		if v.Valid() {
+			continue
+		} else if force {
+			info("Warning: %s\n", v)
+			continue
+		}
+		fail(exitVerify, "Refusing to upload %s (use -force to upload anyway).", v)
+	}
+}
+
+// servedKey reads the key bundle keybase.io serves for a user, which
+// must be a single key matching the fingerprint on their account.
+func servedKey(user *api.User) (e *xopenpgp.Entity, err error) {
+	name := user.Basics.Username
+	pub := user.PublicKeys.Primary
+	if pub == nil || pub.Bundle == "" {
+		err = errNoPublicKey(name)
+		return
+	}
+
+	el, err := xopenpgp.ReadArmoredKeyRing(strings.NewReader(pub.Bundle))
+	if err != nil {
+		err = &statusError{exitVerify, fmt.Sprintf("couldn't read %s's public key: %v", name, err)}
+		return
+	} else if len(el) != 1 {
+		err = &statusError{exitVerify, fmt.Sprintf("expected one public key for %s, found %d", name, len(el))}
+		return
+	}
+	fpr := fmt.Sprintf("%x", el[0].PrimaryKey.Fingerprint)
+	if !strings.EqualFold(fpr, pub.Fingerprint) {
+		err = errMismatch(fpr, pub.Fingerprint)
+		return
+	}
+	e = el[0]
+	return
+}
+
+// fetchKey writes the key keybase.io serves for name to outFile, or
+// standard output if it's "-", once it's been checked against the
+// fingerprint on their account and their pin.
 func fetchKey(name, outFile string) {
 	user, err := api.LookupUser(name)
+	if err == nil {
+		_, err = servedKey(user)
+	}
+	if err == nil {
+		err = checkPin(user)
+	}
 	if err != nil {
-		fmt.Printf("Fetch failed: %v\n", err)
-		os.Exit(1)
+		failErr(err, "Fetch failed")
 	}
 
-	pub, ok := user.PublicKeys["primary"]
-	if !ok || pub.Bundle == "" {
-		fmt.Printf("%s hasn't uploaded a public key yet.\n", name)
-		os.Exit(1)
+	pub := user.PublicKeys.Primary
+
+	doc := struct {
+		Username    string   `json:"username"`
+		KID         string   `json:"kid"`
+		Fingerprint string   `json:"fingerprint"`
+		Key         string   `json:"key,omitempty"`
+		File        string   `json:"file,omitempty"`
+		Warnings    []string `json:"warnings,omitempty"`
+	}{
+		Username:    user.Basics.Username,
+		KID:         pub.KeyID,
+		Fingerprint: pub.Fingerprint,
+		Warnings:    keyWarnings(pub.Bundle),
+	}
+	if !jsonOutput {
+		for _, warning := range doc.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
 	}
 
 	if outFile != "-" {
 		err = ioutil.WriteFile(outFile, []byte(pub.Bundle+"\n"), 0644)
 		if err != nil {
-			fmt.Printf("Couldn't write %s's public key to disk: %v\n", user.Basics.Username, err)
-		} else {
-			fmt.Printf("Wrote %s's public key to %s.\n'", user.Basics.Username, outFile)
+			failErr(err, fmt.Sprintf("Couldn't write %s's public key to disk", user.Basics.Username))
 		}
-	} else {
+		doc.File = outFile
+		info("Wrote %s's public key to %s.\n", user.Basics.Username, outFile)
+	} else if !jsonOutput {
 		fmt.Fprintf(os.Stdout, "%s\n", pub.Bundle)
+	} else {
+		doc.Key = pub.Bundle
+	}
+	if jsonOutput {
+		emit(doc)
 	}
 }
 
-func deleteKey(session *api.Session) {
-	pub, ok := session.User.PublicKeys["primary"]
-	if !ok {
-		fmt.Println("There is no public key to delete.")
-		os.Exit(1)
+// certifyOptions holds the certify flags.
+type certifyOptions struct {
+	signer  string
+	level   int
+	user    string
+	outFile string
+	force   bool
+}
+
+// certifyUser checks that the key keybase serves for name matches the
+// fingerprint on their account, asks the user to confirm the identity,
+// and certifies the key with a secret key. The certified key is
+// stored in the public keyring, and written to opts.outFile if given
+// so its owner can publish it.
+func certifyUser(name string, opts *certifyOptions) {
+	user, err := api.LookupUser(name)
+	if err != nil {
+		failErr(err, "Lookup failed")
 	}
-	err := session.DeleteKey(pub.KeyID)
+
+	pub := user.PublicKeys.Primary
+	if pub == nil || pub.Bundle == "" {
+		fail(exitNotFound, "%s hasn't uploaded a public key yet.", name)
+	}
+
+	el, err := xopenpgp.ReadArmoredKeyRing(strings.NewReader(pub.Bundle))
 	if err != nil {
-		fmt.Printf("Failed to delete your public key: %v\n", err)
-		os.Exit(1)
+		fail(exitVerify, "Couldn't read %s's public key: %v", name, err)
+	} else if len(el) != 1 {
+		fail(exitVerify, "Expected one public key for %s, found %d.", name, len(el))
+	}
+	target := el[0]
+	fpr := fmt.Sprintf("%x", target.PrimaryKey.Fingerprint)
+	if !strings.EqualFold(fpr, pub.Fingerprint) {
+		fail(exitVerify, "The key served for %s (%s) doesn't match the fingerprint on their account (%s).",
+			name, fpr, pub.Fingerprint)
 	}
-	fmt.Println("Your public key has been deleted from your account.")
-}
 
-func postAuth(session *api.Session, keyRing *openpgp.KeyRing) {
-	pub := session.User.PublicKeys["primary"]
-	if pub == nil {
-		fmt.Println("No public key for this account.")
-		os.Exit(1)
+	v := openpgp.CheckEntity(target, time.Now())[0]
+	if !v.Valid() && !opts.force {
+		fail(exitVerify, "Refusing to certify %s (use -force to certify anyway).", v)
 	}
 
-	fmt.Printf("Fingerprint: %s\n", pub.Fingerprint)
-	signer := keyRing.Entity(pub.Fingerprint)
-	if signer == nil {
-		fmt.Println("No private key for this account.")
-		os.Exit(1)
-	}
-
-	/*
-	   	var signature = `-----BEGIN PGP MESSAGE-----
-	   Version: Keybase Go client (OpenPGP version 0.1.0)
-
-	   xA0DAAIB7k+6hRB9rTcBrQFRYgBTPJCXeyJib2R5Ijp7ImtleSI6eyJmaW5nZXJw
-	   cmludCI6IjNiMGM0ZGU3ZDE2NThkMWE1ZmFlYzEyMGVlNGZiYTg1MTA3ZGFkMzci
-	   LCJob3N0Ijoia2V5YmFzZS5pbyIsImtleV9pZCI6IjAxMDEwYjA0YTFmNzk2M2Nm
-	   YjUxNjQ0ZWUyM2E0YTA2NmY1MzkxN2FmNzE1N2E5Njg5MWViYjAzNTExZTU1Yjk2
-	   MmUzMGEiLCJ1aWQiOiI5NGVmMWUzNTc4OWM2ZmE2NThiNzhlMWIwNWVlZGUwMCIs
-	   InVzZXJuYW1lIjoia2lzb20ifSwic3RyaW5nIjoiIiwidHlwZSI6ImF1dGgiLCJ2
-	   ZXJzaW9uIjoxfSwiY3RpbWUiOjEzOTY0NzgwOTQsImV4cGlyZXNfaW4iOjg2NDAw
-	   LCJ0YWciOiJzaWduYXR1cmUifcLBXAQAAQIAEAUCUzyQlwkQ7k+6hRB9rTcAANSz
-	   EAAseFDy8srABjWmQqp4uhaOPGUDzO9E2wYWm6GcFf60KeenMkYUCssHoP12a4eB
-	   ZLgt2ERkIFAQmn+hQkkfBSK7tQjh6XQmXstHwkhUp2XG2/Kn3Lek7t2sgaqzdt46
-	   /qVmgymBbSgraW0JYzDC+Bta8RRfYhkYyNTjtWnx9Ue2r2R6UcTDGyTS4cWMgAVY
-	   h1ZgQwNLfHMDqjEP93gPj9n8JZb5EN0MXGCe3Z6wMfXIams2QQ4TdsMGvwJffKel
-	   GGOaqUYoTlE01zSF9RA53xTzqwC7PpVcOO6V7FMpRM6UQE6x1MQzy/Iz8gBZONpb
-	   G24IkbpFj+SQYdUJ3fedTUiTWT32n/9sgp3NKY4lFdYKEDhv6fMgSLFkMhbvf6YC
-	   d0Jd1OORtzke3MxJPDHRLlnCNdNA3ZfwD1Dx2Mu8j7JknWBdUsZK2KtDh3hijfmY
-	   TGT1TL6fBT9DAzGfJj305rTNaYR2GnD8ncmqMCD5O+ePVFaQxyrC9zgy50UEOTNS
-	   BLVqFj1mCE38ziBxO39Y1Kx4U0ZUmQ90Fz7nzhw3alnPQaPF6M8f+q2Mx+xuZhHF
-	   hYddlV4afpsHp14sAeLaImLZ+IPvL+KH0f3Pc0N9rSaCJhsR5yLLpoPXk3RXrs+1
-	   kXBuzD8AyhGVVJ+VCOuauUwRQT+Ergl7auG94uQi7SN15w==
-	   =gRI/
-	   -----END PGP MESSAGE-----`
-	*/
-	sigData, err := session.SignaturePostAuthData()
+	info("Keybase user: %s\n", user.Basics.Username)
+	info("Full name: %s\n", user.Profile.FullName)
+	printKey(target, false, true)
+	confirm(fmt.Sprintf("Certify this key as belonging to %s? (y/N) ", user.Basics.Username), "Not certified.")
+
+	secRing := loadSecRingOrExit()
+	secRing.Force = opts.force
+
+	signer := opts.signer
+	if signer == "" && opts.user != "" {
+		signer = "keybase:" + opts.user
+	} else if signer == "" && secRing.Len() == 1 {
+		signer = fmt.Sprintf("%x", secRing.Entities()[0].PrimaryKey.Fingerprint)
+	}
+	certified, err := secRing.Certify(target, signer, opts.level)
 	if err != nil {
-		fmt.Printf("Failed to get signature post auth data: %v\n", err)
-		os.Exit(1)
+		failErr(err, "Certification failed")
+	}
+	for _, uid := range certified {
+		info("Certified %s.\n", uid)
 	}
 
-	signature, err := keyRing.Sign(sigData, pub.Fingerprint)
+	// Adding the key to a new keyring and exporting it keeps any
+	// revocations, which the key would lose if serialised directly.
+	certRing := openpgp.NewKeyRing("", false)
+	err = certRing.Add(target, nil)
 	if err != nil {
-		fmt.Printf("Signing failed: %v\n", err)
-		os.Exit(1)
+		failErr(err, "Couldn't export the certified key")
+	}
+	armoured, err := certRing.Export(fpr)
+	if err != nil {
+		failErr(err, "Couldn't export the certified key")
 	}
 
-	authToken, err := session.SignaturePostAuth([]byte(signature))
+	pubRing, err := loadOrCreateKeyRing(openpgp.PubRingPath, false)
 	if err != nil {
-		fmt.Printf("Posting signature authentication failed: %v\n", err)
-		os.Exit(1)
+		failErr(err, "Couldn't open "+openpgp.PubRingPath)
+	}
+	results, err := pubRing.Import(armoured)
+	if err != nil {
+		failErr(err, "Import failed")
+	}
+	storeKeyRing(pubRing, openpgp.PubRingPath)
+	for _, result := range results {
+		info("%s\n", result)
 	}
 
-	fmt.Printf("Authentication token: %x\n", authToken)
+	doc := struct {
+		Username    string   `json:"username"`
+		Fingerprint string   `json:"fingerprint"`
+		Certified   []string `json:"certified"`
+		Level       int      `json:"level"`
+		File        string   `json:"file,omitempty"`
+	}{user.Basics.Username, fpr, certified, opts.level, opts.outFile}
+	if opts.outFile != "" {
+		armoured, err = pubRing.Export(fpr)
+		if err == nil {
+			err = ioutil.WriteFile(opts.outFile, []byte(armoured+"\n"), 0644)
+		}
+		if err != nil {
+			failErr(err, "Couldn't write the certified key")
+		}
+		info("Wrote the certified key to %s; send it to %s to publish.\n", opts.outFile, user.Basics.Username)
+	}
+	if jsonOutput {
+		emit(doc)
+	}
 }
 
-func validCommands() {
-	fmt.Println("Valid commands:")
-	fmt.Printf("\tlookup <users...>\n")
-	fmt.Printf("\tfetch <user>\n")
-	fmt.Printf("\ttestlogin\n")
-	fmt.Printf("\tupload\n")
-	fmt.Printf("\tdelete\n")
+// revocationReasons maps the names accepted by -reason to reasons for
+// revocation.
+var revocationReasons = map[string]byte{
+	"none":        openpgp.RevokeNoReason,
+	"superseded":  openpgp.RevokeSuperseded,
+	"compromised": openpgp.RevokeCompromise,
+	"retired":     openpgp.RevokeRetired,
 }
 
-func main() {
-	flUser := flag.String("u", "", "keybase.io username or email")
-	flKeyFile := flag.String("pub", "", "public key file")
-	flOutFile := flag.String("out", "", "output file")
-	flGPGDir := flag.String("home", "", "override the default GnuPG home directory")
-	flag.Parse()
+// revokeOptions collects the flags used by the revoke-key command.
+type revokeOptions struct {
+	reason  string
+	text    string
+	outFile string
+	upload  bool
+	user    string
+}
 
-	if flag.NArg() == 0 {
-		fmt.Println("No command specified.")
-		validCommands()
-		os.Exit(1)
+// revokeKey revokes the selected key in the local keyrings after
+// asking for confirmation, writes the revocation certificate to
+// -out if given, and uploads the revoked key to keybase.io if asked.
+func revokeKey(selector string, opts *revokeOptions) {
+	reason, ok := revocationReasons[opts.reason]
+	if !ok {
+		fail(exitUsage, "Unknown reason %s; use none, superseded, compromised or retired.", opts.reason)
 	}
 
-	if *flGPGDir != "" {
-		openpgp.SetKeyRingDir(*flGPGDir)
+	secRing := loadSecRingOrExit()
+	e, err := secRing.Find(selector)
+	if err != nil {
+		failErr(err, "Key not found")
 	}
+	fpr := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
 
-	cmd := flag.Arg(0)
-	switch cmd {
-	case "lookup":
-		if flag.NArg() < 2 {
-			fmt.Println("You didn't specify a user to lookup.'")
-			os.Exit(1)
-		}
+	printKey(e, true, false)
+	confirm("Revoke this key? This can't be undone. (y/N) ", "Not revoked.")
 
-		for _, name := range flag.Args()[1:] {
-			lookup(name)
-		}
-	case "fetch":
-		if flag.NArg() < 2 {
-			fmt.Println("You didn't specify the user whose key you want to fetch.'")
-			os.Exit(1)
-		} else if flag.NArg() > 2 {
-			fmt.Println("Only one user's key may be fetched at a time.'")
-			os.Exit(1)
+	cert, err := secRing.RevocationCertificate(fpr, reason, opts.text)
+	secRing.Lock("")
+	if err != nil {
+		failErr(err, "Couldn't create the revocation certificate")
+	}
+	doc := struct {
+		Revoked string `json:"revoked"`
+		Reason  string `json:"reason"`
+		File    string `json:"file,omitempty"`
+		KID     string `json:"kid,omitempty"`
+	}{Revoked: fpr, Reason: opts.reason}
+	if opts.outFile != "" {
+		err = ioutil.WriteFile(opts.outFile, []byte(cert+"\n"), 0600)
+		if err != nil {
+			failErr(err, "Couldn't write the revocation certificate")
 		}
+		doc.File = opts.outFile
+		info("Wrote the revocation certificate to %s.\n", opts.outFile)
+	}
 
-		name := flag.Arg(1)
-		outFile := *flOutFile
-		if outFile == "" {
-			outFile = name + ".pub"
+	pubRing, err := openpgp.LoadKeyRing(openpgp.PubRingPath)
+	if err != nil {
+		failErr(err, "Couldn't open public keyring")
+	}
+	for _, keyRing := range []*openpgp.KeyRing{secRing, pubRing} {
+		if keyRing.Entity(fpr) == nil {
+			continue
 		}
-		fetchKey(name, outFile)
-	case "testlogin":
-		session, err := login(*flUser)
-		if err != nil {
-			fmt.Printf("Login failed: %v\n", err)
-			os.Exit(1)
+		if _, err = keyRing.Import(cert); err == nil {
+			err = keyRing.Store()
 		}
-		fmt.Printf("Logged in as %s.\n", session.User.Basics.Username)
-		fmt.Printf("Session token: %s\n", session.Session)
-		fmt.Printf("CSRF token: %s\n", session.Token)
-	case "upload":
-		var armoured string
-		var err error
-
-		pubRing, err := openpgp.LoadKeyRing(openpgp.PubRingPath)
 		if err != nil {
-			fmt.Printf("Couldn't open public keyring: %v\n", err)
-			os.Exit(1)
+			failErr(err, "Couldn't revoke the key")
 		}
+	}
+	info("Revoked %X.\n", e.PrimaryKey.Fingerprint)
 
-		if *flKeyFile == "" {
-			if flag.NArg() == 2 {
-				armoured, err = pubRing.Export(flag.Arg(1))
-				if err != nil {
-					fmt.Printf("Key not found.")
-					os.Exit(1)
-				}
-			} else {
-				fmt.Println("No file specified (with -pub) and no fingerprint specified.")
-				fmt.Println("Cowardly refusing to proceed.")
-				os.Exit(1)
-			}
-		} else {
-
-			pub, err := ioutil.ReadFile(*flKeyFile)
-			if err != nil {
-				fmt.Printf("Failed to read the public key: %v\n", err)
-				os.Exit(1)
-			}
-			armoured = string(pub)
+	if opts.upload {
+		if pubRing.Entity(fpr) == nil {
+			fail(exitNotFound, "The public key isn't in the public keyring; can't upload it.")
 		}
-
-		session, err := login(*flUser)
+		pub, err := pubRing.Export(fpr)
 		if err != nil {
-			fmt.Printf("Login failed: %v\n", err)
-			os.Exit(1)
+			failErr(err, "Couldn't export the revoked key")
 		}
-		fmt.Printf("Logged in as %s.\n", session.User.Basics.Username)
 
-		kid, err := session.AddKey(armoured)
+		session := loginOrExit(opts.user)
+		doc.KID, err = session.AddKey(pub)
 		if err != nil {
-			fmt.Printf("Upload failed: %v\n", err)
-			os.Exit(1)
+			failErr(err, "Upload failed")
 		}
-		fmt.Printf("Successfully uploaded new key with ID %s.\n", kid)
-	case "delete":
-		session, err := login(*flUser)
-		if err != nil {
-			fmt.Printf("Login failed: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("Logged in as %s.\n", session.User.Basics.Username)
+		info("Uploaded the revoked key with ID %s.\n", doc.KID)
+	}
+	if jsonOutput {
+		emit(doc)
+	}
+}
 
-		deleteKey(session)
+// keybaseFingerprint returns the fingerprint of a keybase user's
+// primary key, for keybase:<user> key selectors.
+func keybaseFingerprint(name string) (fpr string, err error) {
+	user, err := api.LookupUser(name)
+	if err != nil {
+		return
+	}
 
-	case "auth":
-		secRing, err := openpgp.LoadKeyRing(openpgp.SecRingPath)
-		if err != nil {
-			fmt.Printf("Failed to load GnuPG secret keyring: %v.\n", err)
-			os.Exit(1)
-		}
+	pub := user.PublicKeys.Primary
+	if pub == nil || pub.Fingerprint == "" {
+		err = errNoPublicKey(name)
+		return
+	}
+	fpr = pub.Fingerprint
+	return
+}
 
-		session, err := login(*flUser)
-		if err != nil {
-			fmt.Printf("Login failed: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("Logged in as %s.\n", session.User.Basics.Username)
-		postAuth(session, secRing)
-	case "genkey":
-		if *flOutFile == "" {
-			fmt.Println("Please specify an output file with -out.")
-			os.Exit(1)
-		}
-		newKey(*flOutFile)
+// signMessage signs the named file (or standard input) with the
+// selected key, writing the signed message to outFile or standard
+// output.
+func signMessage(selector, inFile, outFile string, force bool) {
+	if inFile == "" {
+		inFile = "-"
+	}
+	message, err := readInput(inFile)
+	if err != nil {
+		failErr(err, "Couldn't read the message")
+	}
 
-	case "nextseq":
-		session, err := login(*flUser)
-		if err != nil {
-			fmt.Printf("Login failed: %v\n", err)
-			os.Exit(1)
+	secRing := loadSecRingOrExit()
+	secRing.Force = force
+	signer, err := secRing.Find(selector)
+	if err != nil {
+		failErr(err, "Signing failed")
+	}
+	fpr := fmt.Sprintf("%x", signer.PrimaryKey.Fingerprint)
+	sig, err := secRing.Sign(message, fpr)
+	secRing.Lock("")
+	if err != nil {
+		failErr(err, "Signing failed")
+	}
+
+	doc := struct {
+		Fingerprint string `json:"fingerprint"`
+		Signature   string `json:"signature,omitempty"`
+		File        string `json:"file,omitempty"`
+	}{Fingerprint: fpr}
+	if outFile == "" || outFile == "-" {
+		if !jsonOutput {
+			os.Stdout.Write(sig)
+			return
 		}
-		fmt.Printf("Logged in as %s.\n", session.User.Basics.Username)
-		nextSeq(session)
-	case "authtwit":
-		secRing, err := openpgp.LoadKeyRing(openpgp.SecRingPath)
+		doc.Signature = string(sig)
+	} else {
+		err = ioutil.WriteFile(outFile, sig, 0644)
 		if err != nil {
-			fmt.Printf("Failed to load GnuPG secret keyring: %v.\n", err)
-			os.Exit(1)
+			failErr(err, "Couldn't write the signature")
 		}
+		doc.File = outFile
+	}
+	if jsonOutput {
+		emit(doc)
+	}
+}
 
-		session, err := login(*flUser)
-		if err != nil {
-			fmt.Printf("Login failed: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("Logged in as %s.\n", session.User.Basics.Username)
-		authTwitter(session, secRing)
+func deleteKey(session *api.Session) {
+	pub := session.User.PublicKeys.Primary
+	if pub == nil {
+		fail(exitNotFound, "There is no public key to delete.")
+	}
+	err := session.DeleteKey(pub.KeyID)
+	if err != nil {
+		failErr(err, "Failed to delete your public key")
+	}
+	if jsonOutput {
+		emit(struct {
+			Deleted string `json:"deleted"`
+		}{pub.KeyID})
+		return
+	}
+	fmt.Println("Your public key has been deleted from your account.")
+}
+
+func postAuth(session *api.Session, keyRing *openpgp.KeyRing, selector string) {
+	pub := session.User.PublicKeys.Primary
+	if pub == nil {
+		fail(exitNotFound, "No public key for this account.")
+	}
+
+	if selector == "" {
+		selector = pub.Fingerprint
+	}
+	signer, err := keyRing.Find(selector)
+	if err != nil {
+		failErr(err, "No private key for this account")
+	}
+	fpr := fmt.Sprintf("%x", signer.PrimaryKey.Fingerprint)
+	info("Fingerprint: %s\n", fpr)
+	if !strings.EqualFold(fpr, pub.Fingerprint) {
+		info("Warning: this key isn't the account's primary key.\n")
+	}
+
+	sigData, err := session.SignaturePostAuthData()
+	if err != nil {
+		failErr(err, "Failed to get signature post auth data")
+	}
+
+	signature, err := keyRing.Sign(sigData, fpr)
+	if err != nil {
+		failErr(err, "Signing failed")
+	}
+
+	authToken, err := session.SignaturePostAuth([]byte(signature))
+	if err != nil {
+		failErr(err, "Posting signature authentication failed")
+	}
+
+	if jsonOutput {
+		emit(struct {
+			Fingerprint string `json:"fingerprint"`
+			AuthToken   string `json:"auth_token"`
+		}{fpr, fmt.Sprintf("%x", authToken)})
+		return
+	}
+	fmt.Printf("Authentication token: %x\n", authToken)
+}
+
+func main() {
+	flGPGDir := flag.String("home", "", "override the configured GnuPG home directory")
+	flag.BoolVar(&jsonOutput, "json", false, "print a JSON document instead of text")
+	flOffline := flag.Bool("offline", false, "look users up only in the cache")
+	flag.Usage = func() {
+		fmt.Println("Usage: keybase [-home dir] [-json] [-offline] <command> [flags] [arguments]")
+		printCommands("")
+	}
+	flag.Parse()
+
+	var err error
+	cfg, err = loadConfig(configPath())
+	if err != nil {
+		fail(exitFailure, "Couldn't read %s: %v", configPath(), err)
 	}
+	err = applyConfig(*flGPGDir, *flOffline)
+	if err != nil {
+		fail(exitFailure, "Invalid configuration: %v", err)
+	}
+	if cfg.value("output") == "json" {
+		jsonOutput = true
+	}
+	openpgp.KeybaseResolver = keybaseFingerprint
+
+	commands = commandList()
+	runCommand(flag.Args())
 }
 
+// readPrompt reads a line from the terminal, printing prompt first (to
+// standard error with -json).
 func readPrompt(prompt string) (in string, err error) {
-	fmt.Printf("%s", prompt)
+	info("%s", prompt)
 	rd := bufio.NewReader(os.Stdin)
 	line, err := rd.ReadString('\n')
 	if err != nil {
@@ -344,37 +545,210 @@ func readPrompt(prompt string) (in string, err error) {
 	return
 }
 
-func newKey(outFile string) {
-	name, err := readPrompt("Name: ")
+// keyOptions holds the genkey flags.
+type keyOptions struct {
+	openpgp.KeyOptions
+	pubFile  string
+	doImport bool
+	upload   bool
+	user     string
+}
+
+// parseLifetime parses a key lifetime such as 2y, 6m, 4w or 30d; Go
+// durations are accepted too. An empty string or 0 means the key
+// doesn't expire.
+func parseLifetime(s string) (lifetime time.Duration, err error) {
+	if s == "" || s == "0" {
+		return
+	}
+
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'm': 30 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if unit, ok := units[s[len(s)-1]]; ok {
+		var n int
+		n, err = strconv.Atoi(s[:len(s)-1])
+		if err == nil && n <= 0 {
+			err = fmt.Errorf("invalid lifetime %s", s)
+		}
+		lifetime = time.Duration(n) * unit
+		return
+	}
+	return time.ParseDuration(s)
+}
+
+// loadOrCreateKeyRing loads a keyring, returning an empty one if it
+// doesn't exist yet.
+func loadOrCreateKeyRing(path string, private bool) (keyRing *openpgp.KeyRing, err error) {
+	keyRing, err = openpgp.LoadKeyRing(path)
+	if os.IsNotExist(err) {
+		keyRing, err = openpgp.NewKeyRing(path, private), nil
+	}
+	return
+}
+
+func readNewPassphrase() (passphrase []byte, err error) {
+	passphrase, err = readpass.PasswordPromptBytes("Passphrase for the new key: ")
+	if err != nil {
+		return
+	} else if len(passphrase) == 0 {
+		err = fmt.Errorf("a passphrase is required")
+		return
+	}
+
+	confirm, err := readpass.PasswordPromptBytes("Repeat passphrase: ")
+	if err != nil {
+		zero(passphrase)
+		return
+	}
+	defer zero(confirm)
+
+	if string(confirm) != string(passphrase) {
+		zero(passphrase)
+		passphrase = nil
+		err = fmt.Errorf("passphrases don't match")
+	}
+	return
+}
+
+func newKey(outFile string, opts *keyOptions) {
+	var err error
+	opts.Name, err = readPrompt("Name: ")
 	if err != nil {
-		fmt.Printf("%v\n", err)
-		os.Exit(1)
-	} else if name == "" {
-		fmt.Println("Name required!")
-		os.Exit(1)
+		fail(exitFailure, "%v", err)
+	} else if opts.Name == "" {
+		fail(exitUsage, "Name required!")
 	}
 
-	email, err := readPrompt("Email: ")
+	opts.Email, err = readPrompt("Email: ")
+	if err != nil {
+		fail(exitFailure, "%v", err)
+	} else if opts.Email == "" {
+		fail(exitUsage, "Email required!")
+	}
+
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		fail(exitFailure, "%v", err)
+	}
+	defer zero(passphrase)
+
+	info("Generating key; this may take a while.\n")
+	e, err := openpgp.NewEntity(&opts.KeyOptions)
+	if err != nil {
+		failErr(err, "Failed to generate key")
+	}
+	fpr := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
+
+	// Build the outputs in throwaway keyrings so the secret key is
+	// protected the same way it would be in the secret keyring.
+	secRing := openpgp.NewKeyRing("", true)
+	pubRing := openpgp.NewKeyRing("", false)
+	if err = secRing.Add(e, passphrase); err == nil {
+		err = pubRing.Add(e, nil)
+	}
 	if err != nil {
-		fmt.Printf("%v\n", err)
-		os.Exit(1)
-	} else if email == "" {
-		fmt.Println("Email required!")
-		os.Exit(1)
+		fail(exitFailure, "%v", err)
 	}
 
-	_, err = openpgp.NewEntity(name, email, outFile)
+	sec, err := secRing.ExportSecret(fpr)
+	if err != nil {
+		failErr(err, "Failed to export the secret key")
+	}
+	pub, err := pubRing.Export(fpr)
 	if err != nil {
-		fmt.Printf("Failed to generate key: %v\n", err)
-		os.Exit(1)
+		failErr(err, "Failed to export the public key")
+	}
+	rev, err := openpgp.NewRevocation(e)
+	if err != nil {
+		failErr(err, "Failed to create a revocation certificate")
+	}
+
+	pubFile := opts.pubFile
+	if pubFile == "" {
+		pubFile = outFile + ".pub"
+	}
+	revFile := outFile + ".rev"
+	for _, out := range []struct {
+		path string
+		data string
+		perm os.FileMode
+	}{
+		{outFile, sec, 0600},
+		{pubFile, pub, 0644},
+		{revFile, rev, 0600},
+	} {
+		err = ioutil.WriteFile(out.path, []byte(out.data), out.perm)
+		if err != nil {
+			failErr(err, "Couldn't write "+out.path)
+		}
+	}
+	info("Generated key %s.\n", fpr)
+	info("Wrote the secret key to %s, the public key to %s, and a revocation certificate to %s.\n",
+		outFile, pubFile, revFile)
+
+	doc := struct {
+		Fingerprint    string `json:"fingerprint"`
+		SecretFile     string `json:"secret_file"`
+		PublicFile     string `json:"public_file"`
+		RevocationFile string `json:"revocation_file"`
+		Imported       bool   `json:"imported"`
+		KID            string `json:"kid,omitempty"`
+	}{fpr, outFile, pubFile, revFile, opts.doImport, ""}
+	if opts.doImport {
+		importNewKey(e, passphrase)
+	}
+
+	if opts.upload {
+		session := loginOrExit(opts.user)
+		doc.KID, err = session.AddKey(pub)
+		if err != nil {
+			failErr(err, "Upload failed")
+		}
+		info("Successfully uploaded new key with ID %s.\n", doc.KID)
+	}
+	if jsonOutput {
+		emit(doc)
+	}
+}
+
+// importNewKey adds a newly generated key to the default keyrings.
+func importNewKey(e *xopenpgp.Entity, passphrase []byte) {
+	for _, ring := range []struct {
+		path    string
+		private bool
+	}{
+		{openpgp.PubRingPath, false},
+		{openpgp.SecRingPath, true},
+	} {
+		keyRing, err := loadOrCreateKeyRing(ring.path, ring.private)
+		if err != nil {
+			failErr(err, "Couldn't open "+ring.path)
+		}
+
+		err = keyRing.Add(e, passphrase)
+		if err == nil {
+			err = keyRing.Store()
+		}
+		if err != nil {
+			failErr(err, "Couldn't import the key into "+ring.path)
+		}
+		info("Imported the key into %s.\n", ring.path)
 	}
 }
 
 func nextSeq(session *api.Session) {
 	seqNum, prev, err := session.NextSequence()
 	if err != nil {
-		fmt.Printf("[!] %v\n", err)
-		os.Exit(1)
+		fail(exitStatus(err), "[!] %v", err)
+	} else if jsonOutput {
+		emit(struct {
+			Seqno int    `json:"seqno"`
+			Prev  string `json:"prev"`
+		}{seqNum, prev})
 	} else {
 		fmt.Printf("Next sequence: %d\n", seqNum)
 		fmt.Printf("Previous hash: %s\n", prev)
@@ -384,37 +758,43 @@ func nextSeq(session *api.Session) {
 func authTwitter(session *api.Session, keyRing *openpgp.KeyRing) {
 	username, err := readPrompt("Twitter username: ")
 	if err != nil {
-		fmt.Printf("Couldn't read from console: %v\n", err)
+		failErr(err, "Couldn't read from console")
 	}
 
 	authData, err := session.TwitterGetAuth(username)
 	if err != nil {
-		fmt.Printf("Couldn't get authentication data: %v\n", err)
+		failErr(err, "Couldn't get authentication data")
 	}
 
-	pub := session.User.PublicKeys["primary"]
+	pub := session.User.PublicKeys.Primary
 	if pub == nil {
-		fmt.Println("No public key for this account.")
-		os.Exit(1)
+		fail(exitNotFound, "No public key for this account.")
 	}
 
-	fmt.Printf("Fingerprint: %s\n", pub.Fingerprint)
+	info("Fingerprint: %s\n", pub.Fingerprint)
 	signer := keyRing.Entity(pub.Fingerprint)
 	if signer == nil {
-		fmt.Println("No private key for this account.")
-		os.Exit(1)
+		fail(exitNotFound, "No private key for this account.")
 	}
 	ioutil.WriteFile("/tmp/authdata.json", authData, 0644)
 	sig, err := keyRing.Sign(authData, pub.Fingerprint)
 	if err != nil {
-		fmt.Printf("Couldn't sign authentication data: %v\n", err)
-		os.Exit(1)
+		failErr(err, "Couldn't sign authentication data")
 	}
 
 	proof, err := session.ServicePostAuth(sig, username, "twitter")
 	if err != nil {
-		fmt.Printf("Couldn't authenticate via Twitter: %v\n", err)
-		os.Exit(1)
+		failErr(err, "Couldn't authenticate via Twitter")
+	}
+	if jsonOutput {
+		emit(struct {
+			Service  string `json:"service"`
+			Username string `json:"username"`
+			Text     string `json:"proof_text"`
+			SigID    string `json:"sig_id"`
+			ProofID  string `json:"proof_id"`
+		}{"twitter", username, proof.Text, proof.SigID, proof.ProofID})
+		return
 	}
 	fmt.Printf("Proof text: '%s'\n", proof.Text)
 }
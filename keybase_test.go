@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gokyle/keybase/api"
+)
+
+// TestServedKey validates that a key bundle is only accepted if it's
+// a single key matching the fingerprint on the account.
+func TestServedKey(t *testing.T) {
+	alice, _ := newSyncMember(t, "alice")
+	bob, _ := newSyncMember(t, "bob")
+
+	tests := []struct {
+		name   string
+		fpr    string
+		bundle string
+		status int // 0 if the key is accepted
+	}{
+		{"matching", alice.fingerprint, alice.armoured, 0},
+		{"upper case fingerprint", strings.ToUpper(alice.fingerprint), alice.armoured, 0},
+		{"another key", alice.fingerprint, bob.armoured, exitVerify},
+		{"unreadable", alice.fingerprint, "not a key", exitVerify},
+		{"no bundle", alice.fingerprint, "", exitNotFound},
+	}
+
+	for _, test := range tests {
+		user := new(api.User)
+		user.Basics.Username = "alice"
+		user.PublicKeys.Primary = &api.Key{Fingerprint: test.fpr, Bundle: test.bundle}
+
+		e, err := servedKey(user)
+		switch {
+		case test.status == 0 && err != nil:
+			t.Fatalf("%s: %v", test.name, err)
+		case test.status == 0 && e == nil:
+			t.Fatalf("%s: no key returned", test.name)
+		case test.status != 0 && (err == nil || exitStatus(err) != test.status):
+			t.Fatalf("%s: expected exit status %d, got %v", test.name, test.status, err)
+		}
+	}
+
+	user := new(api.User)
+	user.Basics.Username = "alice"
+	if _, err := servedKey(user); exitStatus(err) != exitNotFound {
+		t.Fatalf("expected a user without a key to be not found, got %v", err)
+	}
+}
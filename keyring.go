@@ -1 +1,436 @@
 package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokyle/keybase/openpgp"
+	xopenpgp "golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// algoName names a key's algorithm and size as GnuPG does, e.g.
+// rsa2048 or nistp256.
+func algoName(pub *packet.PublicKey) string {
+	bits, _ := pub.BitLength()
+	switch pub.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoRSASignOnly:
+		return fmt.Sprintf("rsa%d", bits)
+	case packet.PubKeyAlgoDSA:
+		return fmt.Sprintf("dsa%d", bits)
+	case packet.PubKeyAlgoElGamal:
+		return fmt.Sprintf("elg%d", bits)
+	case packet.PubKeyAlgoECDSA:
+		if pk, ok := pub.PublicKey.(*ecdsa.PublicKey); ok {
+			name := strings.Replace(pk.Params().Name, "-", "", -1)
+			return "nist" + strings.ToLower(name)
+		}
+	}
+	return fmt.Sprintf("algo%d", pub.PubKeyAlgo)
+}
+
+// usage returns the key flags in sig, e.g. [SC].
+func usage(sig *packet.Signature) string {
+	if sig == nil || !sig.FlagsValid {
+		return ""
+	}
+
+	var flags string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{sig.FlagSign, "S"},
+		{sig.FlagCertify, "C"},
+		{sig.FlagEncryptCommunications || sig.FlagEncryptStorage, "E"},
+	} {
+		if flag.set {
+			flags += flag.name
+		}
+	}
+	return " [" + flags + "]"
+}
+
+// keyStatus describes a key's revocation or expiry for listings.
+func keyStatus(v *openpgp.KeyValidity) string {
+	switch {
+	case v.Revoked:
+		return " [revoked]"
+	case v.Err() == openpgp.ErrKeyExpired:
+		return " [expired: " + v.Expires.Format("2006-01-02") + "]"
+	case !v.Expires.IsZero():
+		return " [expires: " + v.Expires.Format("2006-01-02") + "]"
+	}
+	return ""
+}
+
+// printKey prints a key in the style of gpg --list-keys, to standard
+// error with -json. Keys whose secret key is missing from a secret
+// keyring are marked with #.
+func printKey(e *xopenpgp.Entity, secret, onKeybase bool) {
+	pubTag, subTag := "pub", "sub"
+	if secret {
+		pubTag, subTag = "sec", "ssb"
+	}
+
+	validity := openpgp.CheckEntity(e, time.Now())
+	var names []string
+	for name := range e.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var selfSig *packet.Signature
+	for _, name := range names {
+		if sig := e.Identities[name].SelfSignature; sig != nil && sig.FlagsValid {
+			selfSig = sig
+			break
+		}
+	}
+
+	tag := pubTag
+	if secret && e.PrivateKey == nil {
+		tag += "#"
+	}
+	line := fmt.Sprintf("%-5s %s %s%s%s", tag, algoName(e.PrimaryKey),
+		e.PrimaryKey.CreationTime.Format("2006-01-02"), usage(selfSig), keyStatus(validity[0]))
+	if onKeybase {
+		line += " [keybase]"
+	}
+	info("%s\n", line)
+	info("      %X\n", e.PrimaryKey.Fingerprint)
+
+	for _, name := range names {
+		info("uid           %s\n", name)
+	}
+
+	for i, subkey := range e.Subkeys {
+		tag := subTag
+		if secret && subkey.PrivateKey == nil {
+			tag += "#"
+		}
+		info("%-5s %s %s%s%s\n", tag, algoName(subkey.PublicKey),
+			subkey.PublicKey.CreationTime.Format("2006-01-02"), usage(subkey.Sig),
+			keyStatus(validity[i+1]))
+	}
+	info("\n")
+}
+
+// A keyDoc describes a key or subkey in the keyrings in JSON output.
+type keyDoc struct {
+	Fingerprint string     `json:"fingerprint"`
+	Algorithm   string     `json:"algorithm"`
+	Created     time.Time  `json:"created"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	Usage       string     `json:"usage,omitempty"` // key flags, e.g. SC
+	Revoked     bool       `json:"revoked"`
+	Valid       bool       `json:"valid"`
+	Problems    []string   `json:"problems,omitempty"`
+	Secret      bool       `json:"secret,omitempty"` // the secret key is present
+	UserIDs     []string   `json:"user_ids,omitempty"`
+	Subkeys     []*keyDoc  `json:"subkeys,omitempty"`
+	OnKeybase   bool       `json:"on_keybase,omitempty"`
+}
+
+func newKeyDoc(pub *packet.PublicKey, sig *packet.Signature, v *openpgp.KeyValidity) *keyDoc {
+	doc := &keyDoc{
+		Fingerprint: fmt.Sprintf("%x", pub.Fingerprint),
+		Algorithm:   algoName(pub),
+		Created:     pub.CreationTime.UTC(),
+		Usage:       strings.Trim(usage(sig), " []"),
+		Revoked:     v.Revoked,
+		Valid:       v.Valid(),
+		Problems:    v.Reasons,
+	}
+	if !v.Expires.IsZero() {
+		expires := v.Expires.UTC()
+		doc.Expires = &expires
+	}
+	return doc
+}
+
+// entityDoc describes a key as printKey does, for JSON output.
+func entityDoc(e *xopenpgp.Entity, secret, onKeybase bool) *keyDoc {
+	validity := openpgp.CheckEntity(e, time.Now())
+	var names []string
+	for name := range e.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var selfSig *packet.Signature
+	for _, name := range names {
+		if sig := e.Identities[name].SelfSignature; sig != nil && sig.FlagsValid {
+			selfSig = sig
+			break
+		}
+	}
+
+	doc := newKeyDoc(e.PrimaryKey, selfSig, validity[0])
+	doc.Secret = secret && e.PrivateKey != nil
+	doc.UserIDs = names
+	doc.OnKeybase = onKeybase
+	for i, subkey := range e.Subkeys {
+		sub := newKeyDoc(subkey.PublicKey, subkey.Sig, validity[i+1])
+		sub.Secret = secret && subkey.PrivateKey != nil
+		doc.Subkeys = append(doc.Subkeys, sub)
+	}
+	return doc
+}
+
+// listKeys lists the keys in the public keyring, or the secret
+// keyring if secret is true. If a keybase user was given with -u,
+// their key is marked.
+func listKeys(secret bool, user string) {
+	path := openpgp.PubRingPath
+	if secret {
+		path = openpgp.SecRingPath
+	}
+
+	keyRing, err := openpgp.LoadKeyRing(path)
+	if err != nil {
+		failErr(err, "Couldn't open "+path)
+	}
+
+	var keybaseFpr string
+	if user != "" {
+		keybaseFpr, err = keybaseFingerprint(user)
+		if err != nil {
+			info("Warning: couldn't look up %s's key: %v\n", user, err)
+		}
+	}
+
+	if jsonOutput {
+		doc := struct {
+			KeyRing string    `json:"keyring"`
+			Keys    []*keyDoc `json:"keys"`
+		}{path, []*keyDoc{}}
+		for _, e := range keyRing.Entities() {
+			fpr := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
+			doc.Keys = append(doc.Keys, entityDoc(e, secret, strings.EqualFold(fpr, keybaseFpr)))
+		}
+		emit(doc)
+		return
+	}
+
+	fmt.Println(path)
+	fmt.Println(strings.Repeat("-", len(path)))
+	for _, e := range keyRing.Entities() {
+		fpr := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
+		printKey(e, secret, strings.EqualFold(fpr, keybaseFpr))
+	}
+}
+
+// storeKeyRing writes a keyring to disk, exiting on failure.
+func storeKeyRing(keyRing *openpgp.KeyRing, path string) {
+	err := keyRing.Store()
+	if err != nil {
+		failErr(err, "Couldn't write "+path)
+	}
+}
+
+// readInput reads the named file, or standard input if it's "-".
+func readInput(inFile string) ([]byte, error) {
+	if inFile == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(inFile)
+}
+
+// importKeys imports the armoured keys in the named file, or standard
+// input. Secret keys are imported into the secret keyring, and their
+// public keys into the public keyring.
+func importKeys(inFile string) {
+	data, err := readInput(inFile)
+	if err != nil {
+		failErr(err, "Couldn't read the keys")
+	}
+	imported := importArmoured(data)
+	if jsonOutput {
+		emit(struct {
+			Imported []*importedKey `json:"imported"`
+		}{imported})
+		return
+	}
+	printImported(imported)
+}
+
+// An importedKey is the result of importing a key into one of the
+// keyrings.
+type importedKey struct {
+	KeyRing string `json:"keyring"` // public or secret
+	*openpgp.ImportResult
+}
+
+// printImported prints what an import changed.
+func printImported(imported []*importedKey) {
+	for _, key := range imported {
+		if key.KeyRing == "secret" {
+			fmt.Printf("secret %s\n", key.ImportResult)
+		} else {
+			fmt.Println(key.ImportResult)
+		}
+	}
+}
+
+// importArmoured imports armoured keys as importKeys does.
+func importArmoured(data []byte) (imported []*importedKey) {
+	block, err := armor.Decode(strings.NewReader(string(data)))
+	if err != nil {
+		failErr(err, "Couldn't read the keys")
+	}
+
+	pubRing, err := loadOrCreateKeyRing(openpgp.PubRingPath, false)
+	if err != nil {
+		failErr(err, "Couldn't open "+openpgp.PubRingPath)
+	}
+
+	armoured := []string{string(data)}
+	if block.Type == xopenpgp.PrivateKeyType {
+		secRing, err := loadOrCreateKeyRing(openpgp.SecRingPath, true)
+		if err != nil {
+			failErr(err, "Couldn't open "+openpgp.SecRingPath)
+		}
+
+		results, err := secRing.Import(string(data))
+		if err != nil {
+			failErr(err, "Import failed")
+		}
+		storeKeyRing(secRing, openpgp.SecRingPath)
+
+		armoured = nil
+		for _, result := range results {
+			imported = append(imported, &importedKey{"secret", result})
+			pub, err := secRing.Export(result.Fingerprint)
+			if err != nil {
+				failErr(err, "Couldn't export the public key")
+			}
+			armoured = append(armoured, pub)
+		}
+	}
+
+	for _, pub := range armoured {
+		results, err := pubRing.Import(pub)
+		if err != nil {
+			failErr(err, "Import failed")
+		}
+		for _, result := range results {
+			imported = append(imported, &importedKey{"public", result})
+		}
+	}
+	storeKeyRing(pubRing, openpgp.PubRingPath)
+	return
+}
+
+// exportKey writes the selected public key, or secret key if secret is
+// true, to outFile or standard output.
+func exportKey(selector string, secret bool, outFile string) {
+	var keyRing *openpgp.KeyRing
+	var err error
+	if secret {
+		keyRing = loadSecRingOrExit()
+	} else if keyRing, err = openpgp.LoadKeyRing(openpgp.PubRingPath); err != nil {
+		failErr(err, "Couldn't open public keyring")
+	}
+
+	e, err := keyRing.Find(selector)
+	if err != nil {
+		failErr(err, "Export failed")
+	}
+	fpr := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
+	var armoured string
+	if secret {
+		armoured, err = keyRing.ExportSecret(fpr)
+	} else {
+		armoured, err = keyRing.Export(fpr)
+	}
+	if err != nil {
+		failErr(err, "Export failed")
+	}
+
+	doc := struct {
+		Fingerprint string `json:"fingerprint"`
+		Secret      bool   `json:"secret"`
+		Key         string `json:"key,omitempty"`
+		File        string `json:"file,omitempty"`
+	}{Fingerprint: fpr, Secret: secret}
+	if outFile == "" || outFile == "-" {
+		if jsonOutput {
+			doc.Key = armoured
+			emit(doc)
+		} else {
+			fmt.Println(armoured)
+		}
+		return
+	}
+	err = ioutil.WriteFile(outFile, []byte(armoured+"\n"), 0600)
+	if err != nil {
+		failErr(err, "Couldn't write the key")
+	}
+	if jsonOutput {
+		doc.File = outFile
+		emit(doc)
+	}
+}
+
+// confirm asks a yes or no question, exiting with msg unless the
+// answer is yes.
+func confirm(prompt, msg string) {
+	answer, err := readPrompt(prompt)
+	if err != nil || !strings.EqualFold(answer, "y") && !strings.EqualFold(answer, "yes") {
+		fail(exitFailure, msg)
+	}
+}
+
+// deleteLocalKey removes the selected key, and its secret key if there
+// is one, from the keyrings after asking for confirmation.
+func deleteLocalKey(selector string) {
+	pubRing, err := openpgp.LoadKeyRing(openpgp.PubRingPath)
+	if err != nil {
+		failErr(err, "Couldn't open public keyring")
+	}
+
+	e, err := pubRing.Find(selector)
+	if err != nil {
+		failErr(err, "Key not found")
+	}
+	fpr := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
+
+	secRing, err := openpgp.LoadKeyRing(openpgp.SecRingPath)
+	if err != nil && !os.IsNotExist(err) {
+		failErr(err, "Failed to load GnuPG secret keyring")
+	}
+	hasSecret := secRing != nil && secRing.Entity(fpr) != nil
+
+	printKey(e, false, false)
+	prompt := "Delete this key? (y/N) "
+	if hasSecret {
+		prompt = "Delete this key and its SECRET KEY? (y/N) "
+	}
+	confirm(prompt, "Not deleted.")
+
+	if hasSecret {
+		if _, err = secRing.Delete(fpr); err != nil {
+			failErr(err, "Delete failed")
+		}
+		storeKeyRing(secRing, openpgp.SecRingPath)
+	}
+	if _, err = pubRing.Delete(fpr); err != nil {
+		failErr(err, "Delete failed")
+	}
+	storeKeyRing(pubRing, openpgp.PubRingPath)
+	if jsonOutput {
+		emit(struct {
+			Deleted string `json:"deleted"`
+			Secret  bool   `json:"secret"`
+		}{fpr, hasSecret})
+		return
+	}
+	fmt.Printf("Deleted %X.\n", e.PrimaryKey.Fingerprint)
+}
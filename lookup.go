@@ -0,0 +1,509 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokyle/keybase/api"
+	"github.com/gokyle/keybase/openpgp"
+	xopenpgp "golang.org/x/crypto/openpgp"
+)
+
+// bioWidth is the width lookup wraps bios to, not counting the indent.
+const bioWidth = 64
+
+// groupFingerprint formats a fingerprint for reading as GnuPG does, in
+// groups of four digits with a wider gap in the middle.
+func groupFingerprint(fpr string) string {
+	fpr = strings.ToUpper(fpr)
+	var groups []string
+	for len(fpr) > 4 {
+		groups = append(groups, fpr[:4])
+		fpr = fpr[4:]
+	}
+	groups = append(groups, fpr)
+	if len(groups) == 10 {
+		return strings.Join(groups[:5], " ") + "  " + strings.Join(groups[5:], " ")
+	}
+	return strings.Join(groups, " ")
+}
+
+// wrapText wraps text into lines of at most width characters, keeping
+// its line breaks. Words longer than width get a line of their own.
+// Blank text has no lines.
+func wrapText(text string, width int) (lines []string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	for _, para := range strings.Split(text, "\n") {
+		var line string
+		for _, word := range strings.Fields(para) {
+			if line != "" && len(line)+1+len(word) > width {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		lines = append(lines, line)
+	}
+	return
+}
+
+// accountKeys reads every public key on a keybase account from its
+// bundles, skipping any that can't be read.
+func accountKeys(user *api.User) (keys xopenpgp.EntityList) {
+	bundles := user.PublicKeys.AllBundles
+	if len(bundles) == 0 && user.PublicKeys.Primary != nil {
+		bundles = []string{user.PublicKeys.Primary.Bundle}
+	}
+
+	seen := map[string]bool{}
+	for _, bundle := range bundles {
+		el, err := xopenpgp.ReadArmoredKeyRing(strings.NewReader(bundle))
+		if err != nil {
+			continue
+		}
+		for _, e := range el {
+			fpr := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
+			if !seen[fpr] {
+				seen[fpr] = true
+				keys = append(keys, e)
+			}
+		}
+	}
+	return
+}
+
+// findKey returns the key in keys with the given fingerprint, or nil.
+func findKey(keys xopenpgp.EntityList, fpr string) *xopenpgp.Entity {
+	for _, e := range keys {
+		if strings.EqualFold(fmt.Sprintf("%x", e.PrimaryKey.Fingerprint), fpr) {
+			return e
+		}
+	}
+	return nil
+}
+
+// keyExpiry returns when a key expires, or the zero time if it
+// doesn't. The key itself is checked if it could be read; otherwise
+// keybase.io's record of its expiry is used.
+func keyExpiry(e *xopenpgp.Entity, pub *api.Key) time.Time {
+	if e != nil {
+		return openpgp.CheckEntity(e, time.Now())[0].Expires
+	} else if pub != nil && pub.Expires != 0 {
+		return time.Unix(int64(pub.Expires), 0)
+	}
+	return time.Time{}
+}
+
+func expiryString(expires time.Time) string {
+	switch {
+	case expires.IsZero():
+		return "never"
+	case expires.Before(time.Now()):
+		return expires.Format(displayTime) + " (expired)"
+	}
+	return expires.Format(displayTime)
+}
+
+// checkFields checks the parts of a user given to lookup -fields,
+// returning them with basics added, which lookup always needs.
+func checkFields(list string) (fields []string, err error) {
+	if list == "" {
+		return
+	}
+	fields = []string{"basics"}
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		known := false
+		for _, f := range api.LookupFields {
+			known = known || f == field
+		}
+		if !known {
+			err = fmt.Errorf("unknown field %s; use %s", field, strings.Join(api.LookupFields, ", "))
+			return
+		} else if field != "basics" {
+			fields = append(fields, field)
+		}
+	}
+	return
+}
+
+// wantField returns true if field was asked for; no fields means all
+// of them.
+func wantField(fields []string, field string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// A userDoc describes a keybase user in JSON output.
+type userDoc struct {
+	Username        string              `json:"username"`
+	ID              string              `json:"id"`
+	Created         time.Time           `json:"created"`
+	Modified        time.Time           `json:"modified"`
+	FullName        string              `json:"full_name,omitempty"`
+	Location        string              `json:"location,omitempty"`
+	Bio             string              `json:"bio,omitempty"`
+	Picture         string              `json:"picture,omitempty"`
+	Email           string              `json:"email,omitempty"`
+	PublicKey       *publicKeyDoc       `json:"public_key,omitempty"`
+	Keys            []*keyDoc           `json:"keys,omitempty"`
+	Proofs          []*proofDoc         `json:"proofs,omitempty"`
+	CryptoAddresses map[string][]string `json:"cryptocurrency_addresses,omitempty"`
+	Sigchain        *api.SigchainLink   `json:"sigchain,omitempty"` // the last link
+}
+
+// A publicKeyDoc describes a public key on keybase.io in JSON output.
+type publicKeyDoc struct {
+	KID         string     `json:"kid"`
+	Fingerprint string     `json:"fingerprint"`
+	Created     time.Time  `json:"created"`
+	Modified    time.Time  `json:"modified"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	Warnings    []string   `json:"warnings,omitempty"`
+}
+
+// A proofDoc describes a proof of another account in JSON output.
+type proofDoc struct {
+	Service  string `json:"service"`
+	Username string `json:"username"`
+	State    string `json:"state"`
+	URL      string `json:"url,omitempty"`
+	ProofURL string `json:"proof_url,omitempty"`
+}
+
+func newUserDoc(user *api.User, fields []string) *userDoc {
+	doc := &userDoc{
+		Username: user.Basics.Username,
+		ID:       user.ID,
+		Created:  unixTime(user.Basics.Created),
+		Modified: unixTime(user.Basics.Modified),
+		FullName: user.Profile.FullName,
+		Location: user.Profile.Location,
+		Bio:      user.Profile.Bio,
+		Email:    user.Emails.Primary.Email,
+	}
+	if pic := user.Pictures["primary"]; pic != nil {
+		doc.Picture = pic.URL
+	}
+
+	if last := user.Sigs.Last; last != nil && wantField(fields, "sigs") {
+		doc.Sigchain = last
+	}
+
+	var keys xopenpgp.EntityList
+	if wantField(fields, "public_keys") {
+		keys = accountKeys(user)
+	}
+	if pub := user.PublicKeys.Primary; pub != nil && wantField(fields, "public_keys") {
+		doc.PublicKey = &publicKeyDoc{
+			KID:         pub.KeyID,
+			Fingerprint: pub.Fingerprint,
+			Created:     unixTime(pub.Created),
+			Modified:    unixTime(pub.Modified),
+			Warnings:    keyWarnings(pub.Bundle),
+		}
+		if expires := keyExpiry(findKey(keys, pub.Fingerprint), pub); !expires.IsZero() {
+			expires = expires.UTC()
+			doc.PublicKey.Expires = &expires
+		}
+	}
+	for _, e := range keys {
+		doc.Keys = append(doc.Keys, entityDoc(e, false, true))
+	}
+
+	for _, proof := range user.Proofs.All {
+		doc.Proofs = append(doc.Proofs, &proofDoc{
+			Service:  proof.Type,
+			Username: proof.Nametag,
+			State:    proof.StateName(),
+			URL:      proof.ServiceURL,
+			ProofURL: proof.ProofURL,
+		})
+	}
+
+	for currency, addrs := range user.CryptoAddresses {
+		if doc.CryptoAddresses == nil {
+			doc.CryptoAddresses = map[string][]string{}
+		}
+		for _, addr := range addrs {
+			doc.CryptoAddresses[currency] = append(doc.CryptoAddresses[currency], addr.Address)
+		}
+	}
+	return doc
+}
+
+// A failedDoc describes a user who couldn't be looked up in JSON
+// output.
+type failedDoc struct {
+	Username string `json:"username"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+func newFailedDoc(name string, err error) *failedDoc {
+	return &failedDoc{name, exitCodes[exitStatus(err)], err.Error()}
+}
+
+// proofTypes maps the services lookup accepts to the proof types in a
+// user's proofs summary.
+var proofTypes = map[string]string{
+	"twitter":    "twitter",
+	"github":     "github",
+	"reddit":     "reddit",
+	"hackernews": "hackernews",
+	"coinbase":   "coinbase",
+	"dns":        "dns",
+	"web":        "generic_web_site",
+}
+
+// checkLookup checks a lookup argument: a username, fingerprint:<fpr>,
+// kid:<kid> or <service>:<name>.
+func checkLookup(arg string) error {
+	i := strings.Index(arg, ":")
+	if i < 0 {
+		return nil
+	} else if kind := arg[:i]; kind == "fingerprint" || kind == "kid" || proofTypes[kind] != "" {
+		return nil
+	}
+	return fmt.Errorf("unknown lookup %s; use fingerprint:, kid: or one of %s", arg, strings.Join(lookupServices(), ", "))
+}
+
+func lookupServices() (services []string) {
+	for service := range proofTypes {
+		services = append(services, service+":")
+	}
+	sort.Strings(services)
+	return
+}
+
+// withField adds field to fields, unless all fields are being fetched.
+func withField(fields []string, field string) []string {
+	if wantField(fields, field) {
+		return fields
+	}
+	return append(fields[:len(fields):len(fields)], field)
+}
+
+// lookupBy looks up the user with the key or proof in a lookup
+// argument, and checks that the user found has it.
+func lookupBy(arg string, fields []string) (user *api.User, err error) {
+	i := strings.Index(arg, ":")
+	kind, value := arg[:i], arg[i+1:]
+	switch kind {
+	case "fingerprint":
+		user, err = api.LookupByFingerprint(value, withField(fields, "public_keys")...)
+		if err != nil {
+			return
+		}
+		fpr := strings.ToLower(strings.Replace(value, " ", "", -1))
+		pub := user.PublicKeys.Primary
+		if findKey(accountKeys(user), fpr) == nil && (pub == nil || !strings.EqualFold(pub.Fingerprint, fpr)) {
+			user, err = nil, api.ErrKeyMismatch
+		}
+	case "kid":
+		user, err = api.LookupByKID(value, fields...)
+	default:
+		user, err = api.LookupByProof(kind, value, withField(fields, "proofs_summary")...)
+		if err != nil {
+			return
+		}
+		for _, proof := range user.Proofs.All {
+			if proof.Type == proofTypes[kind] && strings.EqualFold(proof.Nametag, value) {
+				return
+			}
+		}
+		user, err = nil, &statusError{exitVerify, fmt.Sprintf("%s doesn't list a %s proof for %s",
+			user.Basics.Username, kind, value)}
+	}
+	return
+}
+
+// lookupUsers looks up users by name, in batches, and by key or proof,
+// returning the results in the order of args.
+func lookupUsers(args, fields []string) []*api.LookupResult {
+	results := make([]*api.LookupResult, len(args))
+	var names, others []string
+	var nameAt, otherAt []int
+	for i, arg := range args {
+		if strings.Contains(arg, ":") {
+			others = append(others, arg)
+			otherAt = append(otherAt, i)
+		} else {
+			names = append(names, arg)
+			nameAt = append(nameAt, i)
+		}
+	}
+
+	for i, result := range api.LookupUsers(names, fields) {
+		results[nameAt[i]] = result
+	}
+	lookup := func(arg string) (*api.User, error) {
+		return lookupBy(arg, fields)
+	}
+	for i, result := range api.LookupAll(others, 0, lookup) {
+		results[otherAt[i]] = result
+	}
+	return results
+}
+
+// lookup prints information about each of the named users, or with
+// -json a document listing them. Only the given api.LookupFields are
+// shown, or all of them if none are given. Each user is checked
+// against their pin. Users that can't be looked up, or don't match
+// their pins, are reported once the rest have been printed, and the
+// command exits with the status for the first of them.
+func lookup(names []string, fields []string) {
+	doc := struct {
+		Users  []*userDoc   `json:"users"`
+		Failed []*failedDoc `json:"failed,omitempty"`
+	}{Users: []*userDoc{}}
+
+	fetch := fields
+	for _, field := range pinFields {
+		fetch = withField(fetch, field)
+	}
+	pins := loadPinsOrExit()
+	var status int
+	for _, result := range lookupUsers(names, fetch) {
+		err := result.Err
+		if err == nil {
+			err = pins.check(result.User)
+		}
+		if err != nil {
+			if status == 0 {
+				status = exitStatus(err)
+			}
+			doc.Failed = append(doc.Failed, newFailedDoc(result.Query, err))
+			continue
+		}
+		if jsonOutput {
+			doc.Users = append(doc.Users, newUserDoc(result.User, fields))
+		} else {
+			printUser(result.User, fields)
+		}
+	}
+	pins.storeOrExit()
+
+	if jsonOutput {
+		emit(doc)
+	} else {
+		for _, failed := range doc.Failed {
+			fmt.Fprintf(os.Stderr, "Lookup failed for %s: %s\n", failed.Username, failed.Message)
+		}
+	}
+	if status != 0 {
+		os.Exit(status)
+	}
+}
+
+func printUser(user *api.User, fields []string) {
+	fmt.Printf("Details for user %s:\n", user.Basics.Username)
+	fmt.Printf("\tCreated: %s\n", unixToString(user.Basics.Created))
+	fmt.Printf("\tLast modified: %s\n", unixToString(user.Basics.Modified))
+	if email := user.Emails.Primary; email.Email != "" {
+		verified := ""
+		if email.Verified != 0 {
+			verified = " (verified)"
+		}
+		fmt.Printf("\tEmail: %s%s\n", email.Email, verified)
+	}
+	if pic := user.Pictures["primary"]; pic != nil && wantField(fields, "pictures") {
+		fmt.Printf("\tPicture: %s\n", pic.URL)
+	}
+
+	if wantField(fields, "profile") {
+		fmt.Printf("\tProfile:\n")
+		fmt.Printf("\t\tLast updated: %s\n", unixToString(user.Profile.Modified))
+		fmt.Printf("\t\tFull name: %s\n", user.Profile.FullName)
+		fmt.Printf("\t\tLocation: %s\n", user.Profile.Location)
+		fmt.Printf("\t\tBio:\n")
+		for _, line := range wrapText(user.Profile.Bio, bioWidth) {
+			fmt.Printf("\t\t\t%s\n", line)
+		}
+	}
+
+	if wantField(fields, "public_keys") {
+		printUserKeys(user)
+	}
+
+	if wantField(fields, "proofs_summary") && len(user.Proofs.All) > 0 {
+		fmt.Printf("\tProofs:\n")
+		for _, proof := range user.Proofs.All {
+			fmt.Printf("\t\t%s: %s (%s)\n", proof.Type, proof.Nametag, proof.StateName())
+			if proof.ServiceURL != "" {
+				fmt.Printf("\t\t\t%s\n", proof.ServiceURL)
+			}
+		}
+	}
+
+	if last := user.Sigs.Last; last != nil && wantField(fields, "sigs") {
+		fmt.Printf("\tSignature chain: %d links, the last %s\n", last.SeqNo, last.PayloadHash)
+	}
+
+	if wantField(fields, "cryptocurrency_addresses") && len(user.CryptoAddresses) > 0 {
+		var currencies []string
+		for currency := range user.CryptoAddresses {
+			currencies = append(currencies, currency)
+		}
+		sort.Strings(currencies)
+
+		fmt.Printf("\tCryptocurrency addresses:\n")
+		for _, currency := range currencies {
+			for _, addr := range user.CryptoAddresses[currency] {
+				fmt.Printf("\t\t%s: %s\n", currency, addr.Address)
+			}
+		}
+	}
+}
+
+// printUserKeys prints a user's primary key, then any other keys on
+// their account.
+func printUserKeys(user *api.User) {
+	keys := accountKeys(user)
+	pub := user.PublicKeys.Primary
+	if pub == nil {
+		fmt.Printf("\tNo public key.\n")
+	} else {
+		fmt.Printf("\tPublic key\n")
+		fmt.Printf("\t\tKey ID: %s\n", pub.KeyID)
+		fmt.Printf("\t\tFingerprint: %s\n", groupFingerprint(pub.Fingerprint))
+		fmt.Printf("\t\tCreated: %s\n", unixToString(pub.Created))
+		fmt.Printf("\t\tLast modified: %s\n", unixToString(pub.Modified))
+		fmt.Printf("\t\tExpires: %s\n", expiryString(keyExpiry(findKey(keys, pub.Fingerprint), pub)))
+		for _, warning := range keyWarnings(pub.Bundle) {
+			fmt.Printf("\t\tWarning: %s\n", warning)
+		}
+	}
+
+	var others xopenpgp.EntityList
+	for _, e := range keys {
+		if pub == nil || !strings.EqualFold(fmt.Sprintf("%x", e.PrimaryKey.Fingerprint), pub.Fingerprint) {
+			others = append(others, e)
+		}
+	}
+	if len(others) == 0 {
+		return
+	}
+	fmt.Printf("\tOther keys\n")
+	for _, e := range others {
+		fmt.Printf("\t\t%s %s\n", algoName(e.PrimaryKey), groupFingerprint(fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)))
+		fmt.Printf("\t\t\tCreated: %s\n", e.PrimaryKey.CreationTime.Format(displayTime))
+		fmt.Printf("\t\t\tExpires: %s\n", expiryString(keyExpiry(e, nil)))
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestWrapText validates wrapping a bio.
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		lines []string
+	}{
+		{"", 10, nil},
+		{"  \n\t ", 10, nil},
+		{"short", 10, []string{"short"}},
+		{"  padded  ", 10, []string{"padded"}},
+		{"one two three four", 9, []string{"one two", "three", "four"}},
+		{"exactly ten", 11, []string{"exactly ten"}},
+		{"a supercalifragilistic word", 10, []string{"a", "supercalifragilistic", "word"}},
+		{"supercalifragilistic", 10, []string{"supercalifragilistic"}},
+		{"first line\nsecond", 20, []string{"first line", "second"}},
+		{"one paragraph\n\nanother", 20, []string{"one paragraph", "", "another"}},
+		{"spaced   out\twords", 20, []string{"spaced out words"}},
+	}
+
+	for _, test := range tests {
+		if lines := wrapText(test.text, test.width); !reflect.DeepEqual(lines, test.lines) {
+			t.Fatalf("%q at %d: expected %q, got %q", test.text, test.width, test.lines, lines)
+		}
+	}
+}
+
+// TestGroupFingerprint validates grouping fingerprints as GnuPG does.
+func TestGroupFingerprint(t *testing.T) {
+	tests := []struct {
+		fpr     string
+		grouped string
+	}{
+		{"1f72f8b9cf8d215881e3c1d0af7db9c0ccaff8eb", "1F72 F8B9 CF8D 2158 81E3  C1D0 AF7D B9C0 CCAF F8EB"},
+		{"AF7DB9C0CCAFF8EB", "AF7D B9C0 CCAF F8EB"},
+		{"abcdef", "ABCD EF"},
+		{"abcd", "ABCD"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if grouped := groupFingerprint(test.fpr); grouped != test.grouped {
+			t.Fatalf("%s: expected %q, got %q", test.fpr, test.grouped, grouped)
+		}
+	}
+}
+
+// TestCheckFields validates the -fields given to lookup.
+func TestCheckFields(t *testing.T) {
+	tests := []struct {
+		list   string
+		fields []string
+		err    string // part of the error expected, if any
+	}{
+		{"", nil, ""},
+		{"profile", []string{"basics", "profile"}, ""},
+		{"basics", []string{"basics"}, ""},
+		{"public_keys, proofs_summary", []string{"basics", "public_keys", "proofs_summary"}, ""},
+		{"profile,bogus", nil, "unknown field bogus"},
+		{"Profile", nil, "unknown field Profile"},
+		{"profile,", nil, "unknown field "},
+	}
+
+	for _, test := range tests {
+		fields, err := checkFields(test.list)
+		switch {
+		case test.err == "" && err != nil:
+			t.Fatalf("%q: %v", test.list, err)
+		case test.err == "" && !reflect.DeepEqual(fields, test.fields):
+			t.Fatalf("%q: expected %q, got %q", test.list, test.fields, fields)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Fatalf("%q: expected an error with %q, got %v", test.list, test.err, err)
+		}
+	}
+}
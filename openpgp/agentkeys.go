@@ -0,0 +1,909 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/elgamal"
+	"golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
+)
+
+// Protection modes used by gpg-agent for keys it has protected itself;
+// see agent/protect.c in the GnuPG sources.
+const (
+	protectCBC    = "openpgp-s2k3-sha1-aes-cbc"
+	protectOCB    = "openpgp-s2k3-ocb-aes"
+	protectNative = "openpgp-native"
+)
+
+// An agentKey is a secret key stored by gpg-agent in a form that
+// packet.PrivateKey can't decrypt itself. It is kept alongside the
+// placeholder private key until the key is unlocked.
+type agentKey struct {
+	algo *sexp // the algorithm list, e.g. (rsa (n ..) (e ..) (protected ..))
+}
+
+// readAgentKeys parses every key file in a private-keys-v1.d
+// directory. Files that can't be parsed are skipped.
+func readAgentKeys(dir string) (keys []*sexp, err error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.key"))
+	if err != nil {
+		return
+	}
+
+	for _, name := range names {
+		var data []byte
+		data, err = ioutil.ReadFile(name)
+		if err != nil {
+			return
+		}
+
+		var key *sexp
+		key, err = parseKeyFile(data)
+		if err != nil {
+			err = nil
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return
+}
+
+// publicMaterial returns a string identifying the public parameters
+// of pk, used to pair OpenPGP keys with gpg-agent keys without
+// needing to compute keygrips.
+func publicMaterial(pk *packet.PublicKey) string {
+	switch pub := pk.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "rsa:" + pub.N.Text(16)
+	case *dsa.PublicKey:
+		return "dsa:" + pub.Y.Text(16)
+	case *elgamal.PublicKey:
+		return "elg:" + pub.Y.Text(16)
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ecc:%x", elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+	}
+	return ""
+}
+
+// agentAlgo returns the algorithm list of a gpg-agent key.
+func agentAlgo(key *sexp) *sexp {
+	switch key.Name() {
+	case "private-key", "protected-private-key":
+		return key.Nth(1)
+	}
+	return nil
+}
+
+// agentPublicMaterial is the counterpart to publicMaterial for a key
+// stored by gpg-agent.
+func agentPublicMaterial(key *sexp) string {
+	algo := agentAlgo(key)
+	mpi := func(name string) string {
+		return new(big.Int).SetBytes(algo.Value(name)).Text(16)
+	}
+
+	switch algo.Name() {
+	case "rsa":
+		return "rsa:" + mpi("n")
+	case "dsa":
+		return "dsa:" + mpi("y")
+	case "elg", "openpgp-elg", "openpgp-elg-sig":
+		return "elg:" + mpi("y")
+	case "ecc", "ecdsa":
+		return fmt.Sprintf("ecc:%x", algo.Value("q"))
+	}
+	return ""
+}
+
+// newAgentPrivateKey builds the private key for pub from the gpg-agent
+// key expression. If the key is protected, the returned agentKey must
+// be used to unlock it; keys protected by a legacy OpenPGP passphrase
+// can be decrypted directly.
+func newAgentPrivateKey(pub *packet.PublicKey, key *sexp) (priv *packet.PrivateKey, ak *agentKey, err error) {
+	switch pub.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly, packet.PubKeyAlgoRSAEncryptOnly,
+		packet.PubKeyAlgoDSA, packet.PubKeyAlgoElGamal, packet.PubKeyAlgoECDSA:
+	default:
+		err = errors.UnsupportedError("secret key algorithm")
+		return
+	}
+
+	algo := agentAlgo(key)
+	if key.Name() == "private-key" {
+		priv = &packet.PrivateKey{PublicKey: *pub}
+		err = setAgentParams(priv, algo)
+		if err != nil {
+			priv = nil
+		}
+		return
+	}
+
+	protected := algo.Find("protected")
+	switch mode := protected.Nth(1).String(); mode {
+	case protectNative:
+		priv, err = nativePrivateKey(pub, protected.Nth(2))
+	case protectCBC, protectOCB:
+		priv = &packet.PrivateKey{PublicKey: *pub, Encrypted: true}
+		ak = &agentKey{algo: algo}
+	default:
+		err = errors.UnsupportedError("gpg-agent key protection " + mode)
+	}
+	return
+}
+
+// unprotect removes gpg-agent's protection from the key, storing the
+// decrypted key material in priv.
+func (ak *agentKey) unprotect(priv *packet.PrivateKey, passphrase []byte) (err error) {
+	protected := ak.algo.Find("protected")
+	mode := protected.Nth(1).String()
+	params := protected.Nth(2)
+	blob := protected.Nth(3)
+	s2kParams := params.Nth(0)
+	iv := params.Nth(1)
+	if s2kParams.Name() != "sha1" || iv == nil || blob == nil {
+		return errors.UnsupportedError("gpg-agent key protection parameters")
+	}
+
+	salt := s2kParams.Nth(1).atom
+	count, err := strconv.Atoi(s2kParams.Nth(2).String())
+	if err != nil || len(salt) != 8 {
+		return errors.StructuralError("invalid gpg-agent S2K parameters")
+	}
+
+	key := make([]byte, 16)
+	s2k.Iterated(key, sha1.New(), passphrase, salt, count)
+	block, err := aes.NewCipher(key)
+	zero(key)
+	if err != nil {
+		return
+	}
+
+	var plain []byte
+	if mode == protectOCB {
+		// Everything in the algorithm list but the protected
+		// parameters themselves is authenticated.
+		aad := &sexp{isList: true}
+		for _, elt := range ak.algo.list {
+			if elt.Name() != "protected" {
+				aad.list = append(aad.list, elt)
+			}
+		}
+
+		plain, err = newOCB(block).Open(iv.atom, blob.atom, aad.Canonical())
+		if err != nil {
+			return ErrBadPassphrase
+		}
+	} else {
+		if len(iv.atom) != aes.BlockSize || len(blob.atom)%aes.BlockSize != 0 {
+			return errors.StructuralError("invalid gpg-agent CBC parameters")
+		}
+		plain = make([]byte, len(blob.atom))
+		cipher.NewCBCDecrypter(block, iv.atom).CryptBlocks(plain, blob.atom)
+	}
+	defer zero(plain)
+
+	secret, err := parseSexp(plain)
+	if err != nil {
+		return ErrBadPassphrase
+	}
+
+	// The secret parameters are wrapped in a list, which in CBC
+	// mode is followed by a hash of the key:
+	// (((d ..) (p ..) ..) (hash sha1 ..)).
+	if first := secret.Nth(0); first != nil && first.isList && first.Nth(0) != nil && first.Nth(0).isList {
+		secret = first
+	}
+
+	params = &sexp{isList: true, list: []*sexp{sexpAtom([]byte(ak.algo.Name()))}}
+	params.list = append(params.list, secret.list...)
+	err = setAgentParams(priv, params)
+	if err != nil {
+		return ErrBadPassphrase
+	}
+	return
+}
+
+// setAgentParams sets the secret key from the secret parameters in
+// algo, an (algo (name value) ...) list, checking that they match the
+// public key.
+func setAgentParams(priv *packet.PrivateKey, algo *sexp) (err error) {
+	mpi := func(name string) *big.Int {
+		v := algo.Value(name)
+		if v == nil {
+			err = errors.StructuralError("missing secret key parameter " + name)
+			return new(big.Int)
+		}
+		return new(big.Int).SetBytes(v)
+	}
+
+	var key interface{}
+	switch pub := priv.PublicKey.PublicKey.(type) {
+	case *rsa.PublicKey:
+		rsaPriv := &rsa.PrivateKey{
+			PublicKey: *pub,
+			D:         mpi("d"),
+			Primes:    []*big.Int{mpi("p"), mpi("q")},
+		}
+		if err != nil {
+			return
+		}
+		if err = rsaPriv.Validate(); err != nil {
+			return
+		}
+		rsaPriv.Precompute()
+		key = rsaPriv
+	case *dsa.PublicKey:
+		x := mpi("x")
+		if err == nil && new(big.Int).Exp(pub.G, x, pub.P).Cmp(pub.Y) != 0 {
+			err = errors.StructuralError("secret key doesn't match public key")
+		}
+		key = &dsa.PrivateKey{PublicKey: *pub, X: x}
+	case *elgamal.PublicKey:
+		x := mpi("x")
+		if err == nil && new(big.Int).Exp(pub.G, x, pub.P).Cmp(pub.Y) != 0 {
+			err = errors.StructuralError("secret key doesn't match public key")
+		}
+		key = &elgamal.PrivateKey{PublicKey: *pub, X: x}
+	case *ecdsa.PublicKey:
+		d := mpi("d")
+		if err == nil {
+			x, y := pub.Curve.ScalarBaseMult(d.Bytes())
+			if x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+				err = errors.StructuralError("secret key doesn't match public key")
+			}
+		}
+		key = &ecdsa.PrivateKey{PublicKey: *pub, D: d}
+	default:
+		err = errors.UnsupportedError("secret key algorithm")
+	}
+	if err != nil {
+		return
+	}
+
+	priv.PrivateKey = key
+	priv.Encrypted = false
+	return
+}
+
+// OpenPGP identifiers for the libgcrypt cipher and hash names used in
+// openpgp-native keys.
+var (
+	nativeCiphers = map[string]byte{
+		"3DES": 2, "CAST5": 3, "AES": 7, "AES128": 7, "AES192": 8, "AES256": 9,
+	}
+	nativeHashes = map[string]byte{
+		"MD5": 1, "SHA1": 2, "RIPEMD160": 3, "SHA256": 8, "SHA384": 9, "SHA512": 10, "SHA224": 11,
+	}
+)
+
+// nativePrivateKey rebuilds the OpenPGP secret key packet stored in an
+// openpgp-native key, which is how gpg-agent keeps keys imported from
+// an OpenPGP keyring until their passphrase is first used. native is
+// the (openpgp-private-key ...) expression.
+func nativePrivateKey(pub *packet.PublicKey, native *sexp) (priv *packet.PrivateKey, err error) {
+	body, err := publicKeyBody(pub)
+	if err != nil {
+		return
+	}
+
+	protection := native.Find("protection")
+	skey := native.Find("skey")
+	if protection == nil || skey == nil {
+		err = errors.StructuralError("invalid openpgp-native key")
+		return
+	}
+
+	// The skey list alternates flags and values: "_" marks a
+	// plaintext MPI and "e" the encrypted secret parameters. The
+	// public parameters are already in body.
+	nPublic := len(skey.list)
+	var secret []byte
+	for i := 1; i+1 < len(skey.list); i += 2 {
+		if skey.list[i].String() == "e" {
+			secret = skey.list[i+1].atom
+			nPublic = i
+			break
+		}
+	}
+
+	switch usage := protection.Nth(1).String(); usage {
+	case "none":
+		if nPublic != len(skey.list) {
+			err = errors.StructuralError("unprotected openpgp-native key with encrypted data")
+			return
+		}
+		body = append(body, 0)
+		var mpis []byte
+		for i := 1 + 2*publicParamCount(pub); i+1 < len(skey.list); i += 2 {
+			mpis = append(mpis, encodeMPI(skey.list[i+1].atom)...)
+		}
+		body = append(body, mpis...)
+		sum := mod64kHash(mpis)
+		body = append(body, byte(sum>>8), byte(sum))
+	case "sha1", "sum":
+		cipherID, ok := nativeCiphers[protection.Nth(2).String()]
+		if !ok {
+			err = errors.UnsupportedError("openpgp-native cipher " + protection.Nth(2).String())
+			return
+		}
+		hashID, ok := nativeHashes[protection.Nth(5).String()]
+		if !ok {
+			err = errors.UnsupportedError("openpgp-native hash " + protection.Nth(5).String())
+			return
+		}
+		iv := protection.Nth(3).atom
+		salt := protection.Nth(6).atom
+
+		var mode, count int
+		mode, err = strconv.Atoi(protection.Nth(4).String())
+		if err != nil {
+			return
+		}
+		count, err = strconv.Atoi(protection.Nth(7).String())
+		if err != nil {
+			return
+		}
+
+		if usage == "sha1" {
+			body = append(body, 254)
+		} else {
+			body = append(body, 255)
+		}
+		body = append(body, cipherID, byte(mode), hashID)
+		switch mode {
+		case 0:
+		case 1:
+			body = append(body, salt...)
+		case 3:
+			body = append(body, salt...)
+			body = append(body, encodeS2KCount(count))
+		default:
+			err = errors.UnsupportedError("openpgp-native S2K mode")
+			return
+		}
+		body = append(body, iv...)
+		body = append(body, secret...)
+	default:
+		err = errors.UnsupportedError("openpgp-native protection " + usage)
+		return
+	}
+
+	var tag byte = tagSecretKey
+	if pub.IsSubkey {
+		tag = tagSecretSubkey
+	}
+	buf := new(bytes.Buffer)
+	err = serializePacket(buf, tag, body)
+	if err != nil {
+		return
+	}
+
+	p, err := packet.Read(buf)
+	if err != nil {
+		return
+	}
+	priv, ok := p.(*packet.PrivateKey)
+	if !ok {
+		err = errors.StructuralError("openpgp-native key isn't a secret key")
+	}
+	return
+}
+
+// publicParamCount returns the number of public MPIs for pub's
+// algorithm in an openpgp-native skey list.
+func publicParamCount(pub *packet.PublicKey) int {
+	switch pub.PubKeyAlgo {
+	case packet.PubKeyAlgoDSA:
+		return 4
+	case packet.PubKeyAlgoElGamal:
+		return 3
+	}
+	return 2
+}
+
+// encodeMPI returns the OpenPGP MPI encoding of the big-endian
+// integer in b.
+func encodeMPI(b []byte) []byte {
+	b = bytes.TrimLeft(b, "\x00")
+	bits := 0
+	if len(b) > 0 {
+		bits = (len(b)-1)*8 + big.NewInt(int64(b[0])).BitLen()
+	}
+	return append([]byte{byte(bits >> 8), byte(bits)}, b...)
+}
+
+func mod64kHash(d []byte) uint16 {
+	var h uint16
+	for _, b := range d {
+		h += uint16(b)
+	}
+	return h
+}
+
+// encodeS2KCount returns the one-byte encoding of an iterated S2K
+// count. Counts that already fit in a byte are taken to be encoded,
+// as gpg-agent stores them that way for openpgp-native keys.
+func encodeS2KCount(count int) byte {
+	if count >= 0 && count < 256 {
+		return byte(count)
+	}
+	for c := 0; c < 256; c++ {
+		if (16+c&15)<<(uint(c>>4)+6) >= count {
+			return byte(c)
+		}
+	}
+	return 255
+}
+
+// agentPublicRing returns the public keyring that goes with a
+// private-keys-v1.d directory.
+func agentPublicRing(dir string) string {
+	home := filepath.Dir(strings.TrimRight(dir, string(filepath.Separator)))
+	kbx := filepath.Join(home, "pubring.kbx")
+	if fileExists(kbx) {
+		return kbx
+	}
+	return filepath.Join(home, "pubring.gpg")
+}
+
+// attachAgentKeys pairs the public keys in el with the secret keys in
+// keys, returning the entities that have at least one secret key.
+func (keyRing *KeyRing) attachAgentKeys(el openpgp.EntityList, keys []*sexp) (secrets openpgp.EntityList) {
+	byMaterial := map[string]*sexp{}
+	for _, key := range keys {
+		if m := agentPublicMaterial(key); m != "" {
+			byMaterial[m] = key
+		}
+	}
+
+	attach := func(pub *packet.PublicKey) *packet.PrivateKey {
+		key, ok := byMaterial[publicMaterial(pub)]
+		if !ok {
+			return nil
+		}
+		priv, ak, err := newAgentPrivateKey(pub, key)
+		if err != nil {
+			return nil
+		}
+		if ak != nil {
+			keyRing.agentKeys[priv] = ak
+		}
+		keyRing.onDisk[fingerprint(pub)] = true
+		return priv
+	}
+
+	for _, e := range el {
+		var found bool
+		if priv := attach(e.PrimaryKey); priv != nil {
+			e.PrivateKey = priv
+			e.PrimaryKey = &priv.PublicKey
+			found = true
+		}
+		for i := range e.Subkeys {
+			if priv := attach(e.Subkeys[i].PublicKey); priv != nil {
+				e.Subkeys[i].PrivateKey = priv
+				e.Subkeys[i].PublicKey = &priv.PublicKey
+				found = true
+			}
+		}
+		if found {
+			secrets = append(secrets, e)
+		}
+	}
+	return
+}
+
+// storeAgentKeys writes a key file to the private-keys-v1.d directory
+// for each secret key that isn't there yet, or whose passphrase has
+// been changed. Other keys are left as gpg-agent wrote them.
+func (keyRing *KeyRing) storeAgentKeys() (err error) {
+	err = os.MkdirAll(keyRing.path, 0700)
+	if err != nil {
+		return
+	}
+
+	for _, e := range keyRing.ownEntities() {
+		privs := []*packet.PrivateKey{e.PrivateKey}
+		for _, subkey := range e.Subkeys {
+			privs = append(privs, subkey.PrivateKey)
+		}
+
+		for _, priv := range privs {
+			if priv == nil {
+				continue
+			}
+			fpr := fingerprint(&priv.PublicKey)
+			if keyRing.onDisk[fpr] && !keyRing.changed[fpr] {
+				continue
+			}
+
+			err = keyRing.storeAgentKey(priv)
+			if err != nil {
+				return
+			}
+			keyRing.onDisk[fpr] = true
+			delete(keyRing.changed, fpr)
+		}
+	}
+
+	for len(keyRing.removed) > 0 {
+		var grip []byte
+		grip, err = keygrip(keyRing.removed[0])
+		if err != nil {
+			return
+		}
+		err = os.Remove(filepath.Join(keyRing.path, fmt.Sprintf("%X.key", grip)))
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+		err = nil
+		keyRing.removed = keyRing.removed[1:]
+	}
+	return
+}
+
+// storeAgentKey writes priv to its key file, named after its keygrip.
+func (keyRing *KeyRing) storeAgentKey(priv *packet.PrivateKey) (err error) {
+	grip, err := keygrip(&priv.PublicKey)
+	if err != nil {
+		return
+	}
+
+	pkt, err := keyRing.secretPacket(priv)
+	if err != nil {
+		return
+	}
+
+	var key *sexp
+	if priv.Encrypted || keyRing.passphrases[fingerprint(&priv.PublicKey)] != nil {
+		key, err = nativeAgentKey(&priv.PublicKey, pkt)
+	} else {
+		key, err = clearAgentKey(priv)
+	}
+	if err != nil {
+		return
+	}
+
+	path := filepath.Join(keyRing.path, fmt.Sprintf("%X.key", grip))
+	return writeFileAtomic(path, 0600, func(w io.Writer) error {
+		_, err := w.Write(key.Canonical())
+		return err
+	})
+}
+
+// mpiAtom returns the value of an integer in the signed form
+// libgcrypt uses, with a leading zero byte if the top bit is set.
+func mpiAtom(n *big.Int) *sexp {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return sexpAtom(b)
+}
+
+func sexpParam(name string, value *sexp) *sexp {
+	return sexpList(sexpAtom([]byte(name)), value)
+}
+
+// gcryptCurves maps the curves supported by the openpgp package to
+// their libgcrypt names.
+var gcryptCurves = map[string]string{
+	"P-256": "NIST P-256",
+	"P-384": "NIST P-384",
+	"P-521": "NIST P-521",
+}
+
+// agentPublicKey returns the algorithm name and public parameters of
+// pub as gpg-agent stores them.
+func agentPublicKey(pub *packet.PublicKey) (algo string, params []*sexp, err error) {
+	switch pk := pub.PublicKey.(type) {
+	case *rsa.PublicKey:
+		algo = "rsa"
+		params = []*sexp{
+			sexpParam("n", mpiAtom(pk.N)),
+			sexpParam("e", mpiAtom(big.NewInt(int64(pk.E)))),
+		}
+	case *dsa.PublicKey:
+		algo = "dsa"
+		params = []*sexp{
+			sexpParam("p", mpiAtom(pk.P)),
+			sexpParam("q", mpiAtom(pk.Q)),
+			sexpParam("g", mpiAtom(pk.G)),
+			sexpParam("y", mpiAtom(pk.Y)),
+		}
+	case *elgamal.PublicKey:
+		algo = "elg"
+		params = []*sexp{
+			sexpParam("p", mpiAtom(pk.P)),
+			sexpParam("g", mpiAtom(pk.G)),
+			sexpParam("y", mpiAtom(pk.Y)),
+		}
+	case *ecdsa.PublicKey:
+		curve, ok := gcryptCurves[pk.Curve.Params().Name]
+		if !ok {
+			err = errors.UnsupportedError("curve " + pk.Curve.Params().Name)
+			return
+		}
+		algo = "ecc"
+		params = []*sexp{
+			sexpParam("curve", sexpAtom([]byte(curve))),
+			sexpParam("q", sexpAtom(elliptic.Marshal(pk.Curve, pk.X, pk.Y))),
+		}
+	default:
+		err = errors.UnsupportedError("secret key algorithm")
+	}
+	return
+}
+
+// keygrip computes the libgcrypt keygrip of pub, which gpg-agent uses
+// to name key files.
+func keygrip(pub *packet.PublicKey) (grip []byte, err error) {
+	h := sha1.New()
+	switch pk := pub.PublicKey.(type) {
+	case *rsa.PublicKey:
+		h.Write(mpiAtom(pk.N).atom)
+	case *ecdsa.PublicKey:
+		params := pk.Curve.Params()
+		if _, ok := gcryptCurves[params.Name]; !ok {
+			err = errors.UnsupportedError("curve " + params.Name)
+			return
+		}
+		size := (params.BitSize + 7) / 8
+		fixed := func(n *big.Int) []byte {
+			b := make([]byte, size)
+			return n.FillBytes(b)
+		}
+		a := new(big.Int).Sub(params.P, big.NewInt(3))
+		for _, elt := range []struct {
+			name  string
+			value []byte
+		}{
+			{"p", fixed(params.P)},
+			{"a", fixed(a)},
+			{"b", fixed(params.B)},
+			{"g", elliptic.Marshal(pk.Curve, params.Gx, params.Gy)},
+			{"n", fixed(params.N)},
+			{"q", elliptic.Marshal(pk.Curve, pk.X, pk.Y)},
+		} {
+			fmt.Fprintf(h, "(1:%s%d:", elt.name, len(elt.value))
+			h.Write(elt.value)
+			h.Write([]byte(")"))
+		}
+	default:
+		var params []*sexp
+		_, params, err = agentPublicKey(pub)
+		if err != nil {
+			return
+		}
+		for _, param := range params {
+			h.Write(param.Canonical())
+		}
+	}
+	grip = h.Sum(nil)
+	return
+}
+
+// clearAgentKey returns the gpg-agent form of an unprotected key.
+func clearAgentKey(priv *packet.PrivateKey) (key *sexp, err error) {
+	algo, params, err := agentPublicKey(&priv.PublicKey)
+	if err != nil {
+		return
+	}
+
+	switch sk := priv.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		// libgcrypt expects p < q, with u the inverse of p mod q.
+		p, q := sk.Primes[0], sk.Primes[1]
+		if p.Cmp(q) > 0 {
+			p, q = q, p
+		}
+		params = append(params,
+			sexpParam("d", mpiAtom(sk.D)),
+			sexpParam("p", mpiAtom(p)),
+			sexpParam("q", mpiAtom(q)),
+			sexpParam("u", mpiAtom(new(big.Int).ModInverse(p, q))))
+	case *dsa.PrivateKey:
+		params = append(params, sexpParam("x", mpiAtom(sk.X)))
+	case *elgamal.PrivateKey:
+		params = append(params, sexpParam("x", mpiAtom(sk.X)))
+	case *ecdsa.PrivateKey:
+		params = append(params, sexpParam("d", mpiAtom(sk.D)))
+	default:
+		err = errors.UnsupportedError("secret key algorithm")
+		return
+	}
+
+	algoList := sexpList(append([]*sexp{sexpAtom([]byte(algo))}, params...)...)
+	key = sexpList(sexpAtom([]byte("private-key")), algoList)
+	return
+}
+
+// libgcrypt names for the ciphers, hashes and public key algorithms
+// that may appear in an openpgp-native key.
+var (
+	gcryptCiphers = map[packet.CipherFunction]string{
+		packet.Cipher3DES: "3DES", packet.CipherCAST5: "CAST5", packet.CipherAES128: "AES",
+		packet.CipherAES192: "AES192", packet.CipherAES256: "AES256",
+	}
+	gcryptHashes = map[byte]string{
+		1: "MD5", 2: "SHA1", 3: "RIPEMD160", 8: "SHA256", 9: "SHA384", 10: "SHA512", 11: "SHA224",
+	}
+	gcryptAlgos = map[packet.PublicKeyAlgorithm]string{
+		packet.PubKeyAlgoRSA: "RSA", packet.PubKeyAlgoRSAEncryptOnly: "RSA", packet.PubKeyAlgoRSASignOnly: "RSA",
+		packet.PubKeyAlgoDSA: "DSA", packet.PubKeyAlgoElGamal: "ELG", packet.PubKeyAlgoECDSA: "ECDSA",
+	}
+)
+
+// openpgpPublicParams splits the public key parameters out of a public
+// key packet body: MPIs, or for ECC keys the curve OID followed by
+// the point.
+func openpgpPublicParams(pub *packet.PublicKey, body []byte) (params [][]byte, err error) {
+	if len(body) < 6 {
+		err = errors.StructuralError("short public key packet")
+		return
+	}
+	body = body[6:]
+
+	n := publicParamCount(pub)
+	if pub.PubKeyAlgo == packet.PubKeyAlgoECDSA {
+		if len(body) < 1 || len(body) < 1+int(body[0]) {
+			err = errors.StructuralError("short curve OID")
+			return
+		}
+		params = append(params, body[1:1+body[0]])
+		body = body[1+body[0]:]
+		n = 1
+	}
+
+	for i := 0; i < n; i++ {
+		if len(body) < 2 {
+			err = errors.StructuralError("short MPI")
+			return
+		}
+		l := (int(body[0])<<8 | int(body[1]) + 7) / 8
+		if len(body) < 2+l {
+			err = errors.StructuralError("short MPI")
+			return
+		}
+		params = append(params, body[2:2+l])
+		body = body[2+l:]
+	}
+	return
+}
+
+// nativeAgentKey wraps an encrypted OpenPGP secret key packet for pub
+// in gpg-agent's openpgp-native protection, the same form gpg uses
+// when importing secret keys. gpg-agent converts it to its own
+// protection the first time the key is used.
+func nativeAgentKey(pub *packet.PublicKey, pkt []byte) (key *sexp, err error) {
+	pubBody, err := publicKeyBody(pub)
+	if err != nil {
+		return
+	}
+	body, err := packetBody(pkt)
+	if err != nil {
+		return
+	}
+	if len(body) < len(pubBody)+4 || !bytes.Equal(body[:len(pubBody)], pubBody) {
+		err = errors.StructuralError("secret key doesn't match public key")
+		return
+	}
+
+	pubParams, err := openpgpPublicParams(pub, pubBody)
+	if err != nil {
+		return
+	}
+
+	rest := body[len(pubBody):]
+	var usage string
+	switch rest[0] {
+	case 254:
+		usage = "sha1"
+	case 255:
+		usage = "sum"
+	default:
+		err = errors.UnsupportedError("secret key protection")
+		return
+	}
+
+	cipherFunc := packet.CipherFunction(rest[1])
+	cipherName, ok := gcryptCiphers[cipherFunc]
+	if !ok {
+		err = errors.UnsupportedError("secret key cipher")
+		return
+	}
+	mode, hashID := rest[2], rest[3]
+	hashName, ok := gcryptHashes[hashID]
+	if !ok {
+		err = errors.UnsupportedError("secret key S2K hash")
+		return
+	}
+	rest = rest[4:]
+
+	var salt []byte
+	var count byte
+	switch mode {
+	case 0:
+	case 1, 3:
+		if len(rest) < 9 {
+			err = errors.StructuralError("short S2K specifier")
+			return
+		}
+		salt, rest = rest[:8], rest[8:]
+		if mode == 3 {
+			count, rest = rest[0], rest[1:]
+		}
+	default:
+		err = errors.UnsupportedError("S2K mode")
+		return
+	}
+
+	ivSize := 16
+	if cipherFunc == packet.Cipher3DES || cipherFunc == packet.CipherCAST5 {
+		ivSize = 8
+	}
+	if len(rest) < ivSize {
+		err = errors.StructuralError("short secret key IV")
+		return
+	}
+	iv, secret := rest[:ivSize], rest[ivSize:]
+
+	algo, params, err := agentPublicKey(pub)
+	if err != nil {
+		return
+	}
+
+	native := sexpList(
+		sexpAtom([]byte("openpgp-private-key")),
+		sexpParam("version", sexpAtom([]byte("4"))),
+		sexpParam("algo", sexpAtom([]byte(gcryptAlgos[pub.PubKeyAlgo]))))
+
+	// For ECC keys, gpg gives the curve by name rather than
+	// including the OID in the skey list.
+	if pub.PubKeyAlgo == packet.PubKeyAlgoECDSA {
+		native.list = append(native.list, params[0])
+		pubParams = pubParams[1:]
+	}
+
+	skey := []*sexp{sexpAtom([]byte("skey"))}
+	for _, param := range pubParams {
+		skey = append(skey, sexpAtom([]byte("_")), sexpAtom(param))
+	}
+	skey = append(skey, sexpAtom([]byte("e")), sexpAtom(secret))
+
+	native.list = append(native.list,
+		sexpList(skey...),
+		sexpParam("csum", sexpAtom([]byte("0"))),
+		sexpList(
+			sexpAtom([]byte("protection")),
+			sexpAtom([]byte(usage)),
+			sexpAtom([]byte(cipherName)),
+			sexpAtom(iv),
+			sexpAtom([]byte(strconv.Itoa(int(mode)))),
+			sexpAtom([]byte(hashName)),
+			sexpAtom(salt),
+			sexpAtom([]byte(strconv.Itoa(int(count))))))
+
+	params = append(params, sexpList(sexpAtom([]byte("protected")), sexpAtom([]byte(protectNative)), native))
+	algoList := sexpList(append([]*sexp{sexpAtom([]byte(algo))}, params...)...)
+	key = sexpList(sexpAtom([]byte("protected-private-key")), algoList)
+	return
+}
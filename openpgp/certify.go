@@ -0,0 +1,98 @@
+package openpgp
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+var (
+	ErrCertLevel    = errors.New("openpgp: invalid certification level")
+	ErrNoCertifyKey = errors.New("openpgp: no usable certification key")
+	ErrCertifySelf  = errors.New("openpgp: a key can't certify itself")
+)
+
+// Certification levels, from RFC 4880 section 5.2.1, describing how
+// carefully the certifier checked the key's owner.
+const (
+	CertGeneric  = 0 // no statement is made
+	CertPersona  = 1 // the owner wasn't checked
+	CertCasual   = 2 // the owner was checked casually
+	CertPositive = 3 // the owner was checked thoroughly
+)
+
+// Certify signs each valid user ID on target with the named secret key,
+// at the given certification level. User IDs the key has already
+// certified are skipped; the names of those certified are returned.
+// Certifications need the primary key, so keys kept offline can't be
+// used. Expired and revoked keys are refused unless Force is set.
+func (keyRing *KeyRing) Certify(target *openpgp.Entity, signerID string, level int) (certified []string, err error) {
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	if level < CertGeneric || level > CertPositive {
+		err = ErrCertLevel
+		return
+	}
+
+	signer, err := keyRing.find(signerID)
+	if err != nil {
+		return
+	} else if signer.PrivateKey == nil ||
+		!primaryUsable(signer, func(sig *packet.Signature) bool { return sig.FlagCertify }) {
+		err = ErrNoCertifyKey
+		return
+	} else if fingerprint(signer.PrimaryKey) == fingerprint(target.PrimaryKey) {
+		err = ErrCertifySelf
+		return
+	}
+
+	now := DefaultConfig.Now()
+	if !keyRing.Force {
+		if err = checkPrimary(signer, now); err != nil {
+			return
+		} else if err = checkPrimary(target, now); err != nil {
+			return
+		}
+	}
+
+	err = keyRing.unlockKey(signer, signer.PrivateKey)
+	if err != nil {
+		return
+	}
+
+	priv := signer.PrivateKey
+	for _, name := range identityNames(target) {
+		if !identityValid(target, name) {
+			continue
+		}
+
+		id := target.Identities[name]
+		done := false
+		for _, sig := range id.Signatures {
+			if sig.IssuerKeyId != nil && *sig.IssuerKeyId == priv.KeyId && !isRevocation(sig) {
+				done = true
+				break
+			}
+		}
+		if done {
+			continue
+		}
+
+		sig := &packet.Signature{
+			SigType:      packet.SigTypeGenericCert + packet.SignatureType(level),
+			PubKeyAlgo:   priv.PubKeyAlgo,
+			Hash:         DefaultConfig.Hash(),
+			CreationTime: now,
+			IssuerKeyId:  &priv.KeyId,
+		}
+		err = sig.SignUserId(name, target.PrimaryKey, priv, DefaultConfig)
+		if err != nil {
+			return
+		}
+		id.Signatures = append(id.Signatures, sig)
+		certified = append(certified, name)
+	}
+	return
+}
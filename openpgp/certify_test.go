@@ -0,0 +1,79 @@
+package openpgp
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// TestCertify validates certifying another key's user IDs, and that
+// the certifications survive export and import.
+func TestCertify(t *testing.T) {
+	signer, err := NewEntity(&KeyOptions{Name: "Certifier", Email: "certifier@example.net", Algorithm: AlgoECC})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	secRing := NewKeyRing("", true)
+	if err = secRing.Add(signer, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	pubRing, err := LoadKeyRing(testPubRingPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	target := pubRing.Entity("kyle@tyrfingr.is")
+	fpr := fingerprint(target.PrimaryKey)
+
+	if _, err = secRing.Certify(target, "certifier@example.net", 4); err != ErrCertLevel {
+		t.Fatal("invalid certification level was accepted")
+	} else if _, err = secRing.Certify(signer, "certifier@example.net", CertCasual); err != ErrCertifySelf {
+		t.Fatal("key certified itself")
+	}
+
+	certified, err := secRing.Certify(target, "certifier@example.net", CertCasual)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(certified) != len(target.Identities) {
+		t.Fatalf("certified %d of %d user IDs", len(certified), len(target.Identities))
+	}
+
+	for _, name := range certified {
+		sigs := target.Identities[name].Signatures
+		sig := sigs[len(sigs)-1]
+		if sig.SigType != packet.SigTypeCasualCert {
+			t.Fatalf("wrong certification type %x", sig.SigType)
+		}
+		err = signer.PrimaryKey.VerifyUserIdSignature(name, target.PrimaryKey, sig)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	certified, err = secRing.Certify(target, "certifier@example.net", CertCasual)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(certified) != 0 {
+		t.Fatal("user IDs were certified twice")
+	}
+
+	armoured, err := pubRing.Export(fpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	pubRing, err = LoadKeyRing(testPubRingPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	results, err := pubRing.Import(armoured)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if results[0].Signatures != len(target.Identities) {
+		t.Fatalf("expected %d new signatures, have %d", len(target.Identities), results[0].Signatures)
+	}
+
+	offline := loadOfflineKey(t, "")
+	if _, err = offline.Certify(target, offlineKey, CertCasual); err != ErrNoCertifyKey {
+		t.Fatal("key with an offline primary key was used to certify")
+	}
+}
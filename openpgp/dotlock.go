@@ -0,0 +1,158 @@
+package openpgp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned when another process holds a keyring's lock for
+// longer than LockTimeout.
+var ErrLocked = errors.New("openpgp: keyring is locked by another process")
+
+// LockTimeout is how long to wait for another process to release a
+// keyring's lock.
+var LockTimeout = 10 * time.Second
+
+// A dotlock is a lock file as used by GnuPG (see common/dotlock.c):
+// pubring.gpg is locked by creating pubring.gpg.lock, which holds the
+// process ID and host name of its owner. The lock is taken by
+// hard-linking a uniquely named file to the lock file, which works on
+// NFS; where hard links aren't supported, the lock file is created
+// exclusively instead. Locks left behind by processes that have died
+// on this host are removed.
+//
+// A dotlock may be taken more than once by its owner, and is released
+// when it has been released as many times.
+type dotlock struct {
+	file  string // the file being locked
+	path  string // the lock file
+	tname string // the file linked to the lock file to take it
+	node  string
+	held  int
+}
+
+// nodeName returns the host name as GnuPG writes it in lock files.
+func nodeName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown"
+	}
+	if i := strings.Index(host, "."); i > 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// newDotlock returns the lock for the named file.
+func newDotlock(file string) (l *dotlock) {
+	l = &dotlock{file: file, path: file + ".lock", node: nodeName()}
+	l.tname = filepath.Join(filepath.Dir(file),
+		fmt.Sprintf(".#lk%p.%s.%d", l, l.node, os.Getpid()))
+	return
+}
+
+// contents returns what GnuPG writes to a lock file: the process ID,
+// padded to ten characters, and the host name, each on its own line.
+func (l *dotlock) contents() []byte {
+	return []byte(fmt.Sprintf("%10d\n%s\n", os.Getpid(), l.node))
+}
+
+// owner reads the process ID from the lock file, and whether it was
+// taken on this host; older lock files don't name the host.
+func (l *dotlock) owner() (pid int, local bool, err error) {
+	data, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return
+	} else if len(data) < 11 {
+		err = ErrLocked
+		return
+	}
+
+	pid, err = strconv.Atoi(strings.TrimSpace(string(data[:11])))
+	if err != nil {
+		return
+	}
+	node := strings.TrimSuffix(string(data[11:]), "\n")
+	local = node == "" || node == l.node
+	return
+}
+
+// createLock takes the lock by creating the lock file exclusively.
+func (l *dotlock) createLock() (ok bool, err error) {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return false, nil
+	} else if err != nil {
+		return
+	}
+
+	_, err = f.Write(l.contents())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(l.path)
+		return
+	}
+	return true, nil
+}
+
+// lock takes the lock, waiting up to timeout for another process to
+// release it. A nil lock is never held.
+func (l *dotlock) lock(timeout time.Duration) (err error) {
+	if l == nil {
+		return
+	} else if l.held > 0 {
+		l.held++
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+	wait := 50 * time.Millisecond
+	for {
+		var ok bool
+		ok, err = l.tryLock()
+		if err != nil {
+			return
+		} else if ok {
+			l.held = 1
+			return
+		}
+
+		pid, local, oerr := l.owner()
+		if os.IsNotExist(oerr) {
+			continue
+		} else if oerr == nil && local && pid != os.Getpid() && processGone(pid) {
+			os.Remove(l.path)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(wait)
+		if wait < time.Second {
+			wait *= 2
+		}
+	}
+}
+
+// release releases the lock once it has been released as many times
+// as it was taken.
+func (l *dotlock) release() (err error) {
+	if l == nil || l.held == 0 {
+		return
+	}
+
+	l.held--
+	if l.held == 0 {
+		err = os.Remove(l.path)
+	}
+	return
+}
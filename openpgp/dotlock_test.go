@@ -0,0 +1,108 @@
+package openpgp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDotlock validates that lock files are written as GnuPG writes
+// them, that a held lock can't be taken twice, and that locks left by
+// dead processes are removed.
+func TestDotlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keybase")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "pubring.gpg")
+
+	first, second := newDotlock(file), newDotlock(file)
+	if err = first.lock(time.Second); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	data, err := ioutil.ReadFile(file + ".lock")
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if string(data) != fmt.Sprintf("%10d\n%s\n", os.Getpid(), nodeName()) {
+		t.Fatalf("bad lock file contents %q", data)
+	}
+
+	if err = second.lock(100 * time.Millisecond); err != ErrLocked {
+		t.Fatal("a held lock was taken twice")
+	}
+	if err = first.release(); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = second.lock(time.Second); err != nil {
+		t.Fatalf("%v", err)
+	}
+	second.release()
+
+	// No process has this ID on Linux, where pid_max is at most 2^22.
+	stale := fmt.Sprintf("%10d\n%s\n", 1<<30, nodeName())
+	err = ioutil.WriteFile(file+".lock", []byte(stale), 0644)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err = first.lock(time.Second); err != nil {
+		t.Fatalf("stale lock wasn't removed: %v", err)
+	}
+	first.release()
+}
+
+// TestKeyRingChanged validates that a keyring isn't stored over changes
+// made on disk since it was loaded, that UpdateKeyRing applies
+// changes, and that a keyring may be used from several goroutines.
+func TestKeyRingChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keybase")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pubring.gpg")
+	fpr := "3b0c4de7d1658d1a5faec120ee4fba85107dad37"
+
+	err = UpdateKeyRing(path, false, func(keyRing *KeyRing) (err error) {
+		_, err = keyRing.Import(testPubArmoured)
+		return
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	keyRing, err := LoadKeyRing(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if keyRing.Len() != 1 {
+		t.Fatal("UpdateKeyRing didn't store the imported key")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keyRing.Find(fpr)
+			keyRing.Entities()
+			keyRing.Import(testPubArmoured)
+		}()
+	}
+	wg.Wait()
+
+	err = UpdateKeyRing(path, false, func(keyRing *KeyRing) (err error) {
+		_, err = keyRing.Delete(fpr)
+		return
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err = keyRing.Store(); err != ErrKeyRingChanged {
+		t.Fatal("keyring was stored over changes made on disk")
+	}
+}
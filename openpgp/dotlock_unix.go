@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package openpgp
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+// tryLock tries to take the lock once by linking a uniquely named file
+// to the lock file; the link count shows whether it worked, even if
+// link reports an error over NFS.
+func (l *dotlock) tryLock() (ok bool, err error) {
+	err = ioutil.WriteFile(l.tname, l.contents(), 0644)
+	if err != nil {
+		return
+	}
+	defer os.Remove(l.tname)
+
+	linkErr := os.Link(l.tname, l.path)
+	fi, err := os.Stat(l.tname)
+	if err != nil {
+		return
+	}
+	if st, isStat := fi.Sys().(*syscall.Stat_t); isStat && uint64(st.Nlink) == 2 {
+		return true, nil
+	}
+
+	if linkErr == nil || os.IsExist(linkErr) {
+		return false, nil
+	}
+	return l.createLock()
+}
+
+// processGone returns true if no process has the given ID.
+func processGone(pid int) bool {
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
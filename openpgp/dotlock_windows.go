@@ -0,0 +1,13 @@
+package openpgp
+
+// tryLock tries to take the lock once. Windows has no hard links to
+// rely on, so the lock file is created exclusively.
+func (l *dotlock) tryLock() (ok bool, err error) {
+	return l.createLock()
+}
+
+// processGone can't tell whether a process has exited on Windows, so
+// stale locks are left for the user to remove.
+func processGone(pid int) bool {
+	return false
+}
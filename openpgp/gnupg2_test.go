@@ -0,0 +1,331 @@
+package openpgp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testGnuPG2Home   = "testdata/gnupg2"
+	testKeyboxPath   = "testdata/gnupg2/pubring.kbx"
+	testAgentKeyPath = "testdata/gnupg2/private-keys-v1.d"
+)
+
+// Fingerprints of the keys in testdata/gnupg2: the key from the
+// legacy test keyrings, imported into GnuPG 2.2 (and so stored as an
+// openpgp-native key), an RSA key generated and protected by GnuPG
+// 2.2, and an unprotected ECDSA key.
+const (
+	testNativeFpr = "1f72f8b9cf8d215881e3c1d0af7db9c0ccaff8eb"
+	testOCBFpr    = "8000024aaa58b020545bccf3c25d98be5c97beab"
+	testECDSAFpr  = "9c13a24594aa466c14e9f390c2dfcce9536f3aae"
+)
+
+// TestSetKeyRingDir checks that the keyring layout is detected.
+func TestSetKeyRingDir(t *testing.T) {
+	defer func(pub, sec string) {
+		PubRingPath, SecRingPath = pub, sec
+	}(PubRingPath, SecRingPath)
+
+	SetKeyRingDir(testGnuPG2Home)
+	if filepath.Base(PubRingPath) != "pubring.kbx" {
+		t.Fatalf("expected a keybox, have %s", PubRingPath)
+	} else if filepath.Base(SecRingPath) != "private-keys-v1.d" {
+		t.Fatalf("expected a private-keys-v1.d directory, have %s", SecRingPath)
+	}
+
+	SetKeyRingDir("testdata")
+	if filepath.Base(PubRingPath) != "pubring.gpg" {
+		t.Fatalf("expected pubring.gpg, have %s", PubRingPath)
+	} else if filepath.Base(SecRingPath) != "secring.gpg" {
+		t.Fatalf("expected secring.gpg, have %s", SecRingPath)
+	}
+}
+
+// TestLoadKeybox validates loading a GnuPG 2.1+ keybox.
+func TestLoadKeybox(t *testing.T) {
+	keyRing, err := LoadKeyRing(testKeyboxPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if keyRing.Private() {
+		t.Fatal("keybox should not be private")
+	} else if len(keyRing.entities) != 4 {
+		t.Fatalf("expected 4 keys in the keybox, have %d", len(keyRing.entities))
+	}
+
+	for _, fpr := range []string{testNativeFpr, testOCBFpr, testECDSAFpr} {
+		if keyRing.Entity(fpr) == nil {
+			t.Fatalf("key %s wasn't loaded", fpr)
+		}
+	}
+
+}
+
+// TestStoreKeybox validates writing a keybox: keys must survive the
+// round trip, along with any blobs that couldn't be parsed.
+func TestStoreKeybox(t *testing.T) {
+	tempDir, err := ioutil.TempDir("testdata/", "openpgp_test")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	kbx := filepath.Join(tempDir, "pubring.kbx")
+
+	keyRing, err := LoadKeyRing(testKeyboxPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	keyRing.path = kbx
+	if err = keyRing.Store(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	stored, err := LoadKeyRing(kbx)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if stored.format != formatKeybox {
+		t.Fatal("keybox wasn't stored as a keybox")
+	} else if len(stored.entities) != len(keyRing.entities) {
+		t.Fatalf("expected %d keys, have %d", len(keyRing.entities), len(stored.entities))
+	} else if len(stored.kept) != len(keyRing.kept) {
+		t.Fatal("unparsed keybox blobs were lost")
+	}
+
+	for fpr := range keyRing.entities {
+		if stored.Entity(fpr) == nil {
+			t.Fatalf("key %s wasn't stored", fpr)
+		}
+	}
+}
+
+// TestLoadAgentKeys validates loading and unlocking the secret keys in
+// a private-keys-v1.d directory.
+func TestLoadAgentKeys(t *testing.T) {
+	keyRing, err := LoadKeyRing(testAgentKeyPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !keyRing.Private() {
+		t.Fatal("private-keys-v1.d should be private")
+	} else if len(keyRing.entities) != 4 {
+		t.Fatalf("expected 4 secret keys, have %d", len(keyRing.entities))
+	}
+
+	ecdsaKey := keyRing.Entity(testECDSAFpr)
+	if ecdsaKey == nil || ecdsaKey.PrivateKey == nil {
+		t.Fatal("unprotected ECDSA key wasn't loaded")
+	} else if ecdsaKey.PrivateKey.Encrypted || ecdsaKey.PrivateKey.PrivateKey == nil {
+		t.Fatal("unprotected ECDSA key should be usable")
+	}
+
+	for _, fpr := range []string{testNativeFpr, testOCBFpr} {
+		e := keyRing.Entity(fpr)
+		if e == nil || e.PrivateKey == nil {
+			t.Fatalf("secret key %s wasn't loaded", fpr)
+		} else if !e.PrivateKey.Encrypted {
+			t.Fatalf("secret key %s should be encrypted", fpr)
+		}
+
+		if err = keyRing.decrypt(e.PrivateKey, []byte("wrong")); err == nil {
+			t.Fatalf("secret key %s unlocked with the wrong passphrase", fpr)
+		}
+
+		if err = keyRing.decrypt(e.PrivateKey, []byte("passphrase")); err != nil {
+			t.Fatalf("failed to unlock %s: %v", fpr, err)
+		} else if e.PrivateKey.Encrypted {
+			t.Fatalf("secret key %s is still encrypted", fpr)
+		}
+	}
+
+	native := keyRing.Entity(testNativeFpr)
+	if len(native.Subkeys) != 1 || native.Subkeys[0].PrivateKey == nil {
+		t.Fatal("secret subkey wasn't loaded")
+	}
+
+	sig, err := keyRing.Sign([]byte("Hello, world"), testOCBFpr)
+	if err != nil {
+		t.Fatalf("signature failed: %v", err)
+	} else if len(sig) == 0 {
+		t.Fatal("empty signature")
+	}
+}
+
+// TestParseCanonicalKey checks that GnuPG 2.1's canonical key files
+// are understood as well as the extended format.
+func TestParseCanonicalKey(t *testing.T) {
+	key := sexpList(sexpAtom([]byte("private-key")),
+		sexpList(sexpAtom([]byte("rsa")),
+			sexpList(sexpAtom([]byte("n")), sexpAtom([]byte{0, 0xc5, 0x29, '(', ')'})),
+			sexpList(sexpAtom([]byte("e")), sexpAtom([]byte{1, 0, 1}))))
+
+	parsed, err := parseKeyFile(key.Canonical())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if string(parsed.Canonical()) != string(key.Canonical()) {
+		t.Fatal("canonical key didn't round trip")
+	} else if agentAlgo(parsed).Name() != "rsa" {
+		t.Fatal("failed to find the key's algorithm")
+	} else if len(agentAlgo(parsed).Value("n")) != 5 {
+		t.Fatal("failed to parse binary atom")
+	}
+}
+
+// TestKeygrip checks that keygrips match the names gpg-agent gave the
+// key files.
+func TestKeygrip(t *testing.T) {
+	keyRing, err := LoadKeyRing(testAgentKeyPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, e := range keyRing.entities {
+		for _, pub := range entityKeys(e) {
+			grip, err := keygrip(pub)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			name := filepath.Join(testAgentKeyPath, fmt.Sprintf("%X.key", grip))
+			if !fileExists(name) {
+				t.Fatalf("no key file for %s", fingerprint(pub))
+			}
+		}
+	}
+}
+
+// TestStoreAgentKeys validates writing secret keys to a
+// private-keys-v1.d directory.
+func TestStoreAgentKeys(t *testing.T) {
+	tempDir, err := ioutil.TempDir("testdata/", "openpgp_test")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	err = copyDir(testGnuPG2Home, tempDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	keyDir := filepath.Join(tempDir, "private-keys-v1.d")
+
+	keyRing, err := LoadKeyRing(keyDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	e := keyRing.Entity(testOCBFpr)
+	if err = keyRing.decrypt(e.PrivateKey, []byte("passphrase")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, subkey := range e.Subkeys {
+		if err = keyRing.decrypt(subkey.PrivateKey, []byte("passphrase")); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if err = keyRing.SetPassphrase(testOCBFpr, []byte("new passphrase")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Change the ECDSA key in memory to make sure keys that haven't
+	// changed are left alone.
+	keyRing.Entity(testECDSAFpr).PrivateKey.Encrypted = true
+
+	if err = keyRing.Store(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	keyRing, err = LoadKeyRing(keyDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(keyRing.entities) != 4 {
+		t.Fatalf("expected 4 secret keys, have %d", len(keyRing.entities))
+	}
+
+	if keyRing.Entity(testECDSAFpr).PrivateKey.Encrypted {
+		t.Fatal("unchanged key was rewritten")
+	}
+
+	e = keyRing.Entity(testOCBFpr)
+	if !e.PrivateKey.Encrypted {
+		t.Fatal("unlocked key was stored unencrypted")
+	} else if err = keyRing.decrypt(e.PrivateKey, []byte("passphrase")); err == nil {
+		t.Fatal("old passphrase still unlocks the key")
+	} else if err = keyRing.decrypt(e.PrivateKey, []byte("new passphrase")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	sig, err := keyRing.Sign([]byte("Hello, world"), testOCBFpr)
+	if err != nil {
+		t.Fatalf("signature failed: %v", err)
+	} else if len(sig) == 0 {
+		t.Fatal("empty signature")
+	}
+}
+
+// TestDeleteAgentKey validates that deleting a key from
+// private-keys-v1.d removes its key files when the keyring is stored.
+func TestDeleteAgentKey(t *testing.T) {
+	tempDir, err := ioutil.TempDir("testdata/", "openpgp_test")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	err = copyDir(testGnuPG2Home, tempDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	keyDir := filepath.Join(tempDir, "private-keys-v1.d")
+
+	keyRing, err := LoadKeyRing(keyDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e, err := keyRing.Delete(testECDSAFpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if _, err = keyRing.Delete(testECDSAFpr); err != ErrKeyNotFound {
+		t.Fatal("key was deleted twice")
+	} else if err = keyRing.Store(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	grip, err := keygrip(e.PrimaryKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if fileExists(filepath.Join(keyDir, fmt.Sprintf("%X.key", grip))) {
+		t.Fatal("deleted key's file is still there")
+	}
+
+	keyRing, err = LoadKeyRing(keyDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(keyRing.entities) != 3 || keyRing.Entity(testECDSAFpr) != nil {
+		t.Fatal("key wasn't deleted")
+	}
+}
+
+// copyDir copies the regular files under src to dst.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, fi.Mode().Perm())
+	})
+}
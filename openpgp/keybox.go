@@ -0,0 +1,211 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// ErrKeybox is returned when a keybox file is malformed.
+var ErrKeybox = errors.New("openpgp: invalid keybox")
+
+// Keybox blob types; see kbx/keybox-blob.c in the GnuPG sources.
+const (
+	kbxBlobHeader  = 1
+	kbxBlobOpenPGP = 2
+)
+
+// isKeybox returns true if data starts with a keybox header blob.
+func isKeybox(data []byte) bool {
+	return len(data) >= 12 && data[4] == kbxBlobHeader &&
+		bytes.Equal(data[8:12], []byte("KBXf"))
+}
+
+// readKeybox extracts the OpenPGP keyblocks from a GnuPG 2.1+ keybox
+// (pubring.kbx). The header, X.509 certificates and keys using
+// algorithms the Go OpenPGP package doesn't support are returned as
+// raw blobs in kept, so they aren't lost when the keybox is written.
+func readKeybox(data []byte) (el openpgp.EntityList, kept [][]byte, err error) {
+	if !isKeybox(data) {
+		err = ErrKeybox
+		return
+	}
+
+	for len(data) > 0 {
+		if len(data) < 5 {
+			err = ErrKeybox
+			return
+		}
+
+		blobLen := binary.BigEndian.Uint32(data)
+		if blobLen < 5 || uint64(blobLen) > uint64(len(data)) {
+			err = ErrKeybox
+			return
+		}
+		blob := data[:blobLen]
+		data = data[blobLen:]
+
+		if blob[4] != kbxBlobOpenPGP {
+			kept = append(kept, blob)
+			continue
+		}
+
+		// Skip the length, type, version and flags to get to
+		// the location of the keyblock.
+		if len(blob) < 16 {
+			err = ErrKeybox
+			return
+		}
+		off := binary.BigEndian.Uint32(blob[8:])
+		n := binary.BigEndian.Uint32(blob[12:])
+		if uint64(off)+uint64(n) > uint64(len(blob)) {
+			err = ErrKeybox
+			return
+		}
+
+		var entities openpgp.EntityList
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(blob[off : off+n]))
+		switch err.(type) {
+		case nil:
+		case pgperrors.UnsupportedError, pgperrors.StructuralError:
+			err = nil
+			kept = append(kept, blob)
+			continue
+		default:
+			return
+		}
+		el = append(el, entities...)
+	}
+	return
+}
+
+// keyboxHeader returns a new keybox header blob.
+func keyboxHeader(created time.Time) []byte {
+	blob := make([]byte, 32)
+	binary.BigEndian.PutUint32(blob, 32)
+	blob[4] = kbxBlobHeader
+	blob[5] = 1
+	copy(blob[8:], "KBXf")
+	binary.BigEndian.PutUint32(blob[16:], uint32(created.Unix()))
+	return blob
+}
+
+// keyboxBlob builds the OpenPGP keybox blob for a keyblock. The key,
+// user ID and signature tables describe the packets in the keyblock;
+// validity and trust are left for GnuPG to fill in.
+func keyboxBlob(keys []*packet.PublicKey, keyblock []byte, created time.Time) (blob []byte, err error) {
+	pkts, err := splitPackets(keyblock)
+	if err != nil {
+		return
+	}
+
+	type uidInfo struct{ off, n int }
+	var uids []uidInfo
+	var nsigs, off int
+	for _, pkt := range pkts {
+		switch packetTag(pkt) {
+		case tagUserID:
+			body, _ := packetBody(pkt)
+			uids = append(uids, uidInfo{off + len(pkt) - len(body), len(body)})
+		case tagSignature:
+			nsigs++
+		}
+		off += len(pkt)
+	}
+
+	const keyInfoSize, uidInfoSize, sigInfoSize = 28, 12, 4
+	fixed := 20 + len(keys)*keyInfoSize + 2 + 4 + len(uids)*uidInfoSize +
+		4 + nsigs*sigInfoSize + 16 + 4
+	buf := new(bytes.Buffer)
+	u16 := func(v int) { binary.Write(buf, binary.BigEndian, uint16(v)) }
+	u32 := func(v int) { binary.Write(buf, binary.BigEndian, uint32(v)) }
+
+	u32(fixed + len(keyblock) + sha1.Size)
+	buf.Write([]byte{kbxBlobOpenPGP, 1})
+	u16(0)
+	u32(fixed)
+	u32(len(keyblock))
+
+	u16(len(keys))
+	u16(keyInfoSize)
+	for _, pk := range keys {
+		// The key ID is the tail of the fingerprint.
+		keyIDOff := buf.Len() + 12
+		buf.Write(pk.Fingerprint[:])
+		u32(keyIDOff)
+		u16(0)
+		u16(0)
+	}
+
+	u16(0) // no serial number
+	u16(len(uids))
+	u16(uidInfoSize)
+	for _, uid := range uids {
+		u32(fixed + uid.off)
+		u32(uid.n)
+		u16(0)
+		buf.Write([]byte{0, 0})
+	}
+
+	u16(nsigs)
+	u16(sigInfoSize)
+	for i := 0; i < nsigs; i++ {
+		u32(0) // not checked
+	}
+
+	buf.Write([]byte{0, 0}) // ownertrust and validity
+	u16(0)
+	u32(0) // recheck after
+	u32(0) // latest timestamp
+	u32(int(created.Unix()))
+	u32(0) // no reserved space
+
+	buf.Write(keyblock)
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	blob = buf.Bytes()
+	return
+}
+
+// writeKeybox writes the keyring as a keybox. Blobs that were kept
+// when the keybox was read are written back unchanged.
+func (keyRing *KeyRing) writeKeybox(w io.Writer) (err error) {
+	now := time.Now()
+	kept := keyRing.kept
+	if len(kept) == 0 || kept[0][4] != kbxBlobHeader {
+		kept = append([][]byte{keyboxHeader(now)}, kept...)
+	}
+
+	for _, blob := range kept {
+		_, err = w.Write(blob)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, e := range keyRing.ownEntities() {
+		keyblock := new(bytes.Buffer)
+		err = keyRing.serializeEntity(keyblock, e, false)
+		if err != nil {
+			return
+		}
+
+		var blob []byte
+		blob, err = keyboxBlob(entityKeys(e), keyblock.Bytes(), now)
+		if err != nil {
+			return
+		}
+		_, err = w.Write(blob)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,322 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
+)
+
+var (
+	ErrKeyAlgorithm = errors.New("openpgp: unsupported key algorithm")
+	ErrKeySize      = errors.New("openpgp: unsupported key size")
+	ErrUserID       = errors.New("openpgp: invalid user ID")
+)
+
+// Key generation algorithms.
+const (
+	AlgoRSA = "rsa"
+	AlgoECC = "ecc"
+)
+
+// DefaultRSABits is the size of generated RSA keys if none is given.
+const DefaultRSABits = 3072
+
+// KeyOptions describes a key to generate.
+type KeyOptions struct {
+	Name    string
+	Comment string
+	Email   string
+
+	// Algorithm is AlgoRSA (the default) or AlgoECC. Bits is the
+	// RSA key size (2048, 3072 or 4096), and Curve the elliptic
+	// curve (P-256, the default, P-384 or P-521).
+	Algorithm string
+	Bits      int
+	Curve     string
+
+	// Lifetime is how long the key is valid for; zero means the
+	// key doesn't expire.
+	Lifetime time.Duration
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// newPrivateKey generates a new key as described by opts.
+func newPrivateKey(opts *KeyOptions, created time.Time) (priv *packet.PrivateKey, err error) {
+	switch opts.Algorithm {
+	case "", AlgoRSA:
+		bits := opts.Bits
+		if bits == 0 {
+			bits = DefaultRSABits
+		}
+		if bits != 2048 && bits != 3072 && bits != 4096 {
+			err = ErrKeySize
+			return
+		}
+
+		var key *rsa.PrivateKey
+		key, err = rsa.GenerateKey(DefaultConfig.Random(), bits)
+		if err != nil {
+			return
+		}
+		priv = packet.NewRSAPrivateKey(created, key)
+	case AlgoECC:
+		name := opts.Curve
+		if name == "" {
+			name = "P-256"
+		}
+		curve, ok := curves[name]
+		if !ok {
+			err = ErrKeyAlgorithm
+			return
+		}
+
+		var key *ecdsa.PrivateKey
+		key, err = ecdsa.GenerateKey(curve, DefaultConfig.Random())
+		if err != nil {
+			return
+		}
+		priv = packet.NewECDSAPrivateKey(created, key)
+	default:
+		err = ErrKeyAlgorithm
+	}
+	return
+}
+
+// NewEntity generates a new key. RSA keys get an encryption subkey
+// alongside the primary signing key; the OpenPGP package can't use
+// ECDH, so ECC keys are signing keys only.
+func NewEntity(opts *KeyOptions) (ne *openpgp.Entity, err error) {
+	uid := packet.NewUserId(opts.Name, opts.Comment, opts.Email)
+	if uid == nil {
+		err = ErrUserID
+		return
+	}
+
+	created := DefaultConfig.Now()
+	var lifetime *uint32
+	if opts.Lifetime > 0 {
+		secs := uint32(opts.Lifetime / time.Second)
+		lifetime = &secs
+	}
+
+	priv, err := newPrivateKey(opts, created)
+	if err != nil {
+		return
+	}
+
+	e := &openpgp.Entity{
+		PrimaryKey: &priv.PublicKey,
+		PrivateKey: priv,
+		Identities: map[string]*openpgp.Identity{},
+	}
+
+	isPrimaryID := true
+	selfSig := &packet.Signature{
+		CreationTime:    created,
+		SigType:         packet.SigTypePositiveCert,
+		PubKeyAlgo:      priv.PubKeyAlgo,
+		Hash:            DefaultConfig.Hash(),
+		IsPrimaryId:     &isPrimaryID,
+		FlagsValid:      true,
+		FlagSign:        true,
+		FlagCertify:     true,
+		IssuerKeyId:     &priv.KeyId,
+		KeyLifetimeSecs: lifetime,
+	}
+	err = selfSig.SignUserId(uid.Id, e.PrimaryKey, priv, DefaultConfig)
+	if err != nil {
+		return
+	}
+	e.Identities[uid.Id] = &openpgp.Identity{
+		Name:          uid.Id,
+		UserId:        uid,
+		SelfSignature: selfSig,
+	}
+
+	if priv.PubKeyAlgo == packet.PubKeyAlgoRSA {
+		var subkey *packet.PrivateKey
+		subkey, err = newPrivateKey(opts, created)
+		if err != nil {
+			return
+		}
+		subkey.IsSubkey = true
+		subkey.PublicKey.IsSubkey = true
+
+		sig := &packet.Signature{
+			CreationTime:              created,
+			SigType:                   packet.SigTypeSubkeyBinding,
+			PubKeyAlgo:                priv.PubKeyAlgo,
+			Hash:                      DefaultConfig.Hash(),
+			FlagsValid:                true,
+			FlagEncryptStorage:        true,
+			FlagEncryptCommunications: true,
+			IssuerKeyId:               &priv.KeyId,
+			KeyLifetimeSecs:           lifetime,
+		}
+		err = sig.SignKey(&subkey.PublicKey, priv, DefaultConfig)
+		if err != nil {
+			return
+		}
+		e.Subkeys = append(e.Subkeys, openpgp.Subkey{
+			PublicKey:  &subkey.PublicKey,
+			PrivateKey: subkey,
+			Sig:        sig,
+		})
+	}
+
+	ne = e
+	return
+}
+
+// Reasons for revocation, from RFC 4880 section 5.2.3.23.
+const (
+	RevokeNoReason   = 0
+	RevokeSuperseded = 1
+	RevokeCompromise = 2
+	RevokeRetired    = 3
+)
+
+// signatureSubpacket encodes a signature subpacket.
+func signatureSubpacket(typ byte, data []byte) (sp []byte) {
+	n := len(data) + 1
+	switch {
+	case n < 192:
+		sp = []byte{byte(n)}
+	case n < 8384:
+		n -= 192
+		sp = []byte{byte(n>>8) + 192, byte(n)}
+	default:
+		sp = []byte{255, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	sp = append(sp, typ)
+	return append(sp, data...)
+}
+
+// keyRevocation builds a key revocation signature for priv, which
+// must be unlocked. The packet library can't write reasons for
+// revocation, so the signature is put together by hand.
+func keyRevocation(priv *packet.PrivateKey, reason byte, text string) (pkt []byte, err error) {
+	if priv.Encrypted {
+		err = ErrKeyLocked
+		return
+	}
+
+	hashFunc := DefaultConfig.Hash()
+	hashID, ok := s2k.HashToHashId(hashFunc)
+	if !ok || !hashFunc.Available() {
+		err = ErrKeyAlgorithm
+		return
+	}
+
+	created := make([]byte, 4)
+	binary.BigEndian.PutUint32(created, uint32(DefaultConfig.Now().Unix()))
+	issuer := make([]byte, 8)
+	binary.BigEndian.PutUint64(issuer, priv.KeyId)
+
+	var hashed []byte
+	hashed = append(hashed, signatureSubpacket(2, created)...)
+	hashed = append(hashed, signatureSubpacket(29, append([]byte{reason}, text...))...)
+	unhashed := signatureSubpacket(16, issuer)
+
+	prefix := []byte{4, packet.SigTypeKeyRevocation, byte(priv.PubKeyAlgo), hashID,
+		byte(len(hashed) >> 8), byte(len(hashed))}
+	prefix = append(prefix, hashed...)
+
+	pub, err := publicKeyBody(&priv.PublicKey)
+	if err != nil {
+		return
+	}
+	h := hashFunc.New()
+	priv.PublicKey.SerializeSignaturePrefix(h)
+	h.Write(pub)
+	h.Write(prefix)
+	trailer := []byte{4, 0xff, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(trailer[2:], uint32(len(prefix)))
+	h.Write(trailer)
+	digest := h.Sum(nil)
+
+	var mpis []byte
+	switch key := priv.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		var sig []byte
+		sig, err = rsa.SignPKCS1v15(DefaultConfig.Random(), key, hashFunc, digest)
+		if err != nil {
+			return
+		}
+		mpis = encodeMPI(sig)
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(DefaultConfig.Random(), key, digest)
+		if err != nil {
+			return
+		}
+		mpis = append(encodeMPI(r.Bytes()), encodeMPI(s.Bytes())...)
+	default:
+		err = ErrKeyAlgorithm
+		return
+	}
+
+	body := append(prefix, byte(len(unhashed)>>8), byte(len(unhashed)))
+	body = append(body, unhashed...)
+	body = append(body, digest[:2]...)
+	body = append(body, mpis...)
+
+	buf := new(bytes.Buffer)
+	err = serializePacket(buf, tagSignature, body)
+	pkt = buf.Bytes()
+	return
+}
+
+// armouredRevocation armours a revocation signature as GnuPG does for
+// revocation certificates.
+func armouredRevocation(pkt []byte, comment string) (armoured string, err error) {
+	buf := new(bytes.Buffer)
+	hdr := map[string]string{
+		"Version": fmt.Sprintf("Keybase Go client (OpenPGP version %s)", Version),
+		"Comment": comment,
+	}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, hdr)
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write(pkt)
+	if err != nil {
+		return
+	}
+	w.Close()
+	armoured = buf.String()
+	return
+}
+
+// NewRevocation returns an armoured revocation certificate for a newly
+// generated entity, to be kept in case the key is lost or
+// compromised.
+func NewRevocation(e *openpgp.Entity) (armoured string, err error) {
+	if e.PrivateKey == nil {
+		err = ErrKeyNotFound
+		return
+	}
+
+	pkt, err := keyRevocation(e.PrivateKey, RevokeNoReason, "")
+	if err != nil {
+		return
+	}
+	return armouredRevocation(pkt, "This is a revocation certificate")
+}
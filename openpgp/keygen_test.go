@@ -0,0 +1,112 @@
+package openpgp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// TestNewEntity validates key generation, and that a new key is stored
+// encrypted with its passphrase.
+func TestNewEntity(t *testing.T) {
+	opts := &KeyOptions{
+		Name:     "Test Key",
+		Comment:  "test",
+		Email:    "test@example.net",
+		Bits:     2048,
+		Lifetime: 24 * time.Hour,
+	}
+	e, err := NewEntity(opts)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(e.Subkeys) != 1 {
+		t.Fatal("RSA key should have an encryption subkey")
+	}
+
+	id, ok := e.Identities["Test Key (test) <test@example.net>"]
+	if !ok {
+		t.Fatal("user ID is missing the comment")
+	} else if id.SelfSignature.KeyLifetimeSecs == nil || *id.SelfSignature.KeyLifetimeSecs != 86400 {
+		t.Fatal("key lifetime wasn't set")
+	}
+
+	secRing := NewKeyRing("", true)
+	if err = secRing.Add(e, []byte("passphrase")); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = secRing.Add(e, []byte("passphrase")); err != ErrKeyExists {
+		t.Fatal("key was added twice")
+	}
+
+	fpr := fingerprint(e.PrimaryKey)
+	armoured, err := secRing.ExportSecret(fpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	imported := NewKeyRing("", true)
+	if _, err = imported.Import(armoured); err != nil {
+		t.Fatalf("%v", err)
+	}
+	stored := imported.Entity(fpr)
+	if stored == nil {
+		t.Fatal("secret key wasn't exported")
+	} else if !stored.PrivateKey.Encrypted || !stored.Subkeys[0].PrivateKey.Encrypted {
+		t.Fatal("secret key was exported unencrypted")
+	} else if err = imported.decrypt(stored.PrivateKey, []byte("passphrase")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	pubRing := NewKeyRing("", false)
+	if err = pubRing.Add(e, nil); err != nil {
+		t.Fatalf("%v", err)
+	} else if pubRing.Entity(fpr).PrivateKey != nil {
+		t.Fatal("public keyring has the secret key")
+	} else if e.PrivateKey == nil || e.Subkeys[0].PrivateKey == nil {
+		t.Fatal("adding to a public keyring changed the entity")
+	}
+}
+
+// TestNewECCEntity validates ECC key generation and the revocation
+// certificate.
+func TestNewECCEntity(t *testing.T) {
+	e, err := NewEntity(&KeyOptions{
+		Name:      "Test Key",
+		Email:     "test@example.net",
+		Algorithm: AlgoECC,
+		Curve:     "P-384",
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if e.PrimaryKey.PubKeyAlgo != packet.PubKeyAlgoECDSA {
+		t.Fatal("expected an ECDSA key")
+	}
+
+	rev, err := NewRevocation(e)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	block, err := armor.Decode(bytes.NewBufferString(rev))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok || sig.SigType != packet.SigTypeKeyRevocation {
+		t.Fatal("revocation certificate isn't a key revocation")
+	} else if sig.RevocationReason == nil || *sig.RevocationReason != RevokeNoReason {
+		t.Fatal("revocation reason is missing")
+	} else if err = e.PrimaryKey.VerifyRevocationSignature(sig); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, err = NewEntity(&KeyOptions{Name: "Test", Bits: 1024}); err != ErrKeySize {
+		t.Fatal("small RSA key was generated")
+	}
+}
@@ -0,0 +1,223 @@
+package openpgp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// An ImportResult describes what importing changed for one key.
+type ImportResult struct {
+	Fingerprint string   `json:"fingerprint"`
+	New         bool     `json:"new"`                // the key wasn't in the keyring before
+	UserIDs     []string `json:"user_ids,omitempty"` // new user IDs
+	Subkeys     []string `json:"subkeys,omitempty"`  // fingerprints of new subkeys
+	SecretKeys  int      `json:"secret_keys"`        // secret keys added to a key already in the keyring
+	Signatures  int      `json:"signatures"`         // new certifications and other signatures
+
+	// Updated counts self-signatures replaced by newer ones, such
+	// as when a key's expiry is extended.
+	Updated int `json:"updated"`
+
+	Revoked        bool     `json:"revoked"` // the key has been newly revoked
+	RevokedUserIDs []string `json:"revoked_user_ids,omitempty"`
+	RevokedSubkeys []string `json:"revoked_subkeys,omitempty"`
+}
+
+// Changed returns true if the import changed the keyring.
+func (r *ImportResult) Changed() bool {
+	return r.New || len(r.UserIDs) > 0 || len(r.Subkeys) > 0 ||
+		r.SecretKeys > 0 || r.Signatures > 0 || r.Updated > 0 ||
+		r.Revoked || len(r.RevokedUserIDs) > 0 || len(r.RevokedSubkeys) > 0
+}
+
+func plural(n int, what string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", what)
+	}
+	return fmt.Sprintf("%d %ss", n, what)
+}
+
+// String summarises the changes, e.g. "key 1f72...: 1 new user ID,
+// revoked".
+func (r *ImportResult) String() string {
+	var changes []string
+	switch {
+	case r.New:
+		changes = append(changes, "new key")
+	case !r.Changed():
+		changes = append(changes, "unchanged")
+	}
+
+	if !r.New {
+		for _, change := range []struct {
+			n    int
+			what string
+		}{
+			{len(r.UserIDs), "new user ID"},
+			{len(r.Subkeys), "new subkey"},
+			{r.SecretKeys, "new secret key"},
+			{r.Signatures, "new signature"},
+			{r.Updated, "updated self-signature"},
+			{len(r.RevokedUserIDs), "revoked user ID"},
+			{len(r.RevokedSubkeys), "revoked subkey"},
+		} {
+			if change.n > 0 {
+				changes = append(changes, plural(change.n, change.what))
+			}
+		}
+	}
+	if r.Revoked {
+		changes = append(changes, "revoked")
+	}
+
+	return fmt.Sprintf("key %s: %s", r.Fingerprint, strings.Join(changes, ", "))
+}
+
+// sigTypeCertRevocation is the type of a user ID revocation, which the
+// packet library doesn't define.
+const sigTypeCertRevocation packet.SignatureType = 0x30
+
+// ownRevocation returns true if sig claims to be a user ID revocation
+// made by the entity's own primary key.
+func ownRevocation(e *openpgp.Entity, sig *packet.Signature) bool {
+	return sig.SigType == sigTypeCertRevocation && sig.IssuerKeyId != nil &&
+		*sig.IssuerKeyId == e.PrimaryKey.KeyId
+}
+
+// userIDRevocation returns true if sig is a good revocation of the
+// named user ID by the entity's primary key. The packet library only
+// checks self-certifications when it reads a key, and keeps anything
+// else as it is, so anyone could add a revocation to a key otherwise.
+func userIDRevocation(e *openpgp.Entity, name string, sig *packet.Signature) bool {
+	return ownRevocation(e, sig) && e.PrimaryKey.VerifyUserIdSignature(name, e.PrimaryKey, sig) == nil
+}
+
+// signatureID returns a string identifying a signature, used to avoid
+// storing the same signature twice.
+func signatureID(sig *packet.Signature) string {
+	buf := new(bytes.Buffer)
+	if err := sig.Serialize(buf); err != nil {
+		return fmt.Sprintf("%p", sig)
+	}
+	return buf.String()
+}
+
+// isRevocation returns true if sig revokes a key or user ID.
+func isRevocation(sig *packet.Signature) bool {
+	switch sig.SigType {
+	case packet.SigTypeKeyRevocation, packet.SigTypeSubkeyRevocation,
+		sigTypeCertRevocation:
+		return true
+	}
+	return false
+}
+
+// newerSignature returns true if sig should replace the current
+// self-signature or binding signature: revocations always win, and
+// otherwise the most recent signature is used.
+func newerSignature(current, sig *packet.Signature) bool {
+	if current == nil {
+		return true
+	} else if isRevocation(current) {
+		return false
+	} else if isRevocation(sig) {
+		return true
+	}
+	return sig.CreationTime.After(current.CreationTime)
+}
+
+// mergeEntity merges the identities, signatures, subkeys and
+// revocations in src into dst, which must be the same key.
+func mergeEntity(dst, src *openpgp.Entity) (r *ImportResult) {
+	r = &ImportResult{Fingerprint: fingerprint(dst.PrimaryKey)}
+
+	if dst.PrivateKey == nil && src.PrivateKey != nil {
+		dst.PrivateKey = src.PrivateKey
+		dst.PrimaryKey = src.PrimaryKey
+		r.SecretKeys++
+	}
+
+	seen := map[string]bool{}
+	for _, sig := range dst.Revocations {
+		seen[signatureID(sig)] = true
+	}
+	for _, sig := range src.Revocations {
+		if !seen[signatureID(sig)] {
+			r.Revoked = r.Revoked || len(dst.Revocations) == 0
+			dst.Revocations = append(dst.Revocations, sig)
+		}
+	}
+
+	for _, name := range identityNames(src) {
+		id := src.Identities[name]
+		current, ok := dst.Identities[name]
+		if !ok {
+			dst.Identities[name] = id
+			r.UserIDs = append(r.UserIDs, name)
+			continue
+		}
+
+		if id.SelfSignature != nil && signatureID(id.SelfSignature) != signatureID(current.SelfSignature) &&
+			newerSignature(current.SelfSignature, id.SelfSignature) {
+			current.SelfSignature = id.SelfSignature
+			r.Updated++
+		}
+
+		seen := map[string]bool{}
+		for _, sig := range current.Signatures {
+			seen[signatureID(sig)] = true
+		}
+		for _, sig := range id.Signatures {
+			if seen[signatureID(sig)] {
+				continue
+			} else if ownRevocation(dst, sig) {
+				// Forged revocations are dropped.
+				if !userIDRevocation(dst, name, sig) {
+					continue
+				}
+				r.RevokedUserIDs = append(r.RevokedUserIDs, name)
+			} else {
+				r.Signatures++
+			}
+			current.Signatures = append(current.Signatures, sig)
+		}
+	}
+
+	for _, subkey := range src.Subkeys {
+		fpr := fingerprint(subkey.PublicKey)
+		i := -1
+		for j := range dst.Subkeys {
+			if fingerprint(dst.Subkeys[j].PublicKey) == fpr {
+				i = j
+				break
+			}
+		}
+
+		if i < 0 {
+			dst.Subkeys = append(dst.Subkeys, subkey)
+			r.Subkeys = append(r.Subkeys, fpr)
+			continue
+		}
+
+		current := &dst.Subkeys[i]
+		if current.PrivateKey == nil && subkey.PrivateKey != nil {
+			current.PrivateKey = subkey.PrivateKey
+			current.PublicKey = subkey.PublicKey
+			r.SecretKeys++
+		}
+
+		if signatureID(subkey.Sig) != signatureID(current.Sig) && newerSignature(current.Sig, subkey.Sig) {
+			current.Sig = subkey.Sig
+			if isRevocation(subkey.Sig) {
+				r.RevokedSubkeys = append(r.RevokedSubkeys, fpr)
+			} else {
+				r.Updated++
+			}
+		}
+	}
+	return
+}
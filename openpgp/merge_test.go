@@ -0,0 +1,160 @@
+package openpgp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// TestImportMerge validates that importing a refreshed copy of a key
+// merges the changes into the keyring and reports them.
+func TestImportMerge(t *testing.T) {
+	e, err := NewEntity(&KeyOptions{
+		Name:      "Test Key",
+		Email:     "test@example.net",
+		Algorithm: AlgoECC,
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fpr := fingerprint(e.PrimaryKey)
+
+	source := NewKeyRing("", false)
+	if err = source.Add(e, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	original, err := source.Export(fpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Add a user ID and revoke the key.
+	uid := packet.NewUserId("Test Key", "", "other@example.net")
+	sig := &packet.Signature{
+		CreationTime: DefaultConfig.Now(),
+		SigType:      packet.SigTypePositiveCert,
+		PubKeyAlgo:   e.PrimaryKey.PubKeyAlgo,
+		Hash:         DefaultConfig.Hash(),
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+	if err = sig.SignUserId(uid.Id, e.PrimaryKey, e.PrivateKey, DefaultConfig); err != nil {
+		t.Fatalf("%v", err)
+	}
+	pub := source.Entity(fpr)
+	pub.Identities[uid.Id] = &openpgp.Identity{Name: uid.Id, UserId: uid, SelfSignature: sig}
+
+	pkt, err := keyRevocation(e.PrivateKey, RevokeRetired, "testing")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	p, err := packet.Read(bytes.NewReader(pkt))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	pub.Revocations = append(pub.Revocations, p.(*packet.Signature))
+
+	updated, err := source.Export(fpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	keyRing := NewKeyRing("", false)
+	if _, err = keyRing.Import(original); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	results, err := keyRing.Import(updated)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(results) != 1 {
+		t.Fatalf("expected one result, have %d", len(results))
+	}
+
+	r := results[0]
+	if r.New || len(r.UserIDs) != 1 || r.UserIDs[0] != uid.Id || !r.Revoked {
+		t.Fatalf("wrong import result: %s", r)
+	} else if len(keyRing.Entity(fpr).Identities) != 2 || len(keyRing.Entity(fpr).Revocations) != 1 {
+		t.Fatal("changes weren't merged into the keyring")
+	}
+
+	results, err = keyRing.Import(updated)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if results[0].Changed() {
+		t.Fatalf("importing the same key twice changed it: %s", results[0])
+	} else if len(keyRing.Entity(fpr).Revocations) != 1 {
+		t.Fatal("revocation was duplicated")
+	}
+}
+
+// TestImportUserIDRevocation validates that a user ID revocation is
+// only merged if the key's primary key signed it.
+func TestImportUserIDRevocation(t *testing.T) {
+	newKey := func(email string) *openpgp.Entity {
+		e, err := NewEntity(&KeyOptions{Name: "Test Key", Email: email, Algorithm: AlgoECC})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return e
+	}
+	e := newKey("test@example.net")
+	other := newKey("other@example.net")
+	fpr := fingerprint(e.PrimaryKey)
+
+	keyRing := NewKeyRing("", false)
+	if err := keyRing.Add(e, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	original, err := keyRing.Export(fpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	revoke := func(priv *packet.PrivateKey) string {
+		source := NewKeyRing("", false)
+		if _, err := source.Import(original); err != nil {
+			t.Fatalf("%v", err)
+		}
+		pub := source.Entity(fpr)
+		for name, id := range pub.Identities {
+			sig := &packet.Signature{
+				CreationTime: id.SelfSignature.CreationTime.Add(time.Hour),
+				SigType:      sigTypeCertRevocation,
+				PubKeyAlgo:   priv.PubKeyAlgo,
+				Hash:         DefaultConfig.Hash(),
+				IssuerKeyId:  &pub.PrimaryKey.KeyId,
+			}
+			if err := sig.SignUserId(name, pub.PrimaryKey, priv, DefaultConfig); err != nil {
+				t.Fatalf("%v", err)
+			}
+			id.Signatures = append(id.Signatures, sig)
+		}
+		armoured, err := source.Export(fpr)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return armoured
+	}
+
+	results, err := keyRing.Import(revoke(other.PrivateKey))
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if results[0].Changed() {
+		t.Fatalf("a forged revocation was imported: %s", results[0])
+	}
+	for _, id := range keyRing.Entity(fpr).Identities {
+		if len(id.Signatures) != 0 {
+			t.Fatal("a forged revocation was stored")
+		}
+	}
+
+	results, err = keyRing.Import(revoke(e.PrivateKey))
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(results[0].RevokedUserIDs) != 1 {
+		t.Fatalf("a revocation wasn't imported: %s", results[0])
+	}
+}
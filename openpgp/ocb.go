@@ -0,0 +1,185 @@
+package openpgp
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// errOCBAuth is returned when an OCB tag doesn't match.
+var errOCBAuth = errors.New("openpgp: OCB authentication failed")
+
+const ocbTagSize = 16
+
+// ocb implements OCB mode (RFC 7253) with a 128-bit tag over a 128-bit
+// block cipher. gpg-agent uses it to protect secret keys since GnuPG
+// 2.2.
+type ocb struct {
+	block   cipher.Block
+	lStar   [16]byte
+	lDollar [16]byte
+	l       [][16]byte
+}
+
+func newOCB(block cipher.Block) *ocb {
+	o := &ocb{block: block}
+	block.Encrypt(o.lStar[:], o.lStar[:])
+	o.lDollar = ocbDouble(o.lStar)
+	o.l = append(o.l, ocbDouble(o.lDollar))
+	return o
+}
+
+func ocbDouble(in [16]byte) (out [16]byte) {
+	carry := in[0] >> 7
+	for i := 0; i < 15; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[15] = in[15]<<1 ^ carry*0x87
+	return
+}
+
+func ocbXor(dst *[16]byte, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
+
+// lAt returns L_i, extending the table as needed.
+func (o *ocb) lAt(i int) [16]byte {
+	for len(o.l) <= i {
+		o.l = append(o.l, ocbDouble(o.l[len(o.l)-1]))
+	}
+	return o.l[i]
+}
+
+func ntz(i int) (n int) {
+	for i&1 == 0 {
+		i >>= 1
+		n++
+	}
+	return
+}
+
+func (o *ocb) initialOffset(nonce []byte) (offset [16]byte) {
+	var n [16]byte
+	n[0] = (ocbTagSize * 8 % 128) << 1
+	n[15-len(nonce)] |= 1
+	copy(n[16-len(nonce):], nonce)
+	bottom := int(n[15] & 0x3f)
+	n[15] &= 0xc0
+
+	var ktop [16]byte
+	o.block.Encrypt(ktop[:], n[:])
+	var stretch [24]byte
+	copy(stretch[:], ktop[:])
+	for i := 0; i < 8; i++ {
+		stretch[16+i] = ktop[i] ^ ktop[i+1]
+	}
+
+	shift, bits := bottom/8, uint(bottom%8)
+	for i := 0; i < 16; i++ {
+		offset[i] = stretch[i+shift] << bits
+		if bits != 0 {
+			offset[i] |= stretch[i+shift+1] >> (8 - bits)
+		}
+	}
+	return
+}
+
+func (o *ocb) hash(ad []byte) (sum [16]byte) {
+	var offset, tmp [16]byte
+	i := 1
+	for ; len(ad) >= 16; i++ {
+		l := o.lAt(ntz(i))
+		ocbXor(&offset, l[:])
+		tmp = offset
+		ocbXor(&tmp, ad[:16])
+		o.block.Encrypt(tmp[:], tmp[:])
+		ocbXor(&sum, tmp[:])
+		ad = ad[16:]
+	}
+	if len(ad) > 0 {
+		ocbXor(&offset, o.lStar[:])
+		tmp = [16]byte{}
+		copy(tmp[:], ad)
+		tmp[len(ad)] = 0x80
+		ocbXor(&tmp, offset[:])
+		o.block.Encrypt(tmp[:], tmp[:])
+		ocbXor(&sum, tmp[:])
+	}
+	return
+}
+
+// crypt runs OCB over in, returning the output and the tag.
+func (o *ocb) crypt(encrypt bool, nonce, in, ad []byte) (out []byte, tag [16]byte) {
+	offset := o.initialOffset(nonce)
+	var checksum, tmp [16]byte
+	out = make([]byte, len(in))
+
+	i := 1
+	for pos := 0; len(in)-pos >= 16; i, pos = i+1, pos+16 {
+		l := o.lAt(ntz(i))
+		ocbXor(&offset, l[:])
+		tmp = offset
+		ocbXor(&tmp, in[pos:pos+16])
+		if encrypt {
+			ocbXor(&checksum, in[pos:pos+16])
+			o.block.Encrypt(tmp[:], tmp[:])
+		} else {
+			o.block.Decrypt(tmp[:], tmp[:])
+		}
+		ocbXor(&tmp, offset[:])
+		copy(out[pos:], tmp[:])
+		if !encrypt {
+			ocbXor(&checksum, tmp[:])
+		}
+	}
+
+	if rest := len(in) % 16; rest != 0 {
+		pos := len(in) - rest
+		ocbXor(&offset, o.lStar[:])
+		var pad [16]byte
+		o.block.Encrypt(pad[:], offset[:])
+		for j := 0; j < rest; j++ {
+			out[pos+j] = in[pos+j] ^ pad[j]
+		}
+
+		plain := in[pos:]
+		if !encrypt {
+			plain = out[pos:]
+		}
+		tmp = [16]byte{}
+		copy(tmp[:], plain)
+		tmp[rest] = 0x80
+		ocbXor(&checksum, tmp[:])
+	}
+
+	ocbXor(&checksum, offset[:])
+	ocbXor(&checksum, o.lDollar[:])
+	o.block.Encrypt(tag[:], checksum[:])
+	sum := o.hash(ad)
+	ocbXor(&tag, sum[:])
+	return
+}
+
+// Seal encrypts plaintext and appends the tag.
+func (o *ocb) Seal(nonce, plaintext, ad []byte) []byte {
+	out, tag := o.crypt(true, nonce, plaintext, ad)
+	return append(out, tag[:]...)
+}
+
+// Open authenticates and decrypts ciphertext, which includes the tag.
+func (o *ocb) Open(nonce, ciphertext, ad []byte) (plaintext []byte, err error) {
+	if len(ciphertext) < ocbTagSize || len(nonce) == 0 || len(nonce) > 15 {
+		err = errOCBAuth
+		return
+	}
+	n := len(ciphertext) - ocbTagSize
+	plaintext, tag := o.crypt(false, nonce, ciphertext[:n], ad)
+	if subtle.ConstantTimeCompare(tag[:], ciphertext[n:]) != 1 {
+		zero(plaintext)
+		plaintext = nil
+		err = errOCBAuth
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// TestOCBVectors checks the OCB implementation against the AES-128
+// test vectors in RFC 7253, appendix A.
+func TestOCBVectors(t *testing.T) {
+	var vectors = []struct {
+		nonce, ad, plain, cipher string
+	}{
+		{"BBAA99887766554433221100", "", "", "785407BFFFC8AD9EDCC5520AC9111EE6"},
+		{"BBAA99887766554433221101", "0001020304050607", "0001020304050607", "6820B3657B6F615A5725BDA0D3B4EB3A257C9AF1F8F03009"},
+		{"BBAA99887766554433221102", "0001020304050607", "", "81017F8203F081277152FADE694A0A00"},
+		{"BBAA99887766554433221103", "", "0001020304050607", "45DD69F8F5AAE72414054CD1F35D82760B2CD00D2F99BFA9"},
+		{"BBAA99887766554433221104", "000102030405060708090A0B0C0D0E0F", "000102030405060708090A0B0C0D0E0F", "571D535B60B277188BE5147170A9A22C3AD7A4FF3835B8C5701C1CCEC8FC3358"},
+		{"BBAA9988776655443322110F", "", "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F2021222324252627", "4412923493C57D5DE0D700F753CCE0D1D2D95060122E9F15A5DDBFC5787E50B5CC55EE507BCB084E479AD363AC366B95A98CA5F3000B1479"},
+	}
+
+	block, err := aes.NewCipher(mustHex("000102030405060708090A0B0C0D0E0F"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	o := newOCB(block)
+
+	for i, v := range vectors {
+		nonce, ad := mustHex(v.nonce), mustHex(v.ad)
+		plain, expected := mustHex(v.plain), mustHex(v.cipher)
+
+		out := o.Seal(nonce, plain, ad)
+		if !bytes.Equal(out, expected) {
+			t.Fatalf("vector %d: bad ciphertext %x", i, out)
+		}
+
+		out, err = o.Open(nonce, expected, ad)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		} else if !bytes.Equal(out, plain) {
+			t.Fatalf("vector %d: bad plaintext %x", i, out)
+		}
+
+		expected[0] ^= 1
+		if _, err = o.Open(nonce, expected, ad); err != errOCBAuth {
+			t.Fatalf("vector %d: tampered ciphertext was accepted", i)
+		}
+	}
+}
@@ -3,15 +3,16 @@ package openpgp
 import (
 	"bytes"
 	"crypto"
-	"crypto/sha1"
 	_ "crypto/sha512"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gokyle/readpass"
@@ -22,22 +23,53 @@ import (
 
 const Version = "0.1.0"
 
-var (
-	DefaultPublicKeyRing = filepath.Join(os.Getenv("HOME"), ".gnupg", "pubring.gpg")
-	DefaultSecretKeyRing = filepath.Join(os.Getenv("HOME"), ".gnupg", "secring.gpg")
-)
+// DefaultHome is the default GnuPG home directory.
+var DefaultHome = filepath.Join(os.Getenv("HOME"), ".gnupg")
+
+// The default public and secret keyrings; see SetKeyRingDir for how
+// these are chosen.
+var DefaultPublicKeyRing, DefaultSecretKeyRing = keyRingPaths(DefaultHome)
 
+// SetKeyRingDir sets the keyring paths to those in the named GnuPG
+// home directory.
 func SetKeyRingDir(dir string) {
-	PubRingPath = filepath.Join(dir, "pubring.gpg")
-	SecRingPath = filepath.Join(dir, "secring.gpg")
+	PubRingPath, SecRingPath = keyRingPaths(dir)
+}
+
+// keyRingPaths returns the public and secret keyrings in the GnuPG
+// home directory dir. GnuPG 2.1 and later keep public keys in a
+// keybox (pubring.kbx) and secret keys in the private-keys-v1.d
+// directory; older versions use pubring.gpg and secring.gpg. The
+// newer layout is used if a keybox is present, or if there is a
+// private-keys-v1.d directory and no secring.gpg.
+func keyRingPaths(dir string) (pub, sec string) {
+	kbx := filepath.Join(dir, "pubring.kbx")
+	agentDir := filepath.Join(dir, "private-keys-v1.d")
+	secring := filepath.Join(dir, "secring.gpg")
+	if fileExists(kbx) || (fileExists(agentDir) && !fileExists(secring)) {
+		pub = kbx
+		if !fileExists(kbx) {
+			pub = filepath.Join(dir, "pubring.gpg")
+		}
+		return pub, agentDir
+	}
+	return filepath.Join(dir, "pubring.gpg"), secring
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 var (
 	ErrPubRing          = errors.New("openpgp: public keyring")
 	ErrSecRing          = errors.New("openpgp: secret keyring")
-	ErrSecStore         = errors.New("openpgp: exporting secret keyring isn't supported'")
+	ErrKeyExists        = errors.New("openpgp: key is already in the keyring")
+	ErrKeyLocked        = errors.New("openpgp: secret key must be unlocked")
 	ErrKeyNotFound      = errors.New("openpgp: key not found")
 	ErrInvalidPublicKey = errors.New("openpgp: invalid public key")
+	ErrKeyRingChanged   = errors.New("openpgp: keyring changed on disk since it was loaded")
+	ErrBadPassphrase    = errors.New("openpgp: bad passphrase")
 )
 
 // Paths to the public and secret keyrings.
@@ -52,96 +84,364 @@ func ParanoidDefaultConfig() *packet.Config {
 		DefaultHash:            crypto.SHA384,
 		DefaultCipher:          packet.CipherAES256,
 		DefaultCompressionAlgo: packet.CompressionZLIB,
-		CompressionConfig:      &packet.CompressionConfig{-1},
+		CompressionConfig:      &packet.CompressionConfig{Level: -1},
 	}
 }
 
 var DefaultConfig *packet.Config
 
+// The on-disk formats a KeyRing can be loaded from.
+type ringFormat int
+
+const (
+	formatKeyRing  ringFormat = iota // unarmoured OpenPGP packets
+	formatKeybox                     // GnuPG 2.1+ pubring.kbx
+	formatAgent                      // GnuPG 2.1+ private-keys-v1.d
+	formatArmoured                   // ASCII-armoured keys, such as a .asc file
+	formatAscDir                     // a directory of .asc files
+)
+
 // A KeyRing contains a list of entities and the state required to
-// maintain the key ring.
+// maintain the key ring. It is safe for concurrent use, but entities
+// returned by its methods must not be modified while other goroutines
+// are using it.
 type KeyRing struct {
-	Entities map[string]*openpgp.Entity
-	path     string
-	private  bool
+	// Force allows expired and revoked keys to be used to sign,
+	// encrypt and upload. It should be set before the keyring is
+	// shared.
+	Force bool
+
+	// IdleTimeout, if set, locks unlocked keys again once they
+	// haven't been used for that long (see Lock). It should be set
+	// before the keyring is shared.
+	IdleTimeout time.Duration
+
+	mu       sync.RWMutex
+	entities map[string]*openpgp.Entity
+	lock     *dotlock  // nil for private-keys-v1.d, which gpg-agent owns
+	stamp    fileStamp // the keyring file as loaded or last stored
+
+	path      string
+	private   bool
+	format    ringFormat
+	agentKeys map[*packet.PrivateKey]*agentKey
+	kept      [][]byte          // keybox blobs to write back unchanged
+	stubs     map[string][]byte // GnuPG stubs for secret keys kept elsewhere
+	files     map[string]string // the .asc file each key was read from
+	origins   map[string]string // keys from read-only keyrings, and where they came from
+
+	// The following are indexed by key fingerprint, and are used
+	// to make sure secret keys are never stored unprotected.
+	sealed      map[string][]byte // encrypted secret key packets as read
+	passphrases map[string][]byte // passphrases keys were unlocked with
+	changed     map[string]bool   // keys whose passphrase was changed
+	onDisk      map[string]bool   // keys already in private-keys-v1.d
+
+	removed []*packet.PublicKey // deleted keys to remove from private-keys-v1.d
+
+	lastUsed map[string]time.Time // when unlocked keys were last used
+	relock   *time.Timer
 }
 
 // Private returns true if the keyring contains secret key material.
 func (keyRing *KeyRing) Private() bool {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
 	return keyRing.private
 }
 
+// Entity returns the key named by keyID, which may be any selector
+// accepted by Find. It returns nil unless exactly one key matches.
 func (keyRing *KeyRing) Entity(keyID string) (e *openpgp.Entity) {
-	return keyRing.Entities[strings.ToLower(keyID)]
+	e, _ = keyRing.find(keyID)
+	return
+}
+
+// Entities returns the keys in the keyring, in fingerprint order.
+func (keyRing *KeyRing) Entities() openpgp.EntityList {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+	return keyRing.sortedEntities()
+}
+
+// Len returns the number of keys in the keyring.
+func (keyRing *KeyRing) Len() int {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+	return len(keyRing.entities)
 }
 
-// LoadKeyRing reads the unarmoured keyring stored at the named path.
+// LoadKeyRing reads the keyring stored at the named path. This may be
+// a keyring such as pubring.gpg or secring.gpg, armoured or not, a
+// GnuPG 2.1+ keybox, a directory of .asc files, or a private-keys-v1.d
+// directory; in the last case, the public keys are read from the
+// keyring alongside it. The keyring file is locked while it is read.
 func LoadKeyRing(path string) (keyRing *KeyRing, err error) {
-	file, err := os.Open(path)
+	return loadKeyRing(path, nil)
+}
+
+// loadKeyRing loads a keyring, using lock if the caller already holds
+// the keyring's lock.
+func loadKeyRing(path string, lock *dotlock) (keyRing *KeyRing, err error) {
+	fi, err := os.Stat(path)
 	if err != nil {
 		return
 	}
-	defer file.Close()
 
-	el, err := openpgp.ReadKeyRing(file)
+	keyRing = newKeyRing(path)
+	var el openpgp.EntityList
+	if fi.IsDir() && isAgentDir(path) {
+		keyRing.format = formatAgent
+		keyRing.private = true
+		el, err = loadAgentKeys(path, keyRing)
+	} else {
+		keyRing.lock = lock
+		if lock == nil {
+			keyRing.lock = newDotlock(path)
+		}
+		if fi.IsDir() {
+			el, err = keyRing.readAscDir(path)
+		} else {
+			el, err = keyRing.readKeyRingFile(path)
+		}
+	}
 	if err != nil {
+		keyRing = nil
 		return
 	}
 
-	keyRing = new(KeyRing)
-	keyRing.path = path
-	keyRing.Entities = map[string]*openpgp.Entity{}
-	for _, e := range el {
-		if e.PrivateKey != nil {
-			keyRing.private = true
+	keyRing.addEntities(el)
+	return
+}
+
+// NewKeyRing returns an empty keyring that will be stored at path. The
+// format is chosen from the name: a .kbx file is a keybox, a .asc file
+// is armoured, and a private-keys-v1.d directory holds gpg-agent keys.
+func NewKeyRing(path string, private bool) (keyRing *KeyRing) {
+	keyRing = newKeyRing(path)
+	keyRing.private = private
+	switch {
+	case filepath.Ext(path) == ".kbx":
+		keyRing.format = formatKeybox
+	case filepath.Ext(path) == ".asc":
+		keyRing.format = formatArmoured
+	case filepath.Base(path) == "private-keys-v1.d":
+		keyRing.format = formatAgent
+		keyRing.private = true
+	}
+
+	if keyRing.format != formatAgent && path != "" {
+		keyRing.lock = newDotlock(path)
+	}
+	keyRing.stamp = fileStamp{path: path}
+	return
+}
+
+// UpdateKeyRing loads the keyring at path, or creates an empty one if
+// there isn't one, and calls fn to change it; if fn succeeds, the
+// keyring is stored. The keyring file stays locked throughout, so
+// other programs can't change it in the meantime.
+func UpdateKeyRing(path string, private bool, fn func(keyRing *KeyRing) error) (err error) {
+	var lock *dotlock
+	if !isAgentDir(path) {
+		lock = newDotlock(path)
+		err = lock.lock(LockTimeout)
+		if err != nil {
+			return
+		}
+		defer lock.release()
+	}
+
+	keyRing, err := loadKeyRing(path, lock)
+	if os.IsNotExist(err) {
+		keyRing, err = NewKeyRing(path, private), nil
+		if lock != nil {
+			keyRing.lock = lock
 		}
-		id := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
-		keyRing.Entities[id] = e
 	}
+	if err != nil {
+		return
+	}
+
+	err = fn(keyRing)
+	if err != nil {
+		return
+	}
+	return keyRing.Store()
+}
+
+func newKeyRing(path string) *KeyRing {
+	return &KeyRing{
+		entities:    map[string]*openpgp.Entity{},
+		path:        path,
+		agentKeys:   map[*packet.PrivateKey]*agentKey{},
+		sealed:      map[string][]byte{},
+		passphrases: map[string][]byte{},
+		changed:     map[string]bool{},
+		onDisk:      map[string]bool{},
+		stubs:       map[string][]byte{},
+		files:       map[string]string{},
+		origins:     map[string]string{},
+		lastUsed:    map[string]time.Time{},
+	}
+}
+
+// readKeyRingFile reads a keyring, armoured or not, or a keybox,
+// setting the keyring's format to match. The file is locked while it is read.
+func (keyRing *KeyRing) readKeyRingFile(path string) (el openpgp.EntityList, err error) {
+	lock := keyRing.lock
+	if lock == nil || path != keyRing.path {
+		lock = newDotlock(path)
+	}
+	err = lock.lock(LockTimeout)
+	if err != nil {
+		return
+	}
+	defer lock.release()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if path == keyRing.path {
+		keyRing.stamp = stampData(path, data)
+	}
+
+	if isKeybox(data) {
+		keyRing.format = formatKeybox
+		el, keyRing.kept, err = readKeybox(data)
+		return
+	}
+
+	keyRing.format = formatKeyRing
+	if isArmoured(data) {
+		keyRing.format = formatArmoured
+	}
+	return keyRing.readPackets(data)
+}
+
+// loadAgentKeys reads the secret keys in a private-keys-v1.d
+// directory, returning the entities they belong to.
+func loadAgentKeys(dir string, keyRing *KeyRing) (el openpgp.EntityList, err error) {
+	pub, err := newKeyRing(dir).readKeyRingFile(agentPublicRing(dir))
+	if err != nil {
+		return
+	}
+
+	keys, err := readAgentKeys(dir)
+	if err != nil {
+		return
+	}
+
+	el = keyRing.attachAgentKeys(pub, keys)
 	return
 }
 
-// Store writes the keyring to disk as an unarmoured keyring.
+// Store writes the keyring back to disk. Secret keys are never written
+// unprotected unless they were unprotected to begin with: keys that
+// have been unlocked are re-encrypted with their passphrase (or the
+// one set with SetPassphrase). Keyring files are replaced atomically,
+// keeping their permissions; new secret keyrings are only readable by
+// their owner.
+//
+// The keyring file is locked while it is written. If another program
+// has changed it since it was loaded, ErrKeyRingChanged is returned
+// and nothing is written; the keyring should be loaded again and the
+// changes made again, or made with UpdateKeyRing.
 func (keyRing *KeyRing) Store() (err error) {
-	if keyRing.private {
-		err = ErrSecStore
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	if keyRing.path == "" {
+		return ErrReadOnly
+	} else if keyRing.format == formatAgent {
+		return keyRing.storeAgentKeys()
+	}
+
+	if keyRing.lock == nil || keyRing.lock.file != keyRing.path {
+		keyRing.lock = newDotlock(keyRing.path)
+	}
+	err = keyRing.lock.lock(LockTimeout)
+	if err != nil {
 		return
 	}
-	tempFile, err := ioutil.TempFile(filepath.Dir(keyRing.path), "openpgp")
+	defer keyRing.lock.release()
+
+	// A keyring stored somewhere other than where it was loaded from
+	// replaces whatever is there.
+	current, err := stampFile(keyRing.path)
 	if err != nil {
 		return
+	} else if keyRing.stamp.path == keyRing.path && current != keyRing.stamp {
+		err = ErrKeyRingChanged
+		return
 	}
-	defer tempFile.Close()
 
-	for _, e := range keyRing.Entities {
-		err = e.Serialize(tempFile)
-		if err != nil {
-			tempFile.Close()
-			os.Remove(tempFile.Name())
-			return
-		}
+	var perm os.FileMode = 0644
+	if keyRing.private {
+		perm = 0600
+	}
+
+	switch keyRing.format {
+	case formatKeybox:
+		err = writeFileAtomic(keyRing.path, 0644, keyRing.writeKeybox)
+	case formatArmoured:
+		err = writeFileAtomic(keyRing.path, perm, func(w io.Writer) error {
+			return keyRing.writeArmoured(w, keyRing.ownEntities())
+		})
+	case formatAscDir:
+		err = keyRing.storeAscDir(perm)
+	default:
+		err = writeFileAtomic(keyRing.path, perm, func(w io.Writer) error {
+			for _, e := range keyRing.ownEntities() {
+				err := keyRing.serializeEntity(w, e, keyRing.private)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
 	}
-	tempFile.Close()
-	err = os.Rename(tempFile.Name(), keyRing.path)
 	if err != nil {
-		os.Remove(tempFile.Name())
+		return
 	}
+
+	keyRing.stamp, err = stampFile(keyRing.path)
 	return
 }
 
-// Import imports an armoured public key block.
-func (keyRing *KeyRing) Import(armoured string) (n int, err error) {
-	buf := bytes.NewBufferString(armoured)
-	el, err := openpgp.ReadArmoredKeyRing(buf)
+// Import imports an armoured key block; secret keys may only be
+// imported into a secret keyring, and public keys into a public one.
+// Keys already in the keyring are merged with the imported copy, so
+// that new user IDs, subkeys, signatures and revocations are kept. A
+// revocation certificate revokes the key it was made for, which must
+// be in the keyring. A result is returned for each key in the block.
+func (keyRing *KeyRing) Import(armoured string) (results []*ImportResult, err error) {
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	block, err := armor.Decode(bytes.NewBufferString(armoured))
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		return
+	} else if len(data) > 0 && packetTag(data) == tagSignature {
+		return keyRing.importRevocations(data)
+	}
+
+	data, stubs := stripStubs(data)
+	el, err := openpgp.ReadKeyRing(bytes.NewReader(data))
 	if err != nil {
 		return
 	}
 
 	for _, e := range el {
-		if keyRing.private && e.PrivateKey == nil {
+		if keyRing.private && !hasSecretKey(e) {
 			err = ErrSecRing
 			return
-		} else if !keyRing.private && e.PrivateKey != nil {
+		} else if !keyRing.private && hasSecretKey(e) {
 			err = ErrPubRing
 			return
 		}
@@ -154,19 +454,52 @@ func (keyRing *KeyRing) Import(armoured string) (n int, err error) {
 		}
 	}
 
+	sealed := sealedKeys(data)
 	for _, e := range el {
-		id := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
-		if _, ok := keyRing.Entities[id]; !ok {
-			keyRing.Entities[id] = e
-			n++
+		id := fingerprint(e.PrimaryKey)
+		var result *ImportResult
+		if current, ok := keyRing.entities[id]; ok {
+			result = mergeEntity(current, e)
+			if result.Changed() {
+				delete(keyRing.origins, id)
+			}
+		} else {
+			keyRing.entities[id] = e
+			result = &ImportResult{Fingerprint: id, New: true}
+		}
+		results = append(results, result)
+
+		for _, pub := range entityKeys(keyRing.entities[id]) {
+			fpr := fingerprint(pub)
+			if _, ok := keyRing.sealed[fpr]; !ok && sealed[fpr] != nil {
+				keyRing.sealed[fpr] = sealed[fpr]
+			}
+			if _, ok := keyRing.stubs[fpr]; !ok && stubs[fpr] != nil {
+				keyRing.stubs[fpr] = stubs[fpr]
+			}
 		}
 	}
 	return
 }
 
-// Export writes out the named public key, or all public keys if keyID
-// is empty. The result is an ASCII-armoured public key.
+// entityKeys returns the primary key and subkeys of e.
+func entityKeys(e *openpgp.Entity) (keys []*packet.PublicKey) {
+	keys = append(keys, e.PrimaryKey)
+	for _, subkey := range e.Subkeys {
+		keys = append(keys, subkey.PublicKey)
+	}
+	return
+}
+
+// Export writes out the named public key (see Find), or all public
+// keys if keyID is empty. The result is an ASCII-armoured public key.
 func (keyRing *KeyRing) Export(keyID string) (armoured string, err error) {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+	return keyRing.export(keyID)
+}
+
+func (keyRing *KeyRing) export(keyID string) (armoured string, err error) {
 	buf := new(bytes.Buffer)
 	blockType := openpgp.PublicKeyType
 	blockHeaders := map[string]string{
@@ -179,19 +512,25 @@ func (keyRing *KeyRing) Export(keyID string) (armoured string, err error) {
 	}
 
 	if keyID != "" {
-		e, ok := keyRing.Entities[strings.ToLower(keyID)]
-		if !ok {
-			err = ErrKeyNotFound
+		var e *openpgp.Entity
+		e, err = keyRing.find(keyID)
+		if err != nil {
+			return
+		}
+		err = keyRing.serializeEntity(armourBuffer, e, false)
+		if err != nil {
 			return
 		}
-		e.Serialize(armourBuffer)
 	} else {
-		if len(keyRing.Entities) == 0 {
+		if len(keyRing.entities) == 0 {
 			err = ErrKeyNotFound
 			return
 		}
-		for _, e := range keyRing.Entities {
-			e.Serialize(armourBuffer)
+		for _, e := range keyRing.entities {
+			err = keyRing.serializeEntity(armourBuffer, e, false)
+			if err != nil {
+				return
+			}
 		}
 	}
 
@@ -201,45 +540,300 @@ func (keyRing *KeyRing) Export(keyID string) (armoured string, err error) {
 	return
 }
 
-// Unlock decrypts the secured key, reading the passphrase from the
-// command line.
+// ExportForUpload exports the named key to be published, refusing keys
+// that have expired or been revoked unless Force is set.
+func (keyRing *KeyRing) ExportForUpload(keyID string) (armoured string, err error) {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	e, err := keyRing.find(keyID)
+	if err != nil {
+		return
+	}
+
+	if !keyRing.Force {
+		err = checkPrimary(e, DefaultConfig.Now())
+		if err != nil {
+			return
+		}
+	}
+	return keyRing.export(fingerprint(e.PrimaryKey))
+}
+
+// ExportSecret writes out the named secret key as an ASCII-armoured
+// private key block. Keys are protected as they would be by Store.
+func (keyRing *KeyRing) ExportSecret(keyID string) (armoured string, err error) {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	e, err := keyRing.find(keyID)
+	if err != nil {
+		return
+	} else if !hasSecretKey(e) {
+		err = ErrKeyNotFound
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	blockHeaders := map[string]string{
+		"Version": fmt.Sprintf("Keybase Go client (OpenPGP version %s)", Version),
+	}
+	armourBuffer, err := armor.Encode(buf, openpgp.PrivateKeyType, blockHeaders)
+	if err != nil {
+		return
+	}
+
+	err = keyRing.serializeEntity(armourBuffer, e, true)
+	if err != nil {
+		return
+	}
+	armourBuffer.Close()
+
+	armoured = buf.String()
+	return
+}
+
+// Add adds a new entity, such as one from NewEntity, to the keyring.
+// In a secret keyring, the entity's secret keys will be protected with
+// passphrase when they are stored; a public keyring only keeps the
+// public keys.
+func (keyRing *KeyRing) Add(e *openpgp.Entity, passphrase []byte) (err error) {
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	id := fingerprint(e.PrimaryKey)
+	if _, ok := keyRing.entities[id]; ok {
+		err = ErrKeyExists
+		return
+	}
+
+	if !keyRing.private {
+		pub := *e
+		pub.PrivateKey = nil
+		pub.Subkeys = nil
+		for _, subkey := range e.Subkeys {
+			subkey.PrivateKey = nil
+			pub.Subkeys = append(pub.Subkeys, subkey)
+		}
+		keyRing.entities[id] = &pub
+		return
+	}
+
+	if !hasSecretKey(e) {
+		err = ErrSecRing
+		return
+	}
+	keyRing.entities[id] = e
+	if len(passphrase) > 0 {
+		for _, pub := range entityKeys(e) {
+			keyRing.keepPassphrase(fingerprint(pub), passphrase)
+		}
+	}
+	return
+}
+
+// Delete removes the named key from the keyring, returning it. The
+// change is written when the keyring is stored.
+func (keyRing *KeyRing) Delete(keyID string) (e *openpgp.Entity, err error) {
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	e, err = keyRing.find(keyID)
+	if err != nil {
+		return
+	} else if _, ok := keyRing.origins[fingerprint(e.PrimaryKey)]; ok {
+		e, err = nil, ErrReadOnly
+		return
+	}
+
+	delete(keyRing.entities, fingerprint(e.PrimaryKey))
+	for _, pub := range entityKeys(e) {
+		fpr := fingerprint(pub)
+		if keyRing.onDisk[fpr] {
+			keyRing.removed = append(keyRing.removed, pub)
+		}
+		for _, m := range []map[string][]byte{keyRing.sealed, keyRing.stubs} {
+			delete(m, fpr)
+		}
+		keyRing.forgetPassphrase(fpr)
+		delete(keyRing.changed, fpr)
+		delete(keyRing.onDisk, fpr)
+	}
+	return
+}
+
+// Unlock decrypts the named secret key, reading the passphrase from
+// the command line. A subkey ID unlocks that subkey; otherwise the
+// primary key is unlocked, or the signing subkey if the primary key is
+// kept offline. Any other secret keys protected with the same
+// passphrase are unlocked too. Lock undoes this.
 func (keyRing *KeyRing) Unlock(keyID string) (err error) {
-	e, ok := keyRing.Entities[strings.ToLower(keyID)]
-	if !ok || e.PrivateKey == nil {
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	e, err := keyRing.find(keyID)
+	if err != nil {
+		return
+	} else if !hasSecretKey(e) {
 		err = ErrKeyNotFound
 		return
 	}
 
-	if !e.PrivateKey.Encrypted {
+	priv := e.PrivateKey
+	if id, ok := hexSelector(keyID); ok {
+		for _, subkey := range e.Subkeys {
+			if subkey.PrivateKey != nil && strings.HasSuffix(fingerprint(subkey.PublicKey), id) {
+				priv = subkey.PrivateKey
+			}
+		}
+	}
+	if priv == nil {
+		_, priv, err = signingKey(e, DefaultConfig.Now(), keyRing.Force)
+		if err != nil {
+			for _, subkey := range e.Subkeys {
+				if subkey.PrivateKey != nil {
+					priv, err = subkey.PrivateKey, nil
+					break
+				}
+			}
+		}
+	}
+	return keyRing.unlockKey(e, priv)
+}
+
+// readPassphrase prompts for a passphrase on the terminal.
+var readPassphrase = readpass.PasswordPromptBytes
+
+// unlockKey decrypts priv, one of e's secret keys, prompting for its
+// passphrase. The passphrase is also tried on e's other locked keys.
+// Unlocking a key counts as using it for IdleTimeout. It's called with
+// the keyring locked for writing, and unlocks it while the passphrase
+// is typed, so that other users of the keyring aren't held up.
+func (keyRing *KeyRing) unlockKey(e *openpgp.Entity, priv *packet.PrivateKey) (err error) {
+	if !priv.Encrypted {
+		keyRing.touch(e)
 		return
 	}
 
-	var id string
-	for k := range e.Identities {
-		id = k
-		break
+	what := "key"
+	if priv.IsSubkey {
+		what = "subkey"
 	}
-	prompt := fmt.Sprintf(`Please enter the passphrase for the key:
+	prompt := fmt.Sprintf(`Please enter the passphrase for the %s:
     %s
-    %x
-Enter passphrase: `, id, e.PrimaryKey.KeyId)
-	passphrase, err := readpass.PasswordPromptBytes(prompt)
+    %X
+Enter passphrase: `, what, primaryName(e), priv.KeyId)
+	keyRing.mu.Unlock()
+	passphrase, err := readPassphrase(prompt)
+	keyRing.mu.Lock()
 	if err != nil {
 		return
 	}
+	defer zero(passphrase)
+
+	// The key may have been unlocked, or locked again, meanwhile.
+	if priv.Encrypted {
+		err = keyRing.decrypt(priv, passphrase)
+		if err != nil {
+			return
+		}
+	}
+	keyRing.touch(e)
 
-	err = e.PrivateKey.Decrypt(passphrase)
+	if e.PrivateKey != nil && e.PrivateKey.Encrypted {
+		keyRing.decrypt(e.PrivateKey, passphrase)
+	}
+	for _, subkey := range e.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			keyRing.decrypt(subkey.PrivateKey, passphrase)
+		}
+	}
 	return
 }
 
-// Sign signs the given message.
+// SetPassphrase sets the passphrase that will protect the named key's
+// secret key material when the keyring is stored. Every secret key in
+// the entity must be unlocked. An empty passphrase is rejected;
+// secret keys are never stored unprotected.
+func (keyRing *KeyRing) SetPassphrase(keyID string, passphrase []byte) (err error) {
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	e, err := keyRing.find(keyID)
+	if err != nil {
+		return
+	} else if !keyRing.private {
+		err = ErrKeyNotFound
+		return
+	} else if len(passphrase) == 0 {
+		err = ErrBadPassphrase
+		return
+	}
+
+	var privs []*packet.PrivateKey
+	if e.PrivateKey != nil {
+		privs = append(privs, e.PrivateKey)
+	}
+	for _, subkey := range e.Subkeys {
+		if subkey.PrivateKey != nil {
+			privs = append(privs, subkey.PrivateKey)
+		}
+	}
+
+	for _, priv := range privs {
+		if priv.Encrypted {
+			err = ErrKeyLocked
+			return
+		}
+	}
+
+	for _, priv := range privs {
+		fpr := fingerprint(&priv.PublicKey)
+		keyRing.keepPassphrase(fpr, passphrase)
+		keyRing.changed[fpr] = true
+	}
+	return
+}
+
+// decrypt decrypts a private key from the keyring with passphrase, a
+// copy of which is kept so the key can be re-encrypted when it is
+// stored or locked.
+func (keyRing *KeyRing) decrypt(priv *packet.PrivateKey, passphrase []byte) (err error) {
+	if ak, ok := keyRing.agentKeys[priv]; ok {
+		err = ak.unprotect(priv, passphrase)
+	} else {
+		err = priv.Decrypt(passphrase)
+	}
+	if err == nil {
+		keyRing.keepPassphrase(fingerprint(&priv.PublicKey), passphrase)
+	}
+	return
+}
+
+// Sign signs the given message with the named key. The newest valid
+// signing subkey is used if there is one, so keys whose primary key
+// is kept offline can still sign. Expired and revoked keys are
+// refused unless the keyring's Force is set.
 func (keyRing *KeyRing) Sign(message []byte, keyID string) (sig []byte, err error) {
-	err = keyRing.Unlock(keyID)
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	signer, err := keyRing.find(keyID)
+	if err != nil {
+		return
+	}
+
+	now := DefaultConfig.Now()
+	pub, priv, err := signingKey(signer, now, keyRing.Force)
+	if err != nil {
+		return
+	}
+
+	err = keyRing.unlockKey(signer, priv)
 	if err != nil {
 		return
 	}
 
-	signer := keyRing.Entities[strings.ToLower(keyID)]
 	buf := new(bytes.Buffer)
 	hdr := map[string]string{
 		"Version": fmt.Sprintf("Keybase Go client (OpenPGP version %s)", Version),
@@ -249,11 +843,12 @@ func (keyRing *KeyRing) Sign(message []byte, keyID string) (sig []byte, err erro
 		return
 	}
 
+	hashFunc := DefaultConfig.Hash()
 	opSig := &packet.OnePassSignature{
 		SigType:    packet.SigTypeBinary,
-		Hash:       crypto.SHA1,
-		PubKeyAlgo: packet.PubKeyAlgoRSA,
-		KeyId:      signer.PrimaryKey.KeyId,
+		Hash:       hashFunc,
+		PubKeyAlgo: pub.PubKeyAlgo,
+		KeyId:      pub.KeyId,
 		IsLast:     true,
 	}
 	err = opSig.Serialize(armourBuf)
@@ -261,7 +856,7 @@ func (keyRing *KeyRing) Sign(message []byte, keyID string) (sig []byte, err erro
 		return
 	}
 
-	literalPacket, err := newLiteralDataPacket(message, "", uint32(time.Now().Unix()))
+	literalPacket, err := newLiteralDataPacket(message, "", uint32(now.Unix()))
 	if err != nil {
 		return
 	}
@@ -272,14 +867,14 @@ func (keyRing *KeyRing) Sign(message []byte, keyID string) (sig []byte, err erro
 
 	sigPacket := &packet.Signature{
 		SigType:      packet.SigTypeBinary,
-		IssuerKeyId:  &signer.PrimaryKey.KeyId,
-		PubKeyAlgo:   packet.PubKeyAlgoRSA,
-		Hash:         crypto.SHA1,
-		CreationTime: time.Now(),
+		IssuerKeyId:  &pub.KeyId,
+		PubKeyAlgo:   pub.PubKeyAlgo,
+		Hash:         hashFunc,
+		CreationTime: now,
 	}
-	h := sha1.New()
+	h := hashFunc.New()
 	h.Write(message)
-	err = sigPacket.Sign(h, signer.PrivateKey, nil)
+	err = sigPacket.Sign(h, priv, DefaultConfig)
 	if err != nil {
 		return
 	}
@@ -293,43 +888,86 @@ func (keyRing *KeyRing) Sign(message []byte, keyID string) (sig []byte, err erro
 	return
 }
 
-// NewEntity creates a new entity. It doesn't provide an option for comments.
-func NewEntity(name, email, outFile string) (ne *openpgp.Entity, err error) {
-	ne, err = openpgp.NewEntity(name, "", email, DefaultConfig)
-	if err != nil {
-		return
-	}
+// Encrypt encrypts message to each of the recipients, which may be
+// any selector accepted by Find. Each recipient's newest valid
+// encryption subkey is used; as with Sign, expired and revoked keys
+// are refused unless Force is set.
+func (keyRing *KeyRing) Encrypt(message []byte, recipients ...string) (ct []byte, err error) {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	now := DefaultConfig.Now()
+	var keys []*packet.PublicKey
+	for _, recipient := range recipients {
+		var e *openpgp.Entity
+		e, err = keyRing.find(recipient)
+		if err != nil {
+			return
+		}
 
-	out, err := os.Create(outFile)
-	if err != nil {
-		ne = nil
+		var pub *packet.PublicKey
+		pub, err = encryptionKey(e, now, keyRing.Force)
+		if err != nil {
+			return
+		}
+		keys = append(keys, pub)
+	}
+	if len(keys) == 0 {
+		err = ErrKeyNotFound
 		return
 	}
 
+	buf := new(bytes.Buffer)
 	hdr := map[string]string{
 		"Version": fmt.Sprintf("Keybase Go client (OpenPGP version %s)", Version),
 	}
+	armourBuf, err := armor.Encode(buf, "PGP MESSAGE", hdr)
+	if err != nil {
+		return
+	}
 
-	keyOut, err := armor.Encode(out, openpgp.PrivateKeyType, hdr)
+	cipher := DefaultConfig.Cipher()
+	key := make([]byte, cipher.KeySize())
+	_, err = io.ReadFull(DefaultConfig.Random(), key)
 	if err != nil {
-		ne = nil
 		return
 	}
+	defer zero(key)
 
-	defer func() {
-		keyOut.Close()
-		out.Close()
-	}()
+	for _, pub := range keys {
+		err = packet.SerializeEncryptedKey(armourBuf, pub, cipher, key, DefaultConfig)
+		if err != nil {
+			return
+		}
+	}
 
-	err = ne.SerializePrivate(keyOut, DefaultConfig)
+	encrypted, err := packet.SerializeSymmetricallyEncrypted(armourBuf, cipher, key, DefaultConfig)
 	if err != nil {
-		ne = nil
 		return
 	}
-
+	literal, err := packet.SerializeLiteral(encrypted, true, "", uint32(now.Unix()))
+	if err != nil {
+		return
+	}
+	_, err = literal.Write(message)
+	if err != nil {
+		return
+	}
+	err = literal.Close()
+	if err != nil {
+		return
+	}
+	armourBuf.Close()
+	ct = buf.Bytes()
 	return
 }
 
+func zero(in []byte) {
+	for i := range in {
+		in[i] ^= in[i]
+	}
+}
+
 func newLiteralDataPacket(literal []byte, fileName string, ts uint32) (pkt []byte, err error) {
 	var header = [3]byte{0x80}
 	header[0] |= (11 << 2)
@@ -3,6 +3,7 @@ package openpgp
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"golang.org/x/crypto/openpgp"
@@ -100,7 +101,7 @@ func TestStorePubRing(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 
-	if len(testPubRing.Entities) < 1 {
+	if len(testPubRing.entities) < 1 {
 		t.Fatal("no entities loaded")
 	}
 
@@ -111,12 +112,12 @@ func TestStorePubRing(t *testing.T) {
 		t.Fatal("exported key ring should be public")
 	}
 
-	if len(tempKeyRing.Entities) != len(testPubRing.Entities) {
+	if len(tempKeyRing.entities) != len(testPubRing.entities) {
 		t.Fatal("the key ring was not fully exported")
 	}
 
-	for k, _ := range testPubRing.Entities {
-		if _, ok := tempKeyRing.Entities[k]; !ok {
+	for k, _ := range testPubRing.entities {
+		if _, ok := tempKeyRing.entities[k]; !ok {
 			t.Fatal("key in public key ring wasn't exported'")
 		}
 	}
@@ -133,32 +134,85 @@ func TestLoadSecRing(t *testing.T) {
 	}
 }
 
-// TestStoreSecRing tests to ensure that attempting to store a secret
-// keyring fails; this is due to a limitation in the Go openpgp
-// package.
+// TestStoreSecRing validates storing a secret keyring: keys must stay
+// encrypted, the file must only be readable by its owner, and a
+// changed passphrase must be used when the keyring is next stored.
 func TestStoreSecRing(t *testing.T) {
-	tempFile, err := ioutil.TempFile("testdata/", "openpgp_test")
+	tempDir, err := ioutil.TempDir("testdata/", "openpgp_test")
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
+	defer os.RemoveAll(tempDir)
+	tfName := filepath.Join(tempDir, "secring.gpg")
 
-	tfName := tempFile.Name()
-	tempFile.Close()
-	defer os.Remove(tfName)
+	// Only the first test key is protected with "passphrase".
+	fpr := "1f72f8b9cf8d215881e3c1d0af7db9c0ccaff8eb"
+	e := testSecRing.entities[fpr]
+	if err = testSecRing.decrypt(e.PrivateKey, []byte("passphrase")); err != nil {
+		t.Fatalf("%v", err)
+	}
 
 	testSecRing.path = tfName
 	err = testSecRing.Store()
-	if err != ErrSecStore {
-		t.Fatal("secret keyring should not be exportable")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	fi, err := os.Stat(tfName)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Fatalf("secret keyring stored with mode %v", fi.Mode().Perm())
+	}
+
+	tempKeyRing, err := LoadKeyRing(tfName)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !tempKeyRing.private {
+		t.Fatal("stored secret keyring should be private")
+	} else if len(tempKeyRing.entities) != len(testSecRing.entities) {
+		t.Fatal("the secret keyring was not fully stored")
+	}
+
+	stored := tempKeyRing.entities[fpr]
+	if !stored.PrivateKey.Encrypted {
+		t.Fatal("unlocked key was stored unencrypted")
+	} else if err = tempKeyRing.decrypt(stored.PrivateKey, []byte("passphrase")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err = tempKeyRing.SetPassphrase(fpr, []byte("new passphrase")); err != ErrKeyLocked {
+		t.Fatal("passphrase changed on a locked subkey")
+	}
+	for _, subkey := range stored.Subkeys {
+		if err = tempKeyRing.decrypt(subkey.PrivateKey, []byte("passphrase")); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if err = tempKeyRing.SetPassphrase(fpr, []byte("new passphrase")); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = tempKeyRing.Store(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tempKeyRing, err = LoadKeyRing(tfName)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	stored = tempKeyRing.entities[fpr]
+	if err = tempKeyRing.decrypt(stored.PrivateKey, []byte("passphrase")); err == nil {
+		t.Fatal("old passphrase still unlocks the key")
+	} else if err = tempKeyRing.decrypt(stored.PrivateKey, []byte("new passphrase")); err != nil {
+		t.Fatalf("%v", err)
 	}
 }
 
 // TestImportPub validates importing armoured public keys.
 func TestImportPub(t *testing.T) {
-	n, err := testPubRing.Import(testPubArmoured)
+	results, err := testPubRing.Import(testPubArmoured)
 	if err != nil {
 		t.Fatalf("%v", err)
-	} else if n != 1 {
+	} else if len(results) != 1 || !results[0].New {
 		t.Fatal("only one public key should be imported")
 	}
 
@@ -182,10 +236,10 @@ func TestExportPub(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 
-	testPubRing.Entities = map[string]*openpgp.Entity{}
-	if n, err := testPubRing.Import(armoured); err != nil {
+	testPubRing.entities = map[string]*openpgp.Entity{}
+	if results, err := testPubRing.Import(armoured); err != nil {
 		t.Fatalf("%v", err)
-	} else if n != 1 {
+	} else if len(results) != 1 || !results[0].New {
 		t.Fatal("failed to import public key")
 	}
 
@@ -194,10 +248,10 @@ func TestExportPub(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 
-	testPubRing.Entities = map[string]*openpgp.Entity{}
-	if n, err := testPubRing.Import(armoured); err != nil {
+	testPubRing.entities = map[string]*openpgp.Entity{}
+	if results, err := testPubRing.Import(armoured); err != nil {
 		t.Fatalf("%v", err)
-	} else if n != 1 {
+	} else if len(results) != 1 || !results[0].New {
 		t.Fatal("failed to import public key")
 	}
 }
@@ -0,0 +1,85 @@
+package openpgp
+
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// OpenPGP packet tags used when building packets by hand.
+const (
+	tagSignature    = 2
+	tagSecretKey    = 5
+	tagPublicKey    = 6
+	tagSecretSubkey = 7
+	tagUserID       = 13
+	tagPublicSubkey = 14
+)
+
+// serializePacket writes body to w as a new-format packet.
+func serializePacket(w io.Writer, tag byte, body []byte) (err error) {
+	var hdr = []byte{0xc0 | tag}
+	n := len(body)
+	switch {
+	case n < 192:
+		hdr = append(hdr, byte(n))
+	case n < 8384:
+		n -= 192
+		hdr = append(hdr, byte(n>>8)+192, byte(n))
+	default:
+		hdr = append(hdr, 255, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+
+	_, err = w.Write(hdr)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(body)
+	return
+}
+
+// packetBody strips the header from a single serialised packet.
+func packetBody(pkt []byte) (body []byte, err error) {
+	if len(pkt) < 2 || pkt[0]&0x80 == 0 {
+		err = errors.StructuralError("invalid packet header")
+		return
+	}
+
+	var hdrLen int
+	if pkt[0]&0x40 == 0 {
+		// Old format packet.
+		hdrLen = []int{2, 3, 5, 1}[pkt[0]&3]
+	} else {
+		switch {
+		case pkt[1] < 192:
+			hdrLen = 2
+		case pkt[1] < 224:
+			hdrLen = 3
+		case pkt[1] == 255:
+			hdrLen = 6
+		default:
+			err = errors.UnsupportedError("partial length packet")
+			return
+		}
+	}
+
+	if len(pkt) < hdrLen {
+		err = errors.StructuralError("truncated packet header")
+		return
+	}
+	body = pkt[hdrLen:]
+	return
+}
+
+// publicKeyBody returns the body of the public key packet for pk,
+// which forms the start of the corresponding secret key packet.
+func publicKeyBody(pk *packet.PublicKey) (body []byte, err error) {
+	buf := new(bytes.Buffer)
+	err = pk.Serialize(buf)
+	if err != nil {
+		return
+	}
+	return packetBody(buf.Bytes())
+}
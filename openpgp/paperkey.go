@@ -0,0 +1,377 @@
+package openpgp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Errors returned when reading paper keys.
+var (
+	ErrPaperKeyFormat   = errors.New("openpgp: invalid paper key")
+	ErrPaperKeyChecksum = errors.New("openpgp: paper key checksum mismatch")
+)
+
+// A paper key holds only the secret parts of a key, in the format used
+// by David Shaw's paperkey: a version octet (0), then for the primary
+// key and each subkey, the key version (4), its 20-octet fingerprint,
+// a two-octet length and the secret key packet from the S2K usage
+// octet on. Secret keys are kept protected as they are stored, so a
+// paper key is no easier to use than the keyring it came from.
+const (
+	paperKeyVersion = 0
+	paperLineBytes  = 22 // bytes per line of base16 text, as paperkey prints them
+)
+
+// PaperKey returns the secret parts of the named key in paperkey's
+// binary format.
+func (keyRing *KeyRing) PaperKey(keyID string) (data []byte, err error) {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	e, err := keyRing.find(keyID)
+	if err != nil {
+		return
+	} else if !hasSecretKey(e) {
+		err = ErrKeyNotFound
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = keyRing.serializeEntity(buf, e, true)
+	if err != nil {
+		return
+	}
+	pkts, err := splitPackets(buf.Bytes())
+	if err != nil {
+		return
+	}
+
+	data = []byte{paperKeyVersion}
+	for _, pkt := range pkts {
+		tag := packetTag(pkt)
+		if tag != tagSecretKey && tag != tagSecretSubkey {
+			continue
+		}
+
+		var pub *packet.PublicKey
+		var secret []byte
+		pub, secret, err = splitSecretKey(pkt)
+		if err != nil {
+			return
+		}
+		data = append(data, 4)
+		data = append(data, pub.Fingerprint[:]...)
+		data = append(data, byte(len(secret)>>8), byte(len(secret)))
+		data = append(data, secret...)
+	}
+	return
+}
+
+// splitSecretKey splits a secret key packet into its public key and
+// the secret data that follows it.
+func splitSecretKey(pkt []byte) (pub *packet.PublicKey, secret []byte, err error) {
+	body, err := packetBody(pkt)
+	if err != nil {
+		return
+	}
+
+	// Reading the packet as a public key stops where the secret
+	// data starts, even for GnuPG stubs.
+	pubTag := byte(tagPublicKey)
+	if packetTag(pkt) == tagSecretSubkey {
+		pubTag = tagPublicSubkey
+	}
+	buf := new(bytes.Buffer)
+	err = serializePacket(buf, pubTag, body)
+	if err != nil {
+		return
+	}
+	p, err := packet.Read(buf)
+	if err != nil {
+		return
+	}
+	pub, ok := p.(*packet.PublicKey)
+	if !ok {
+		err = ErrPaperKeyFormat
+		return
+	}
+
+	pubBody, err := publicKeyBody(pub)
+	if err != nil {
+		return
+	} else if !bytes.HasPrefix(body, pubBody) {
+		err = ErrPaperKeyFormat
+		return
+	}
+	secret = body[len(pubBody):]
+	return
+}
+
+// crc24 is the CRC used by OpenPGP armour, which paperkey uses for
+// its checksums.
+func crc24(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+const crc24Init = 0xb704ce
+
+// EncodePaperKey formats a paper key as base16 text to be printed, as
+// paperkey does: each line is numbered and ends with a checksum of its
+// bytes, and the last line holds a checksum of the whole key.
+func EncodePaperKey(data []byte) (text string, err error) {
+	if len(data) < 1+1+20 || data[0] != paperKeyVersion {
+		err = ErrPaperKeyFormat
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "# Secret portions of key %X\n", data[2:22])
+	fmt.Fprintf(buf, "# Base16 data extracted %s\n", time.Now().UTC().Format(time.ANSIC))
+	fmt.Fprintf(buf, "# Created with the Keybase Go client (OpenPGP version %s)\n", Version)
+	buf.WriteString(`#
+# File format:
+# a) 1 octet:  Version of the paperkey format (currently 0).
+# b) 1 octet:  OpenPGP key or subkey version (currently 4)
+# c) n octets: Key fingerprint (20 octets for a version 4 key or subkey)
+# d) 2 octets: 16-bit big endian length of the following secret data
+# e) n octets: Secret data: a partial OpenPGP secret key or subkey packet as
+#              specified in RFC 4880, starting with the string-to-key usage
+#              octet and continuing until the end of the packet.
+# Repeat fields b through e as needed to cover all subkeys.
+#
+# To recover a secret key without this program, match each fingerprint
+# with a public key packet, append the secret data to it, and change the
+# packet tag from 6 to 5 (14 to 7 for subkeys).
+#
+# Each base16 line ends with a CRC-24 of that line.
+# The entire block of data ends with a CRC-24 of the entire block of data.
+
+`)
+
+	line := 0
+	for off := 0; off < len(data); off += paperLineBytes {
+		chunk := data[off:]
+		if len(chunk) > paperLineBytes {
+			chunk = chunk[:paperLineBytes]
+		}
+		line++
+		fmt.Fprintf(buf, "%3d: ", line)
+		for _, b := range chunk {
+			fmt.Fprintf(buf, "%02X ", b)
+		}
+		fmt.Fprintf(buf, "%06X\n", crc24(crc24Init, chunk))
+	}
+	fmt.Fprintf(buf, "%3d: %06X\n", line+1, crc24(crc24Init, data))
+
+	text = buf.String()
+	return
+}
+
+// DecodePaperKey reads a paper key, either as base16 text, which is
+// checked line by line, or in binary form. Comments and blank lines
+// are skipped, so the text may be typed back in by hand.
+func DecodePaperKey(text []byte) (data []byte, err error) {
+	if len(text) > 0 && text[0] == paperKeyVersion {
+		data = text
+		return
+	}
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			err = ErrPaperKeyFormat
+			return
+		}
+		n, perr := strconv.Atoi(strings.TrimSpace(line[:colon]))
+		if perr != nil || n != len(lines)+1 {
+			err = fmt.Errorf("line %d: %v", len(lines)+1, ErrPaperKeyFormat)
+			return
+		}
+
+		var b []byte
+		b, err = hex.DecodeString(strings.Join(strings.Fields(line[colon+1:]), ""))
+		if err != nil || len(b) < 3 {
+			err = fmt.Errorf("line %d: %v", n, ErrPaperKeyFormat)
+			return
+		}
+		lines = append(lines, b)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	} else if len(lines) < 2 {
+		err = ErrPaperKeyFormat
+		return
+	}
+
+	for i, line := range lines[:len(lines)-1] {
+		payload, sum := line[:len(line)-3], line[len(line)-3:]
+		if crc24(crc24Init, payload) != uint32(sum[0])<<16|uint32(sum[1])<<8|uint32(sum[2]) {
+			err = fmt.Errorf("line %d: %v", i+1, ErrPaperKeyChecksum)
+			return
+		}
+		data = append(data, payload...)
+	}
+
+	sum := lines[len(lines)-1]
+	if len(sum) != 3 || crc24(crc24Init, data) != uint32(sum[0])<<16|uint32(sum[1])<<8|uint32(sum[2]) {
+		err = ErrPaperKeyChecksum
+		data = nil
+		return
+	}
+	if data[0] != paperKeyVersion {
+		err = ErrPaperKeyFormat
+		data = nil
+	}
+	return
+}
+
+// paperSecrets returns the secret data in a paper key, indexed by
+// fingerprint.
+func paperSecrets(data []byte) (secrets map[string][]byte, err error) {
+	if len(data) < 1 || data[0] != paperKeyVersion {
+		err = ErrPaperKeyFormat
+		return
+	}
+
+	secrets = map[string][]byte{}
+	data = data[1:]
+	for len(data) > 0 {
+		if len(data) < 23 || data[0] != 4 {
+			return nil, ErrPaperKeyFormat
+		}
+		fpr := fmt.Sprintf("%x", data[1:21])
+		n := int(data[21])<<8 | int(data[22])
+		if len(data) < 23+n {
+			return nil, ErrPaperKeyFormat
+		}
+		secrets[fpr] = data[23 : 23+n]
+		data = data[23+n:]
+	}
+	return
+}
+
+// PaperKeyFingerprint returns the fingerprint of the primary key a
+// paper key was made from.
+func PaperKeyFingerprint(data []byte) (fpr string, err error) {
+	if len(data) < 1+1+20 || data[0] != paperKeyVersion {
+		err = ErrPaperKeyFormat
+		return
+	}
+	fpr = fmt.Sprintf("%x", data[2:22])
+	return
+}
+
+// RestorePaperKey recombines a paper key with the armoured public key
+// it was made from, returning the armoured secret key. The public key
+// supplies everything but the secret data, so it may come from
+// anywhere, such as keybase.io or a public keyring.
+func RestorePaperKey(data []byte, armouredPublic string) (armoured string, err error) {
+	secrets, err := paperSecrets(data)
+	if err != nil {
+		return
+	}
+
+	block, err := armor.Decode(strings.NewReader(armouredPublic))
+	if err != nil {
+		return
+	}
+	pub := new(bytes.Buffer)
+	_, err = pub.ReadFrom(block.Body)
+	if err != nil {
+		return
+	}
+	pkts, err := splitPackets(pub.Bytes())
+	if err != nil {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	blockHeaders := map[string]string{
+		"Version": fmt.Sprintf("Keybase Go client (OpenPGP version %s)", Version),
+	}
+	armourBuffer, err := armor.Encode(buf, openpgp.PrivateKeyType, blockHeaders)
+	if err != nil {
+		return
+	}
+
+	var restored int
+	for _, pkt := range pkts {
+		tag := packetTag(pkt)
+		if tag != tagPublicKey && tag != tagPublicSubkey {
+			_, err = armourBuffer.Write(pkt)
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		var p packet.Packet
+		p, err = packet.Read(bytes.NewReader(pkt))
+		if err != nil {
+			return
+		}
+		pk, ok := p.(*packet.PublicKey)
+		if !ok {
+			err = ErrInvalidPublicKey
+			return
+		}
+
+		var body []byte
+		body, err = packetBody(pkt)
+		if err != nil {
+			return
+		}
+		secret, ok := secrets[fingerprint(pk)]
+		if !ok {
+			_, err = armourBuffer.Write(pkt)
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		secretTag := byte(tagSecretKey)
+		if tag == tagPublicSubkey {
+			secretTag = tagSecretSubkey
+		}
+		err = serializePacket(armourBuffer, secretTag, append(body[:len(body):len(body)], secret...))
+		if err != nil {
+			return
+		}
+		restored++
+	}
+	if restored == 0 {
+		err = ErrKeyNotFound
+		return
+	}
+
+	err = armourBuffer.Close()
+	armoured = buf.String()
+	return
+}
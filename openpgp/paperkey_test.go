@@ -0,0 +1,72 @@
+package openpgp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestPaperKey validates that a secret key survives being written out
+// as a paper key and restored with its public key, and that mistakes
+// in the printed text are caught.
+func TestPaperKey(t *testing.T) {
+	secRing, err := LoadKeyRing(testSecRingPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fpr := fingerprint(secRing.Entities()[0].PrimaryKey)
+
+	data, err := secRing.PaperKey(fpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if paperFpr, err := PaperKeyFingerprint(data); err != nil || paperFpr != fpr {
+		t.Fatalf("paper key is for the wrong key: %s", paperFpr)
+	}
+
+	text, err := EncodePaperKey(data)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	decoded, err := DecodePaperKey([]byte(text))
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !bytes.Equal(decoded, data) {
+		t.Fatal("paper key text didn't decode to the same key")
+	}
+	if decoded, err = DecodePaperKey(data); err != nil || !bytes.Equal(decoded, data) {
+		t.Fatal("binary paper key wasn't read")
+	}
+
+	i := strings.Index(text, "  1: ") + 5
+	typo := text[:i] + "FF" + text[i+2:]
+	if _, err = DecodePaperKey([]byte(typo)); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("typo wasn't caught: %v", err)
+	}
+
+	pub, err := secRing.Export(fpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	armoured, err := RestorePaperKey(decoded, pub)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	restored := NewKeyRing("", true)
+	if _, err = restored.Import(armoured); err != nil {
+		t.Fatalf("%v", err)
+	}
+	again, err := restored.PaperKey(fpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !bytes.Equal(again, data) {
+		t.Fatal("restored key doesn't match the original")
+	}
+
+	pub, err = loadOfflineKey(t, "").Export("")
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if _, err = RestorePaperKey(decoded, pub); err != ErrKeyNotFound {
+		t.Fatal("paper key was restored onto the wrong key")
+	}
+}
@@ -0,0 +1,91 @@
+package openpgp
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// ErrNotRevocation is returned when importing signatures that aren't
+// key revocations.
+var ErrNotRevocation = errors.New("openpgp: signature isn't a key revocation")
+
+// RevocationCertificate returns an armoured revocation certificate for
+// the named key, prompting for its passphrase if it is locked. reason
+// is one of the Revoke constants, and text explains it. The key isn't
+// revoked until the certificate is imported.
+func (keyRing *KeyRing) RevocationCertificate(keyID string, reason byte, text string) (armoured string, err error) {
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	e, err := keyRing.find(keyID)
+	if err != nil {
+		return
+	} else if e.PrivateKey == nil {
+		err = ErrKeyNotFound
+		return
+	}
+
+	err = keyRing.unlockKey(e, e.PrivateKey)
+	if err != nil {
+		return
+	}
+
+	pkt, err := keyRevocation(e.PrivateKey, reason, text)
+	if err != nil {
+		return
+	}
+	return armouredRevocation(pkt, "This is a revocation certificate")
+}
+
+// importRevocations adds the key revocations in data, a revocation
+// certificate, to the keys they revoke.
+func (keyRing *KeyRing) importRevocations(data []byte) (results []*ImportResult, err error) {
+	pkts, err := splitPackets(data)
+	if err != nil {
+		return
+	}
+
+	for _, pkt := range pkts {
+		var p packet.Packet
+		p, err = packet.Read(bytes.NewReader(pkt))
+		if err != nil {
+			return
+		}
+		sig, ok := p.(*packet.Signature)
+		if !ok || sig.SigType != packet.SigTypeKeyRevocation || sig.IssuerKeyId == nil {
+			err = ErrNotRevocation
+			return
+		}
+
+		var e *openpgp.Entity
+		for _, candidate := range keyRing.entities {
+			if candidate.PrimaryKey.KeyId == *sig.IssuerKeyId {
+				e = candidate
+				break
+			}
+		}
+		if e == nil {
+			err = ErrKeyNotFound
+			return
+		}
+
+		err = e.PrimaryKey.VerifyRevocationSignature(sig)
+		if err != nil {
+			return
+		}
+
+		result := mergeEntity(e, &openpgp.Entity{
+			PrimaryKey:  e.PrimaryKey,
+			Identities:  map[string]*openpgp.Identity{},
+			Revocations: []*packet.Signature{sig},
+		})
+		if result.Changed() {
+			delete(keyRing.origins, result.Fingerprint)
+		}
+		results = append(results, result)
+	}
+	return
+}
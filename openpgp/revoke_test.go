@@ -0,0 +1,56 @@
+package openpgp
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRevocationCertificate validates that a revocation certificate
+// revokes its key when imported, once, and is refused for keys that
+// aren't in the keyring.
+func TestRevocationCertificate(t *testing.T) {
+	e, err := NewEntity(&KeyOptions{Name: "Revoked Key", Email: "revoked@example.net", Algorithm: AlgoECC})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	secRing := NewKeyRing("", true)
+	if err = secRing.Add(e, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	cert, err := secRing.RevocationCertificate("revoked@example.net", RevokeCompromise, "lost laptop")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	pubRing := NewKeyRing("", false)
+	if _, err = pubRing.Import(cert); err != ErrKeyNotFound {
+		t.Fatal("revocation was imported without its key")
+	}
+	if err = pubRing.Add(e, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	results, err := pubRing.Import(cert)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(results) != 1 || !results[0].Revoked {
+		t.Fatal("key wasn't revoked")
+	}
+
+	validity, err := pubRing.Validity("revoked@example.net", time.Now())
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if validity[0].Err() != ErrKeyRevoked {
+		t.Fatal("key should be reported as revoked")
+	} else if validity[0].Reasons[0] != "revoked (compromised): lost laptop" {
+		t.Fatalf("wrong revocation reason %q", validity[0].Reasons[0])
+	}
+
+	results, err = pubRing.Import(cert)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if results[0].Changed() {
+		t.Fatal("revocation was imported twice")
+	}
+}
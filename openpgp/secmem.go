@@ -0,0 +1,207 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/elgamal"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// newSecret copies secret, such as a passphrase, into a buffer that is
+// kept out of swap where the platform allows it. The buffer must be
+// released with wipe.
+func newSecret(secret []byte) (buf []byte) {
+	if len(secret) == 0 {
+		return nil
+	}
+	buf = allocSecret(len(secret))
+	copy(buf, secret)
+	return
+}
+
+// wipe zeroes and releases a buffer returned by newSecret.
+func wipe(buf []byte) {
+	if buf == nil {
+		return
+	}
+	zero(buf[:cap(buf)])
+	freeSecret(buf)
+}
+
+// wipeInt zeroes the words holding n.
+func wipeInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+	words := n.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	n.SetInt64(0)
+}
+
+// wipeKey zeroes the secret parameters of a decrypted private key.
+func wipeKey(priv *packet.PrivateKey) {
+	switch k := priv.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		wipeInt(k.D)
+		for _, p := range k.Primes {
+			wipeInt(p)
+		}
+		wipeInt(k.Precomputed.Dp)
+		wipeInt(k.Precomputed.Dq)
+		wipeInt(k.Precomputed.Qinv)
+		for _, crt := range k.Precomputed.CRTValues {
+			wipeInt(crt.Exp)
+			wipeInt(crt.Coeff)
+			wipeInt(crt.R)
+		}
+	case *ecdsa.PrivateKey:
+		wipeInt(k.D)
+	case *dsa.PrivateKey:
+		wipeInt(k.X)
+	case *elgamal.PrivateKey:
+		wipeInt(k.X)
+	}
+	priv.PrivateKey = nil
+}
+
+// keepPassphrase remembers the passphrase a key was unlocked or is to
+// be protected with, in a locked buffer.
+func (keyRing *KeyRing) keepPassphrase(fpr string, passphrase []byte) {
+	keyRing.forgetPassphrase(fpr)
+	keyRing.passphrases[fpr] = newSecret(passphrase)
+}
+
+// forgetPassphrase wipes the passphrase kept for a key.
+func (keyRing *KeyRing) forgetPassphrase(fpr string) {
+	if passphrase, ok := keyRing.passphrases[fpr]; ok {
+		wipe(passphrase)
+		delete(keyRing.passphrases, fpr)
+	}
+}
+
+// Lock discards the decrypted secret key material of the named key,
+// or of every key if keyID is empty, and wipes the passphrases they
+// were unlocked with. Each key is put back as it would be stored, so
+// it must be unlocked again before it can be used and a passphrase
+// set with SetPassphrase still applies. Keys that were never protected
+// stay as they are.
+//
+// Only passphrases are kept in memory that can't be swapped out; the
+// Go runtime may have copied decrypted keys elsewhere before they are
+// wiped.
+func (keyRing *KeyRing) Lock(keyID string) (err error) {
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	if keyID == "" {
+		for _, e := range keyRing.entities {
+			err = keyRing.lockEntity(e)
+			if err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	e, err := keyRing.find(keyID)
+	if err != nil {
+		return
+	}
+	return keyRing.lockEntity(e)
+}
+
+// lockEntity locks each of e's secret keys.
+func (keyRing *KeyRing) lockEntity(e *openpgp.Entity) (err error) {
+	delete(keyRing.lastUsed, fingerprint(e.PrimaryKey))
+
+	privs := []*packet.PrivateKey{e.PrivateKey}
+	for _, subkey := range e.Subkeys {
+		privs = append(privs, subkey.PrivateKey)
+	}
+	for _, priv := range privs {
+		err = keyRing.lockKey(priv)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// lockKey replaces a decrypted key with its protected form, as Store
+// would write it, and wipes the decrypted key.
+func (keyRing *KeyRing) lockKey(priv *packet.PrivateKey) (err error) {
+	if priv == nil || priv.Encrypted {
+		return
+	}
+
+	pkt, err := keyRing.secretPacket(priv)
+	if err != nil {
+		return
+	}
+	p, err := packet.Read(bytes.NewReader(pkt))
+	if err != nil {
+		return
+	}
+	locked, ok := p.(*packet.PrivateKey)
+	if !ok || !locked.Encrypted {
+		return
+	}
+
+	fpr := fingerprint(&priv.PublicKey)
+	wipeKey(priv)
+	delete(keyRing.agentKeys, priv)
+	*priv = *locked
+	keyRing.sealed[fpr] = pkt
+	keyRing.forgetPassphrase(fpr)
+	return
+}
+
+// touch records that e's secret keys were used, so that they are
+// locked again once they have been idle for IdleTimeout.
+func (keyRing *KeyRing) touch(e *openpgp.Entity) {
+	if keyRing.IdleTimeout <= 0 {
+		return
+	}
+
+	keyRing.lastUsed[fingerprint(e.PrimaryKey)] = time.Now()
+	if keyRing.relock == nil {
+		keyRing.relock = time.AfterFunc(keyRing.IdleTimeout, keyRing.relockIdle)
+	}
+}
+
+// relockIdle locks the keys that have been idle for IdleTimeout, and
+// schedules itself for the next key to become idle.
+func (keyRing *KeyRing) relockIdle() {
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	keyRing.relock = nil
+	now := time.Now()
+	var next time.Duration
+	for fpr, last := range keyRing.lastUsed {
+		idle := now.Sub(last)
+		if idle < keyRing.IdleTimeout {
+			if wait := keyRing.IdleTimeout - idle; next == 0 || wait < next {
+				next = wait
+			}
+			continue
+		}
+
+		if e, ok := keyRing.entities[fpr]; ok {
+			keyRing.lockEntity(e)
+		}
+		delete(keyRing.lastUsed, fpr)
+	}
+
+	if next > 0 {
+		keyRing.relock = time.AfterFunc(next, keyRing.relockIdle)
+	}
+}
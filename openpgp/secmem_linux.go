@@ -0,0 +1,27 @@
+package openpgp
+
+import (
+	"os"
+	"syscall"
+)
+
+// allocSecret returns a buffer of n bytes in its own anonymous mapping,
+// locked into memory so that it isn't written to swap. If the lock
+// fails, for example because RLIMIT_MEMLOCK is too low, the buffer is
+// still used.
+func allocSecret(n int) []byte {
+	pageSize := os.Getpagesize()
+	size := (n + pageSize - 1) / pageSize * pageSize
+	buf, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return make([]byte, n)
+	}
+	syscall.Mlock(buf)
+	return buf[:n]
+}
+
+// freeSecret unmaps a buffer from allocSecret, which also unlocks it.
+func freeSecret(buf []byte) {
+	syscall.Munmap(buf[:cap(buf)])
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package openpgp
+
+// allocSecret returns a buffer of n bytes. Secrets are only locked
+// into memory on Linux.
+func allocSecret(n int) []byte {
+	return make([]byte, n)
+}
+
+// freeSecret does nothing; the buffer was zeroed by wipe.
+func freeSecret(buf []byte) {}
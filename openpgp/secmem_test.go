@@ -0,0 +1,99 @@
+package openpgp
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLock validates that unlocked keys can be locked again, by hand
+// or once they have been idle, that the passphrase is forgotten, and that
+// they can then be unlocked with the same passphrase.
+func TestLock(t *testing.T) {
+	e, err := NewEntity(&KeyOptions{Name: "Lock Test", Email: "lock@example.net", Algorithm: AlgoECC})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	passphrase := []byte("correct horse battery staple")
+	keyRing := NewKeyRing("", true)
+	if err = keyRing.Add(e, passphrase); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if err = keyRing.Lock("lock@example.net"); err != nil {
+		t.Fatalf("%v", err)
+	} else if !e.PrivateKey.Encrypted {
+		t.Fatal("key wasn't locked")
+	} else if len(keyRing.passphrases) != 0 {
+		t.Fatal("passphrases weren't forgotten")
+	}
+
+	if _, err = keyRing.Sign([]byte("message"), "lock@example.net"); err == nil {
+		t.Fatal("signed with a locked key")
+	}
+
+	keyRing.IdleTimeout = 50 * time.Millisecond
+	keyRing.mu.Lock()
+	err = keyRing.decrypt(e.PrivateKey, passphrase)
+	keyRing.touch(e)
+	keyRing.mu.Unlock()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	keyRing.mu.RLock()
+	unlocked := !e.PrivateKey.Encrypted
+	keyRing.mu.RUnlock()
+	if !unlocked {
+		t.Fatal("key wasn't unlocked")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+	if !e.PrivateKey.Encrypted {
+		t.Fatal("idle key wasn't locked")
+	}
+}
+
+// TestUnlockPrompt validates that the keyring can be used while a
+// passphrase is being typed, and that the key is unlocked afterwards.
+func TestUnlockPrompt(t *testing.T) {
+	e, err := NewEntity(&KeyOptions{Name: "Prompt Test", Email: "prompt@example.net", Algorithm: AlgoECC})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	passphrase := []byte("correct horse battery staple")
+	keyRing := NewKeyRing("", true)
+	if err = keyRing.Add(e, passphrase); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = keyRing.Lock("prompt@example.net"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	defer func(read func(string) ([]byte, error)) { readPassphrase = read }(readPassphrase)
+	readPassphrase = func(string) ([]byte, error) {
+		done := make(chan bool)
+		go func() {
+			keyRing.Len()
+			keyRing.Entity("prompt@example.net")
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("the keyring was locked while prompting")
+		}
+		return append([]byte{}, passphrase...), nil
+	}
+
+	if _, err = keyRing.Sign([]byte("message"), "prompt@example.net"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+	if e.PrivateKey.Encrypted {
+		t.Fatal("key wasn't unlocked")
+	}
+}
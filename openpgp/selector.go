@@ -0,0 +1,174 @@
+package openpgp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// KeybaseResolver looks up the fingerprint of a keybase user's primary
+// key, and is used for keybase:<username> selectors. It must be set
+// by the program; the openpgp package doesn't talk to keybase itself.
+var KeybaseResolver func(username string) (fingerprint string, err error)
+
+// An AmbiguousKeyError is returned when a selector matches more than
+// one key.
+type AmbiguousKeyError struct {
+	Selector   string
+	Candidates []string // fingerprint and primary user ID of each match
+}
+
+func (err *AmbiguousKeyError) Error() string {
+	return fmt.Sprintf("openpgp: %q matches more than one key:\n\t%s",
+		err.Selector, strings.Join(err.Candidates, "\n\t"))
+}
+
+// primaryName returns the entity's primary user ID.
+func primaryName(e *openpgp.Entity) string {
+	if names := identityNames(e); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// Find returns the key named by selector, which may be
+//
+//   - a fingerprint, optionally with spaces, as GnuPG prints them;
+//   - a long (16 digit) or short (8 digit) key ID, optionally with
+//     a 0x prefix; subkey IDs select the key they belong to;
+//   - an email address, with or without angle brackets;
+//   - keybase:<username>, for the user's primary key (see
+//     KeybaseResolver); or
+//   - any part of a user ID, ignoring case.
+//
+// If more than one key matches, an *AmbiguousKeyError lists them.
+func (keyRing *KeyRing) Find(selector string) (e *openpgp.Entity, err error) {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+	return keyRing.find(selector)
+}
+
+func (keyRing *KeyRing) find(selector string) (e *openpgp.Entity, err error) {
+	sel := strings.TrimSpace(selector)
+	if sel == "" {
+		err = ErrKeyNotFound
+		return
+	}
+
+	if strings.HasPrefix(strings.ToLower(sel), "keybase:") {
+		if KeybaseResolver == nil {
+			err = ErrKeyNotFound
+			return
+		}
+
+		var fpr string
+		fpr, err = KeybaseResolver(sel[len("keybase:"):])
+		if err != nil {
+			return
+		}
+		sel = fpr
+	}
+
+	var matches []*openpgp.Entity
+	if id, ok := hexSelector(sel); ok {
+		matches = keyRing.matchKeyID(id)
+	} else if email, ok := emailSelector(sel); ok {
+		matches = keyRing.matchUserID(func(id *openpgp.Identity) bool {
+			return strings.EqualFold(id.UserId.Email, email)
+		})
+	}
+
+	if len(matches) == 0 {
+		lower := strings.ToLower(sel)
+		matches = keyRing.matchUserID(func(id *openpgp.Identity) bool {
+			return strings.Contains(strings.ToLower(id.Name), lower)
+		})
+	}
+
+	switch len(matches) {
+	case 0:
+		err = ErrKeyNotFound
+	case 1:
+		e = matches[0]
+	default:
+		ambiguous := &AmbiguousKeyError{Selector: selector}
+		for _, m := range matches {
+			ambiguous.Candidates = append(ambiguous.Candidates,
+				fmt.Sprintf("%s %s", fingerprint(m.PrimaryKey), primaryName(m)))
+		}
+		sort.Strings(ambiguous.Candidates)
+		err = ambiguous
+	}
+	return
+}
+
+// hexSelector returns the lowercase hex key ID or fingerprint in sel,
+// if it is one.
+func hexSelector(sel string) (id string, ok bool) {
+	id = strings.ToLower(strings.Replace(sel, " ", "", -1))
+	id = strings.TrimPrefix(id, "0x")
+	switch len(id) {
+	case 8, 16, 40:
+	default:
+		return "", false
+	}
+
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// emailSelector returns the address in sel if it looks like an email
+// address.
+func emailSelector(sel string) (email string, ok bool) {
+	email = strings.TrimSuffix(strings.TrimPrefix(sel, "<"), ">")
+	if strings.Count(email, "@") != 1 || strings.ContainsAny(email, " <>") {
+		return "", false
+	}
+	return email, true
+}
+
+// matchKeyID returns the entities with a primary key or subkey whose
+// fingerprint ends in id.
+func (keyRing *KeyRing) matchKeyID(id string) (matches []*openpgp.Entity) {
+	for _, e := range keyRing.sortedEntities() {
+		for _, pub := range entityKeys(e) {
+			if strings.HasSuffix(fingerprint(pub), id) {
+				matches = append(matches, e)
+				break
+			}
+		}
+	}
+	return
+}
+
+// matchUserID returns the entities with a user ID matching match.
+func (keyRing *KeyRing) matchUserID(match func(*openpgp.Identity) bool) (matches []*openpgp.Entity) {
+	for _, e := range keyRing.sortedEntities() {
+		for _, id := range e.Identities {
+			if match(id) {
+				matches = append(matches, e)
+				break
+			}
+		}
+	}
+	return
+}
+
+// sortedEntities returns the keyring's entities in fingerprint order.
+func (keyRing *KeyRing) sortedEntities() (el openpgp.EntityList) {
+	var fprs []string
+	for fpr := range keyRing.entities {
+		fprs = append(fprs, fpr)
+	}
+	sort.Strings(fprs)
+
+	for _, fpr := range fprs {
+		el = append(el, keyRing.entities[fpr])
+	}
+	return
+}
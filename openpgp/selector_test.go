@@ -0,0 +1,58 @@
+package openpgp
+
+import (
+	"testing"
+)
+
+// TestFind validates the key selectors accepted by Find.
+func TestFind(t *testing.T) {
+	keyRing, err := LoadKeyRing(testKeyboxPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	defer func() { KeybaseResolver = nil }()
+	KeybaseResolver = func(name string) (string, error) {
+		if name != "kyle" {
+			return "", ErrKeyNotFound
+		}
+		return "1F72F8B9CF8D215881E3C1D0AF7DB9C0CCAFF8EB", nil
+	}
+
+	for _, sel := range []string{
+		testNativeFpr,
+		"1F72 F8B9 CF8D 2158 81E3  C1D0 AF7D B9C0 CCAF F8EB",
+		"0xAF7DB9C0CCAFF8EB",
+		"ccaff8eb",
+		"0xB462EB1DBE187461", // the encryption subkey
+		"kyle@tyrfingr.is",
+		"<KYLE@tyrfingr.is>",
+		"do not use outside",
+		"keybase:kyle",
+	} {
+		e, err := keyRing.Find(sel)
+		if err != nil {
+			t.Fatalf("%s: %v", sel, err)
+		} else if fingerprint(e.PrimaryKey) != testNativeFpr {
+			t.Fatalf("%s selected the wrong key", sel)
+		}
+	}
+
+	if _, err = keyRing.Export("0xccaff8eb"); err != nil {
+		t.Fatalf("%v", err)
+	} else if keyRing.Entity("Clear ECDSA") == nil {
+		t.Fatal("Entity doesn't accept selectors")
+	}
+
+	if _, err = keyRing.Find("example.com"); err == nil {
+		t.Fatal("ambiguous selector matched a key")
+	} else if ambiguous, ok := err.(*AmbiguousKeyError); !ok || len(ambiguous.Candidates) != 2 {
+		t.Fatalf("expected an ambiguous key error, have %v", err)
+	}
+
+	for _, sel := range []string{"nobody@example.com", "0x00000000", "keybase:nobody", ""} {
+		if _, err = keyRing.Find(sel); err == nil {
+			t.Fatalf("%q matched a key", sel)
+		}
+	}
+}
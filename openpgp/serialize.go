@@ -0,0 +1,385 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
+)
+
+// fingerprint returns the lowercase hex fingerprint of a key, as used
+// to index the keyring.
+func fingerprint(pk *packet.PublicKey) string {
+	return fmt.Sprintf("%x", pk.Fingerprint)
+}
+
+// splitPackets splits raw OpenPGP data into its packets.
+func splitPackets(data []byte) (pkts [][]byte, err error) {
+	for len(data) > 0 {
+		if len(data) < 2 || data[0]&0x80 == 0 {
+			err = errors.StructuralError("invalid packet header")
+			return
+		}
+
+		var hdrLen, bodyLen int
+		if data[0]&0x40 == 0 {
+			switch data[0] & 3 {
+			case 0:
+				hdrLen, bodyLen = 2, int(data[1])
+			case 1:
+				if len(data) < 3 {
+					return nil, errors.StructuralError("truncated packet header")
+				}
+				hdrLen, bodyLen = 3, int(data[1])<<8|int(data[2])
+			case 2:
+				if len(data) < 5 {
+					return nil, errors.StructuralError("truncated packet header")
+				}
+				hdrLen = 5
+				bodyLen = int(data[1])<<24 | int(data[2])<<16 | int(data[3])<<8 | int(data[4])
+			default:
+				hdrLen, bodyLen = 1, len(data)-1
+			}
+		} else {
+			switch {
+			case data[1] < 192:
+				hdrLen, bodyLen = 2, int(data[1])
+			case data[1] < 224:
+				if len(data) < 3 {
+					return nil, errors.StructuralError("truncated packet header")
+				}
+				hdrLen, bodyLen = 3, (int(data[1])-192)<<8+int(data[2])+192
+			case data[1] == 255:
+				if len(data) < 6 {
+					return nil, errors.StructuralError("truncated packet header")
+				}
+				hdrLen = 6
+				bodyLen = int(data[2])<<24 | int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+			default:
+				return nil, errors.UnsupportedError("partial length packet")
+			}
+		}
+
+		if bodyLen < 0 || hdrLen+bodyLen > len(data) {
+			return nil, errors.StructuralError("truncated packet")
+		}
+		pkts = append(pkts, data[:hdrLen+bodyLen])
+		data = data[hdrLen+bodyLen:]
+	}
+	return
+}
+
+// packetTag returns the tag of a serialised packet.
+func packetTag(pkt []byte) byte {
+	if pkt[0]&0x40 == 0 {
+		return (pkt[0] >> 2) & 0xf
+	}
+	return pkt[0] & 0x3f
+}
+
+// sealedKeys returns the encrypted secret key packets in data, indexed
+// by fingerprint. Keeping these lets a keyring be written back out
+// without having to decrypt every key in it.
+func sealedKeys(data []byte) (sealed map[string][]byte) {
+	sealed = map[string][]byte{}
+	pkts, _ := splitPackets(data)
+	for _, pkt := range pkts {
+		if tag := packetTag(pkt); tag != tagSecretKey && tag != tagSecretSubkey {
+			continue
+		}
+
+		p, err := packet.Read(bytes.NewReader(pkt))
+		if err != nil {
+			continue
+		}
+		if priv, ok := p.(*packet.PrivateKey); ok && priv.Encrypted {
+			sealed[fingerprint(&priv.PublicKey)] = pkt
+		}
+	}
+	return
+}
+
+// encryptPrivateKey serialises priv as a secret key packet protected
+// with passphrase, using an iterated and salted S2K, the configured
+// cipher (AES-256 by default) and a SHA-1 checksum.
+func encryptPrivateKey(priv *packet.PrivateKey, passphrase []byte, config *packet.Config) (pkt []byte, err error) {
+	if priv.Encrypted {
+		err = ErrKeyLocked
+		return
+	}
+
+	pub, err := publicKeyBody(&priv.PublicKey)
+	if err != nil {
+		return
+	}
+
+	// Serialising the key in the clear is the simplest way to get
+	// at the secret MPIs; they sit between the public key (and the
+	// S2K usage byte) and the two byte checksum.
+	clear := new(bytes.Buffer)
+	err = priv.Serialize(clear)
+	if err != nil {
+		return
+	}
+	defer zero(clear.Bytes())
+	body, err := packetBody(clear.Bytes())
+	if err != nil {
+		return
+	}
+	secret := body[len(pub)+1 : len(body)-2]
+
+	cipherFunc := packet.CipherAES256
+	if config != nil && config.DefaultCipher != 0 {
+		cipherFunc = config.DefaultCipher
+	}
+	var keySize, blockSize int
+	switch cipherFunc {
+	case packet.CipherAES128:
+		keySize, blockSize = 16, 16
+	case packet.CipherAES192:
+		keySize, blockSize = 24, 16
+	case packet.CipherAES256:
+		keySize, blockSize = 32, 16
+	default:
+		err = errors.UnsupportedError("cipher for secret key protection")
+		return
+	}
+
+	buf := bytes.NewBuffer(pub)
+	buf.WriteByte(254)
+	buf.WriteByte(byte(cipherFunc))
+
+	key := make([]byte, keySize)
+	defer zero(key)
+	err = s2k.Serialize(buf, key, config.Random(), passphrase, &s2k.Config{Hash: config.Hash(), S2KCount: s2kCount(config)})
+	if err != nil {
+		return
+	}
+
+	iv := make([]byte, blockSize)
+	_, err = io.ReadFull(config.Random(), iv)
+	if err != nil {
+		return
+	}
+	buf.Write(iv)
+
+	h := sha1.New()
+	h.Write(secret)
+	plain := append(append([]byte{}, secret...), h.Sum(nil)...)
+	defer zero(plain)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	encrypted := make([]byte, len(plain))
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted, plain)
+	buf.Write(encrypted)
+
+	var tag byte = tagSecretKey
+	if priv.IsSubkey {
+		tag = tagSecretSubkey
+	}
+	out := new(bytes.Buffer)
+	err = serializePacket(out, tag, buf.Bytes())
+	pkt = out.Bytes()
+	return
+}
+
+func s2kCount(config *packet.Config) int {
+	if config == nil || config.S2KCount == 0 {
+		return 65011712
+	}
+	return config.S2KCount
+}
+
+// secretPacket returns the secret key packet to store for priv. Keys
+// are re-encrypted with the passphrase they were unlocked with, or the
+// one given to SetPassphrase. A key that was encrypted when it entered
+// the keyring, but whose passphrase isn't known, is written out as it
+// was read. Only keys that were never protected are written in the
+// clear.
+func (keyRing *KeyRing) secretPacket(priv *packet.PrivateKey) (pkt []byte, err error) {
+	fpr := fingerprint(&priv.PublicKey)
+	if passphrase, ok := keyRing.passphrases[fpr]; ok && !priv.Encrypted {
+		return encryptPrivateKey(priv, passphrase, DefaultConfig)
+	}
+
+	if sealed, ok := keyRing.sealed[fpr]; ok {
+		pkt = sealed
+		return
+	} else if priv.Encrypted {
+		err = ErrKeyLocked
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = priv.Serialize(buf)
+	pkt = buf.Bytes()
+	return
+}
+
+// identityNames returns the entity's identities with the primary one
+// first and the rest in order.
+func identityNames(e *openpgp.Entity) (names []string) {
+	for name := range e.Identities {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := isPrimaryID(e.Identities[names[i]]), isPrimaryID(e.Identities[names[j]])
+		if pi != pj {
+			return pi
+		}
+		return names[i] < names[j]
+	})
+	return
+}
+
+func isPrimaryID(id *openpgp.Identity) bool {
+	return id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId
+}
+
+// serializeEntity writes e to w. If private is true, secret key
+// material is included; see secretPacket. Unlike Entity.Serialize,
+// key revocations are written out too.
+func (keyRing *KeyRing) serializeEntity(w io.Writer, e *openpgp.Entity, private bool) (err error) {
+	writeKey := func(pub *packet.PublicKey, priv *packet.PrivateKey) error {
+		if stub, ok := keyRing.stubs[fingerprint(pub)]; private && priv == nil && ok {
+			_, err := w.Write(stub)
+			return err
+		} else if !private || priv == nil {
+			return pub.Serialize(w)
+		}
+		pkt, err := keyRing.secretPacket(priv)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(pkt)
+		return err
+	}
+
+	err = writeKey(e.PrimaryKey, e.PrivateKey)
+	if err != nil {
+		return
+	}
+
+	for _, sig := range e.Revocations {
+		err = sig.Serialize(w)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, name := range identityNames(e) {
+		id := e.Identities[name]
+		err = id.UserId.Serialize(w)
+		if err != nil {
+			return
+		}
+		err = id.SelfSignature.Serialize(w)
+		if err != nil {
+			return
+		}
+		for _, sig := range id.Signatures {
+			err = sig.Serialize(w)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	for _, subkey := range e.Subkeys {
+		err = writeKey(subkey.PublicKey, subkey.PrivateKey)
+		if err != nil {
+			return
+		}
+		err = subkey.Sig.Serialize(w)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// A fileStamp records the contents of a keyring file, to tell whether
+// another program has changed it.
+type fileStamp struct {
+	path   string
+	exists bool
+	sum    [sha256.Size]byte
+}
+
+// stampData returns the stamp of the named file, which holds data.
+func stampData(path string, data []byte) fileStamp {
+	return fileStamp{path: path, exists: true, sum: sha256.Sum256(data)}
+}
+
+// stampFile returns the stamp of the named file, or directory of .asc
+// files, as it is now.
+func stampFile(path string) (stamp fileStamp, err error) {
+	if fi, serr := os.Stat(path); serr == nil && fi.IsDir() {
+		return stampDir(path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fileStamp{path: path}, nil
+	} else if err != nil {
+		return
+	}
+	return stampData(path, data), nil
+}
+
+// writeFileAtomic writes a file by way of a temporary file in the same
+// directory, which is synced and then renamed over the original. An
+// existing file's permissions are kept; otherwise the file is created
+// with perm.
+func writeFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), ".openpgp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	err = tempFile.Chmod(perm)
+	if err != nil {
+		return
+	}
+
+	err = write(tempFile)
+	if err != nil {
+		return
+	}
+
+	err = tempFile.Sync()
+	if err != nil {
+		return
+	}
+
+	err = tempFile.Close()
+	if err != nil {
+		return
+	}
+	return os.Rename(tempFile.Name(), path)
+}
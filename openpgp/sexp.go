@@ -0,0 +1,366 @@
+package openpgp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrSexp is returned when an S-expression can't be parsed.
+var ErrSexp = errors.New("openpgp: malformed S-expression")
+
+// A sexp is a node in an S-expression as used by gpg-agent: either
+// an atom (a byte string) or a list of nodes.
+type sexp struct {
+	atom   []byte
+	list   []*sexp
+	isList bool
+}
+
+// String returns the atom as a string; lists yield the empty string.
+func (s *sexp) String() string {
+	if s == nil || s.isList {
+		return ""
+	}
+	return string(s.atom)
+}
+
+// Name returns the first atom of a list, which by convention names it.
+func (s *sexp) Name() string {
+	if s == nil || !s.isList || len(s.list) == 0 {
+		return ""
+	}
+	return s.list[0].String()
+}
+
+// Find returns the first sublist named name.
+func (s *sexp) Find(name string) *sexp {
+	if s == nil || !s.isList {
+		return nil
+	}
+	for _, elt := range s.list {
+		if elt.Name() == name {
+			return elt
+		}
+	}
+	return nil
+}
+
+// Nth returns the nth element of a list, or nil.
+func (s *sexp) Nth(n int) *sexp {
+	if s == nil || !s.isList || n >= len(s.list) {
+		return nil
+	}
+	return s.list[n]
+}
+
+// Value returns the atom following the name of the sublist named
+// name, i.e. the "v" in "(name v)".
+func (s *sexp) Value(name string) []byte {
+	v := s.Find(name).Nth(1)
+	if v == nil || v.isList {
+		return nil
+	}
+	return v.atom
+}
+
+// Canonical returns the canonical encoding of the expression.
+func (s *sexp) Canonical() []byte {
+	buf := new(bytes.Buffer)
+	s.writeCanonical(buf)
+	return buf.Bytes()
+}
+
+func (s *sexp) writeCanonical(buf *bytes.Buffer) {
+	if !s.isList {
+		fmt.Fprintf(buf, "%d:", len(s.atom))
+		buf.Write(s.atom)
+		return
+	}
+	buf.WriteByte('(')
+	for _, elt := range s.list {
+		elt.writeCanonical(buf)
+	}
+	buf.WriteByte(')')
+}
+
+func sexpAtom(atom []byte) *sexp {
+	return &sexp{atom: atom}
+}
+
+func sexpList(elts ...*sexp) *sexp {
+	return &sexp{list: elts, isList: true}
+}
+
+// parseSexp reads the first complete S-expression in data, which may
+// use either the canonical or the advanced encoding (or a mixture of
+// the two, as libgcrypt allows). Any trailing data, such as cipher
+// padding, is ignored.
+func parseSexp(data []byte) (s *sexp, err error) {
+	p := &sexpParser{data: data}
+	p.skipSpace()
+	if p.peek() != '(' {
+		err = ErrSexp
+		return
+	}
+	return p.parse()
+}
+
+type sexpParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *sexpParser) peek() byte {
+	if p.pos >= len(p.data) {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+func isSexpSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return bytes.IndexByte([]byte("-./_:*+="), c) != -1
+}
+
+func (p *sexpParser) skipSpace() {
+	for p.pos < len(p.data) && isSexpSpace(p.data[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *sexpParser) parse() (s *sexp, err error) {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case c == '(':
+		p.pos++
+		s = &sexp{isList: true}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return nil, ErrSexp
+			} else if p.peek() == ')' {
+				p.pos++
+				return s, nil
+			}
+
+			var elt *sexp
+			elt, err = p.parse()
+			if err != nil {
+				return nil, err
+			}
+			s.list = append(s.list, elt)
+		}
+	case c == '[':
+		// Display hints carry no meaning for our purposes.
+		end := bytes.IndexByte(p.data[p.pos:], ']')
+		if end == -1 {
+			return nil, ErrSexp
+		}
+		p.pos += end + 1
+		return p.parse()
+	case c >= '0' && c <= '9':
+		return p.parseVerbatim()
+	case c == '#':
+		return p.parseHex()
+	case c == '"':
+		return p.parseQuoted()
+	case c == '|':
+		return p.parseBase64()
+	case isTokenChar(c):
+		start := p.pos
+		for p.pos < len(p.data) && isTokenChar(p.data[p.pos]) {
+			p.pos++
+		}
+		return sexpAtom(p.data[start:p.pos]), nil
+	}
+	return nil, ErrSexp
+}
+
+// parseVerbatim handles a length-prefixed atom such as "3:rsa". A
+// length may also precede a token, hex, quoted or base64 string, in
+// which case it is only a hint.
+func (p *sexpParser) parseVerbatim() (s *sexp, err error) {
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(string(p.data[start:p.pos]))
+	if err != nil {
+		return nil, ErrSexp
+	}
+
+	switch p.peek() {
+	case ':':
+		p.pos++
+		if n < 0 || p.pos+n > len(p.data) {
+			return nil, ErrSexp
+		}
+		s = sexpAtom(p.data[p.pos : p.pos+n])
+		p.pos += n
+		return s, nil
+	case '#', '"', '|':
+		return p.parse()
+	}
+
+	// A bare number is a token.
+	return sexpAtom(p.data[start:p.pos]), nil
+}
+
+func (p *sexpParser) parseHex() (s *sexp, err error) {
+	p.pos++
+	digits := make([]byte, 0, 64)
+	for {
+		if p.pos >= len(p.data) {
+			return nil, ErrSexp
+		}
+		c := p.data[p.pos]
+		p.pos++
+		if c == '#' {
+			break
+		} else if isSexpSpace(c) {
+			continue
+		}
+		digits = append(digits, c)
+	}
+
+	atom := make([]byte, hex.DecodedLen(len(digits)))
+	_, err = hex.Decode(atom, digits)
+	if err != nil {
+		return nil, ErrSexp
+	}
+	return sexpAtom(atom), nil
+}
+
+func (p *sexpParser) parseBase64() (s *sexp, err error) {
+	p.pos++
+	end := bytes.IndexByte(p.data[p.pos:], '|')
+	if end == -1 {
+		return nil, ErrSexp
+	}
+	enc := bytes.Map(func(r rune) rune {
+		if r < 0x80 && isSexpSpace(byte(r)) {
+			return -1
+		}
+		return r
+	}, p.data[p.pos:p.pos+end])
+	p.pos += end + 1
+
+	atom, err := base64.StdEncoding.DecodeString(string(enc))
+	if err != nil {
+		return nil, ErrSexp
+	}
+	return sexpAtom(atom), nil
+}
+
+func (p *sexpParser) parseQuoted() (s *sexp, err error) {
+	p.pos++
+	var atom []byte
+	for {
+		if p.pos >= len(p.data) {
+			return nil, ErrSexp
+		}
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return sexpAtom(atom), nil
+		case '\\':
+			if p.pos >= len(p.data) {
+				return nil, ErrSexp
+			}
+			c = p.data[p.pos]
+			p.pos++
+			switch c {
+			case 'b':
+				atom = append(atom, '\b')
+			case 't':
+				atom = append(atom, '\t')
+			case 'v':
+				atom = append(atom, '\v')
+			case 'n':
+				atom = append(atom, '\n')
+			case 'f':
+				atom = append(atom, '\f')
+			case 'r':
+				atom = append(atom, '\r')
+			case '\n', '\r':
+				// Line continuation.
+			case 'x':
+				if p.pos+2 > len(p.data) {
+					return nil, ErrSexp
+				}
+				v, err := strconv.ParseUint(string(p.data[p.pos:p.pos+2]), 16, 8)
+				if err != nil {
+					return nil, ErrSexp
+				}
+				atom = append(atom, byte(v))
+				p.pos += 2
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				if p.pos+2 > len(p.data) {
+					return nil, ErrSexp
+				}
+				v, err := strconv.ParseUint(string(p.data[p.pos-1:p.pos+2]), 8, 8)
+				if err != nil {
+					return nil, ErrSexp
+				}
+				atom = append(atom, byte(v))
+				p.pos += 2
+			default:
+				atom = append(atom, c)
+			}
+		default:
+			atom = append(atom, c)
+		}
+	}
+}
+
+// parseKeyFile extracts the key expression from a file in
+// private-keys-v1.d. GnuPG 2.1 writes the bare canonical expression;
+// GnuPG 2.2 and later use the extended key format, a list of
+// "Name: value" pairs in which the expression is stored under "Key".
+func parseKeyFile(data []byte) (s *sexp, err error) {
+	if len(data) > 0 && data[0] == '(' {
+		return parseSexp(data)
+	}
+
+	var key []byte
+	var inKey bool
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 4096), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			if inKey {
+				key = append(key, '\n')
+				key = append(key, line[1:]...)
+			}
+			continue
+		}
+
+		inKey = false
+		if bytes.HasPrefix(line, []byte("Key:")) {
+			inKey = true
+			key = append(key, bytes.TrimSpace(line[4:])...)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	} else if len(key) == 0 {
+		err = ErrSexp
+		return
+	}
+
+	return parseSexp(key)
+}
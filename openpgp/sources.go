@@ -0,0 +1,401 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// ErrReadOnly is returned when changing a key that came from a
+// read-only keyring, or storing a keyring that was read from an
+// io.Reader.
+var ErrReadOnly = errors.New("openpgp: keyring is read-only")
+
+// armourStart begins every armoured block.
+var armourStart = []byte("-----BEGIN PGP ")
+
+// isArmoured returns true if data holds ASCII-armoured keys.
+func isArmoured(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), armourStart)
+}
+
+// dearmour returns the packets in each armoured key block in data,
+// one after the other. Other blocks, such as signatures, are skipped.
+func dearmour(data []byte) (packets []byte, err error) {
+	for {
+		i := bytes.Index(data, armourStart)
+		if i < 0 {
+			return
+		}
+
+		var block *armor.Block
+		block, err = armor.Decode(bytes.NewReader(data[i:]))
+		if err != nil {
+			return
+		}
+		data = data[i+len(armourStart):]
+		if block.Type != openpgp.PublicKeyType && block.Type != openpgp.PrivateKeyType {
+			continue
+		}
+
+		var body []byte
+		body, err = ioutil.ReadAll(block.Body)
+		if err != nil {
+			return
+		}
+		packets = append(packets, body...)
+	}
+}
+
+// readPackets reads the keys in data, which may be armoured, into the
+// keyring, returning the entities.
+func (keyRing *KeyRing) readPackets(data []byte) (el openpgp.EntityList, err error) {
+	if isArmoured(data) {
+		data, err = dearmour(data)
+		if err != nil {
+			return
+		}
+	}
+
+	data, stubs := stripStubs(data)
+	el, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	for fpr, pkt := range sealedKeys(data) {
+		keyRing.sealed[fpr] = pkt
+	}
+	for fpr, stub := range stubs {
+		keyRing.stubs[fpr] = stub
+	}
+	return
+}
+
+// ReadKeyRing reads a keyring, armoured or not, from r. The keyring
+// can't be stored; use Export or ExportSecret to save its keys.
+func ReadKeyRing(r io.Reader) (keyRing *KeyRing, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	keyRing = newKeyRing("")
+	el, err := keyRing.readPackets(data)
+	if err != nil {
+		keyRing = nil
+		return
+	}
+	keyRing.addEntities(el)
+	return
+}
+
+// addEntities adds entities read from disk to the keyring.
+func (keyRing *KeyRing) addEntities(el openpgp.EntityList) {
+	for _, e := range el {
+		if hasSecretKey(e) {
+			keyRing.private = true
+		}
+		keyRing.entities[fingerprint(e.PrimaryKey)] = e
+	}
+}
+
+// isAgentDir returns true if dir is a gpg-agent private-keys-v1.d
+// directory, rather than a directory of .asc files.
+func isAgentDir(dir string) bool {
+	if filepath.Base(dir) == "private-keys-v1.d" {
+		return true
+	}
+	keys, _ := filepath.Glob(filepath.Join(dir, "*.key"))
+	return len(keys) > 0
+}
+
+// ascFiles returns the names of the .asc files in dir, sorted.
+func ascFiles(dir string) (names []string, err error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.asc"))
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		names = append(names, filepath.Base(path))
+	}
+	sort.Strings(names)
+	return
+}
+
+// stampDir returns the stamp of a directory of .asc files, which
+// covers their names and contents.
+func stampDir(dir string) (stamp fileStamp, err error) {
+	names, err := ascFiles(dir)
+	if err != nil {
+		return
+	}
+
+	h := sha256.New()
+	for _, name := range names {
+		var data []byte
+		data, err = ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(h, "%s\n%d\n", name, len(data))
+		h.Write(data)
+	}
+
+	stamp = fileStamp{path: dir, exists: true}
+	copy(stamp.sum[:], h.Sum(nil))
+	return
+}
+
+// readAscDir reads the keys in each .asc file in dir, remembering the
+// file each came from so that it is written back there. A key found in
+// more than one file is merged into the first.
+func (keyRing *KeyRing) readAscDir(dir string) (el openpgp.EntityList, err error) {
+	err = keyRing.lock.lock(LockTimeout)
+	if err != nil {
+		return
+	}
+	defer keyRing.lock.release()
+
+	keyRing.format = formatAscDir
+	keyRing.stamp, err = stampDir(dir)
+	if err != nil {
+		return
+	}
+
+	names, err := ascFiles(dir)
+	if err != nil {
+		return
+	}
+
+	seen := map[string]*openpgp.Entity{}
+	for _, name := range names {
+		var data []byte
+		data, err = ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return
+		}
+
+		var keys openpgp.EntityList
+		keys, err = keyRing.readPackets(data)
+		if err != nil {
+			err = fmt.Errorf("%s: %v", name, err)
+			return
+		}
+
+		for _, e := range keys {
+			fpr := fingerprint(e.PrimaryKey)
+			if first, ok := seen[fpr]; ok {
+				mergeEntity(first, e)
+				continue
+			}
+			seen[fpr] = e
+			keyRing.files[fpr] = name
+			el = append(el, e)
+		}
+	}
+	return
+}
+
+// blockType returns the armour type the keyring's keys are written as.
+func (keyRing *KeyRing) blockType() string {
+	if keyRing.private {
+		return openpgp.PrivateKeyType
+	}
+	return openpgp.PublicKeyType
+}
+
+// writeArmoured writes el to w as a single armoured key block.
+func (keyRing *KeyRing) writeArmoured(w io.Writer, el openpgp.EntityList) (err error) {
+	blockHeaders := map[string]string{
+		"Version": fmt.Sprintf("Keybase Go client (OpenPGP version %s)", Version),
+	}
+	armourBuffer, err := armor.Encode(w, keyRing.blockType(), blockHeaders)
+	if err != nil {
+		return
+	}
+
+	for _, e := range el {
+		err = keyRing.serializeEntity(armourBuffer, e, keyRing.private)
+		if err != nil {
+			return
+		}
+	}
+
+	err = armourBuffer.Close()
+	if err != nil {
+		return
+	}
+	_, err = io.WriteString(w, "\n")
+	return
+}
+
+// storeAscDir writes each key back to the .asc file it was read from;
+// new keys get a file named after their fingerprint. Files left with
+// no keys are removed.
+func (keyRing *KeyRing) storeAscDir(perm os.FileMode) (err error) {
+	err = os.MkdirAll(keyRing.path, 0700)
+	if err != nil {
+		return
+	}
+
+	files := map[string]openpgp.EntityList{}
+	for _, e := range keyRing.ownEntities() {
+		fpr := fingerprint(e.PrimaryKey)
+		name, ok := keyRing.files[fpr]
+		if !ok {
+			name = strings.ToUpper(fpr) + ".asc"
+			keyRing.files[fpr] = name
+		}
+		files[name] = append(files[name], e)
+	}
+
+	for name, el := range files {
+		err = writeFileAtomic(filepath.Join(keyRing.path, name), perm, func(w io.Writer) error {
+			return keyRing.writeArmoured(w, el)
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	names, err := ascFiles(keyRing.path)
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		if _, ok := files[name]; !ok {
+			err = os.Remove(filepath.Join(keyRing.path, name))
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// ownEntities returns the keys that belong to the keyring itself,
+// leaving out those from read-only keyrings overlaid on it, in
+// fingerprint order.
+func (keyRing *KeyRing) ownEntities() (el openpgp.EntityList) {
+	for _, e := range keyRing.sortedEntities() {
+		if _, ok := keyRing.origins[fingerprint(e.PrimaryKey)]; !ok {
+			el = append(el, e)
+		}
+	}
+	return
+}
+
+// Overlay adds the keys in each of layers to the keyring as read-only
+// keys: they can be used, but Store doesn't write them and Delete
+// refuses to remove them. Keys already in the keyring, or in an
+// earlier layer, take precedence. Importing changes to a read-only key
+// copies it into the keyring.
+func (keyRing *KeyRing) Overlay(layers ...*KeyRing) (err error) {
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+
+	for _, layer := range layers {
+		layer.mu.RLock()
+		if layer.private != keyRing.private && len(layer.entities) > 0 {
+			layer.mu.RUnlock()
+			if keyRing.private {
+				return ErrSecRing
+			}
+			return ErrPubRing
+		}
+
+		for fpr, e := range layer.entities {
+			if _, ok := keyRing.entities[fpr]; ok {
+				continue
+			}
+			keyRing.entities[fpr] = e
+			keyRing.origins[fpr] = layer.origin(fpr)
+
+			for _, pub := range entityKeys(e) {
+				kfpr := fingerprint(pub)
+				if pkt, ok := layer.sealed[kfpr]; ok {
+					keyRing.sealed[kfpr] = pkt
+				}
+				if stub, ok := layer.stubs[kfpr]; ok {
+					keyRing.stubs[kfpr] = stub
+				}
+			}
+		}
+		for priv, ak := range layer.agentKeys {
+			keyRing.agentKeys[priv] = ak
+		}
+		layer.mu.RUnlock()
+	}
+	return
+}
+
+// LoadOverlay loads the writable keyring at path, creating an empty
+// one if there isn't one, and overlays the read-only keyrings named by
+// readOnly (see Overlay). Read-only keyrings that don't exist are
+// skipped.
+func LoadOverlay(path string, private bool, readOnly ...string) (keyRing *KeyRing, err error) {
+	keyRing, err = LoadKeyRing(path)
+	if os.IsNotExist(err) {
+		keyRing, err = NewKeyRing(path, private), nil
+	}
+	if err != nil {
+		return
+	}
+
+	for _, roPath := range readOnly {
+		var layer *KeyRing
+		layer, err = LoadKeyRing(roPath)
+		if os.IsNotExist(err) {
+			err = nil
+			continue
+		} else if err != nil {
+			keyRing = nil
+			return
+		}
+
+		err = keyRing.Overlay(layer)
+		if err != nil {
+			keyRing = nil
+			return
+		}
+	}
+	return
+}
+
+// origin returns the file the key with the given fingerprint was read
+// from.
+func (keyRing *KeyRing) origin(fpr string) string {
+	if path, ok := keyRing.origins[fpr]; ok {
+		return path
+	} else if name, ok := keyRing.files[fpr]; ok {
+		return filepath.Join(keyRing.path, name)
+	}
+	return keyRing.path
+}
+
+// Origin returns the keyring file the named key came from, and whether
+// it is read-only. Keys read from an io.Reader have no origin.
+func (keyRing *KeyRing) Origin(keyID string) (path string, readOnly bool, err error) {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	e, err := keyRing.find(keyID)
+	if err != nil {
+		return
+	}
+
+	fpr := fingerprint(e.PrimaryKey)
+	_, readOnly = keyRing.origins[fpr]
+	return keyRing.origin(fpr), readOnly || keyRing.path == "", nil
+}
@@ -0,0 +1,179 @@
+package openpgp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestArmouredKeyRing validates that keyrings can be read from an
+// io.Reader and from armoured files, and that armoured keyrings are
+// stored armoured.
+func TestArmouredKeyRing(t *testing.T) {
+	keyRing, err := ReadKeyRing(strings.NewReader(testPubArmoured))
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if keyRing.Len() != 1 || keyRing.Private() {
+		t.Fatal("armoured key wasn't read")
+	} else if err = keyRing.Store(); err != ErrReadOnly {
+		t.Fatal("a keyring read from an io.Reader shouldn't be stored")
+	}
+
+	dir, err := ioutil.TempDir("", "keybase")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pubring.asc")
+	err = ioutil.WriteFile(path, []byte(testPubArmoured), 0644)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	keyRing, err = LoadKeyRing(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if keyRing.format != formatArmoured || keyRing.Len() != 1 {
+		t.Fatal("armoured keyring wasn't loaded")
+	}
+
+	pubRing, err := LoadKeyRing(testPubRingPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	armoured, err := pubRing.Export("")
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if _, err = keyRing.Import(armoured); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = keyRing.Store(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !isArmoured(data) {
+		t.Fatal("armoured keyring was stored unarmoured")
+	}
+	stored, err := LoadKeyRing(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if stored.Len() != keyRing.Len() {
+		t.Fatalf("stored %d keys, have %d", stored.Len(), keyRing.Len())
+	}
+}
+
+// TestAscDir validates that a directory of .asc files is loaded, and
+// that keys are written back to the files they came from.
+func TestAscDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keybase")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyDir := filepath.Join(dir, "keys")
+	if err = os.Mkdir(keyDir, 0700); err != nil {
+		t.Fatalf("%v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(keyDir, "kyle.asc"), []byte(testPubArmoured), 0644)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	keyRing, err := LoadKeyRing(keyDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if keyRing.format != formatAscDir || keyRing.Len() != 1 {
+		t.Fatal("directory of .asc files wasn't loaded")
+	}
+
+	e, err := NewEntity(&KeyOptions{Name: "New Key", Email: "new@example.net", Algorithm: AlgoECC})
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if err = keyRing.Add(e, nil); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = keyRing.Store(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	names, err := ascFiles(keyDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	newFile := strings.ToUpper(fingerprint(e.PrimaryKey)) + ".asc"
+	if len(names) != 2 || names[0] != newFile || names[1] != "kyle.asc" {
+		t.Fatalf("wrong key files %v", names)
+	}
+
+	path, readOnly, err := keyRing.Origin("new@example.net")
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if readOnly || path != filepath.Join(keyDir, newFile) {
+		t.Fatalf("wrong origin %s", path)
+	}
+
+	if _, err = keyRing.Delete("new@example.net"); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = keyRing.Store(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	names, err = ascFiles(keyDir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(names) != 1 {
+		t.Fatalf("deleted key's file wasn't removed: %v", names)
+	}
+}
+
+// TestOverlay validates that keys from a read-only keyring can be used
+// but aren't stored or deleted with the writable keyring.
+func TestOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keybase")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pubring.gpg")
+	keyRing, err := LoadOverlay(path, false, testPubRingPath, filepath.Join(dir, "missing.gpg"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	system, err := LoadKeyRing(testPubRingPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if keyRing.Len() != system.Len() {
+		t.Fatal("read-only keys weren't overlaid")
+	}
+
+	fpr := fingerprint(system.Entities()[0].PrimaryKey)
+	origin, readOnly, err := keyRing.Origin(fpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !readOnly || origin != testPubRingPath {
+		t.Fatalf("wrong origin %s", origin)
+	} else if _, err = keyRing.Delete(fpr); err != ErrReadOnly {
+		t.Fatal("a read-only key was deleted")
+	}
+
+	e, err := NewEntity(&KeyOptions{Name: "User Key", Email: "user@example.net", Algorithm: AlgoECC})
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if err = keyRing.Add(e, nil); err != nil {
+		t.Fatalf("%v", err)
+	} else if err = keyRing.Store(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	stored, err := LoadKeyRing(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if stored.Len() != 1 || stored.Entity("user@example.net") == nil {
+		t.Fatal("only the writable keyring's keys should be stored")
+	}
+}
@@ -0,0 +1,520 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+)
+
+// ErrNoAuthKey is returned when a key has no usable authentication
+// key to convert to an SSH key.
+var ErrNoAuthKey = errors.New("openpgp: no usable authentication key")
+
+// The openpgp package doesn't read EdDSA keys or the authentication
+// key flag, so authentication keys are read straight from the packets,
+// and their binding signatures checked here.
+const (
+	pubKeyAlgoEdDSA = 22
+
+	sigTypeGenericCert      = 0x10
+	sigTypePositiveCert     = 0x13
+	sigTypeSubkeyBinding    = 0x18
+	sigTypeDirectKey        = 0x1f
+	sigTypeKeyRevocation    = 0x20
+	sigTypeSubkeyRevocation = 0x28
+
+	subpacketCreationTime  = 2
+	subpacketKeyExpiration = 9
+	subpacketKeyFlags      = 27
+
+	keyFlagAuthenticate = 0x20
+)
+
+// SSH key types, and the OID and point prefix OpenPGP uses for
+// Ed25519 keys.
+const (
+	sshRSA         = "ssh-rsa"
+	sshEd25519     = "ssh-ed25519"
+	sshECDSAPrefix = "ecdsa-sha2-"
+
+	oidEd25519         = "\x2b\x06\x01\x04\x01\xda\x47\x0f\x01"
+	ed25519PointPrefix = 0x40
+)
+
+// sshCurves maps the OIDs of the curves OpenSSH supports to their SSH
+// names.
+var sshCurves = map[string]struct {
+	name  string
+	curve elliptic.Curve
+}{
+	"\x2a\x86\x48\xce\x3d\x03\x01\x07": {"nistp256", elliptic.P256()},
+	"\x2b\x81\x04\x00\x22":             {"nistp384", elliptic.P384()},
+	"\x2b\x81\x04\x00\x23":             {"nistp521", elliptic.P521()},
+}
+
+// signatureHashes maps OpenPGP hash algorithm IDs to hashes.
+var signatureHashes = map[byte]crypto.Hash{
+	2: crypto.SHA1, 8: crypto.SHA256, 9: crypto.SHA384, 10: crypto.SHA512, 11: crypto.SHA224,
+}
+
+// An SSHKey is an OpenPGP authentication key in OpenSSH's format.
+type SSHKey struct {
+	Fingerprint string // of the authentication key
+	Type        string // the SSH key type, e.g. ssh-ed25519
+	Blob        []byte // the key in the SSH wire format
+
+	verifier crypto.PublicKey
+	algo     byte
+}
+
+// AuthorizedKey returns the key as a line for an authorized_keys file,
+// without the trailing newline.
+func (key *SSHKey) AuthorizedKey(comment string) string {
+	line := key.Type + " " + base64.StdEncoding.EncodeToString(key.Blob)
+	if comment != "" {
+		line += " " + comment
+	}
+	return line
+}
+
+// sshString appends a string in the SSH wire format.
+func sshString(buf []byte, s []byte) []byte {
+	n := len(s)
+	buf = append(buf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	return append(buf, s...)
+}
+
+// sshMPInt appends an mpint in the SSH wire format, which is signed:
+// a leading zero keeps the top bit clear.
+func sshMPInt(buf []byte, n []byte) []byte {
+	n = bytes.TrimLeft(n, "\x00")
+	if len(n) > 0 && n[0]&0x80 != 0 {
+		n = append([]byte{0}, n...)
+	}
+	return sshString(buf, n)
+}
+
+// readMPI reads an OpenPGP MPI from the start of data.
+func readMPI(data []byte) (n, rest []byte, err error) {
+	if len(data) < 2 {
+		err = pgperrors.StructuralError("short MPI")
+		return
+	}
+	l := (int(data[0])<<8 | int(data[1]) + 7) / 8
+	if len(data) < 2+l {
+		err = pgperrors.StructuralError("short MPI")
+		return
+	}
+	return data[2 : 2+l], data[2+l:], nil
+}
+
+// readOID reads a curve OID from the start of data.
+func readOID(data []byte) (oid string, rest []byte, err error) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		err = pgperrors.StructuralError("short curve OID")
+		return
+	}
+	return string(data[1 : 1+data[0]]), data[1+data[0]:], nil
+}
+
+// parseSSHKey converts the body of a version 4 public key packet to an
+// SSH key. Algorithms OpenSSH can't use give an UnsupportedError.
+func parseSSHKey(body []byte) (key *SSHKey, err error) {
+	if len(body) < 6 || body[0] != 4 {
+		err = pgperrors.UnsupportedError("public key version")
+		return
+	}
+	key = &SSHKey{algo: body[5]}
+	material := body[6:]
+
+	switch key.algo {
+	case 1, 3: // RSA, RSA sign-only
+		var n, e []byte
+		n, material, err = readMPI(material)
+		if err == nil {
+			e, _, err = readMPI(material)
+		}
+		if err != nil {
+			return
+		}
+		key.Type = sshRSA
+		key.Blob = sshMPInt(sshMPInt(sshString(nil, []byte(sshRSA)), e), n)
+		key.verifier = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case 19: // ECDSA
+		var oid string
+		var point []byte
+		oid, material, err = readOID(material)
+		if err == nil {
+			point, _, err = readMPI(material)
+		}
+		if err != nil {
+			return
+		}
+		curve, ok := sshCurves[oid]
+		if !ok {
+			err = pgperrors.UnsupportedError("curve")
+			return
+		}
+		x, y := elliptic.Unmarshal(curve.curve, point)
+		if x == nil {
+			err = pgperrors.StructuralError("invalid ECDSA point")
+			return
+		}
+		key.Type = sshECDSAPrefix + curve.name
+		key.Blob = sshString(sshString(sshString(nil, []byte(key.Type)), []byte(curve.name)), point)
+		key.verifier = &ecdsa.PublicKey{Curve: curve.curve, X: x, Y: y}
+	case pubKeyAlgoEdDSA:
+		var oid string
+		var point []byte
+		oid, material, err = readOID(material)
+		if err == nil {
+			point, _, err = readMPI(material)
+		}
+		if err != nil {
+			return
+		} else if oid != oidEd25519 {
+			err = pgperrors.UnsupportedError("curve")
+			return
+		} else if len(point) != 1+ed25519.PublicKeySize || point[0] != ed25519PointPrefix {
+			err = pgperrors.StructuralError("invalid Ed25519 point")
+			return
+		}
+		key.Type = sshEd25519
+		key.Blob = sshString(sshString(nil, []byte(sshEd25519)), point[1:])
+		key.verifier = ed25519.PublicKey(point[1:])
+	default:
+		err = pgperrors.UnsupportedError(fmt.Sprintf("public key algorithm %d", key.algo))
+		return
+	}
+
+	fpr := keyFingerprint(body)
+	key.Fingerprint = fmt.Sprintf("%x", fpr)
+	return
+}
+
+// keyFingerprint returns the version 4 fingerprint of a public key
+// packet body.
+func keyFingerprint(body []byte) []byte {
+	h := crypto.SHA1.New()
+	h.Write([]byte{0x99, byte(len(body) >> 8), byte(len(body))})
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// A rawSignature is a version 4 signature read from its packet.
+type rawSignature struct {
+	sigType byte
+	algo    byte
+	hash    byte
+	hashed  []byte // the signature packet up to the unhashed subpackets
+	left16  []byte
+	mpis    [][]byte
+
+	created time.Time
+	expiry  uint32 // key expiration, in seconds after key creation
+	flags   byte
+	flagsOK bool
+}
+
+// parseSignature reads a version 4 signature packet body; older
+// signatures give an UnsupportedError.
+func parseSignature(body []byte) (sig *rawSignature, err error) {
+	if len(body) < 6 || body[0] != 4 {
+		err = pgperrors.UnsupportedError("signature version")
+		return
+	}
+	sig = &rawSignature{sigType: body[1], algo: body[2], hash: body[3]}
+
+	hashedLen := int(body[4])<<8 | int(body[5])
+	if len(body) < 6+hashedLen+2 {
+		err = pgperrors.StructuralError("short signature")
+		return
+	}
+	sig.hashed = body[:6+hashedLen]
+	err = sig.readSubpackets(body[6 : 6+hashedLen])
+	if err != nil {
+		return
+	}
+
+	rest := body[6+hashedLen:]
+	unhashedLen := int(rest[0])<<8 | int(rest[1])
+	if len(rest) < 2+unhashedLen+2 {
+		err = pgperrors.StructuralError("short signature")
+		return
+	}
+	rest = rest[2+unhashedLen:]
+	sig.left16, rest = rest[:2], rest[2:]
+	for len(rest) > 0 {
+		var mpi []byte
+		mpi, rest, err = readMPI(rest)
+		if err != nil {
+			return
+		}
+		sig.mpis = append(sig.mpis, mpi)
+	}
+	return
+}
+
+// readSubpackets picks out the hashed subpackets the authentication
+// key checks need.
+func (sig *rawSignature) readSubpackets(data []byte) (err error) {
+	for len(data) > 0 {
+		var n int
+		switch {
+		case data[0] < 192:
+			n, data = int(data[0]), data[1:]
+		case data[0] < 255:
+			if len(data) < 2 {
+				return pgperrors.StructuralError("short subpacket")
+			}
+			n, data = (int(data[0])-192)<<8+int(data[1])+192, data[2:]
+		default:
+			if len(data) < 5 {
+				return pgperrors.StructuralError("short subpacket")
+			}
+			n, data = int(data[1])<<24|int(data[2])<<16|int(data[3])<<8|int(data[4]), data[5:]
+		}
+		if n < 1 || len(data) < n {
+			return pgperrors.StructuralError("short subpacket")
+		}
+
+		sub := data[1:n]
+		switch data[0] & 0x7f {
+		case subpacketCreationTime:
+			if len(sub) == 4 {
+				sig.created = time.Unix(int64(uint32(sub[0])<<24|uint32(sub[1])<<16|uint32(sub[2])<<8|uint32(sub[3])), 0)
+			}
+		case subpacketKeyExpiration:
+			if len(sub) == 4 {
+				sig.expiry = uint32(sub[0])<<24 | uint32(sub[1])<<16 | uint32(sub[2])<<8 | uint32(sub[3])
+			}
+		case subpacketKeyFlags:
+			if len(sub) > 0 {
+				sig.flags, sig.flagsOK = sub[0], true
+			}
+		}
+		data = data[n:]
+	}
+	return
+}
+
+// verify checks that signer made sig over the given key packet body
+// and, for certifications and bindings, the user ID or subkey.
+func (sig *rawSignature) verify(signer *SSHKey, primary, uid, subkey []byte) bool {
+	hash, ok := signatureHashes[sig.hash]
+	if !ok || sig.algo != signer.algo && !(sig.algo == 1 && signer.algo == 3) || !hash.Available() {
+		return false
+	}
+
+	h := hash.New()
+	h.Write([]byte{0x99, byte(len(primary) >> 8), byte(len(primary))})
+	h.Write(primary)
+	if uid != nil {
+		n := len(uid)
+		h.Write([]byte{0xb4, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+		h.Write(uid)
+	}
+	if subkey != nil {
+		h.Write([]byte{0x99, byte(len(subkey) >> 8), byte(len(subkey))})
+		h.Write(subkey)
+	}
+	n := len(sig.hashed)
+	h.Write(sig.hashed)
+	h.Write([]byte{4, 0xff, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	digest := h.Sum(nil)
+	if !bytes.Equal(digest[:2], sig.left16) {
+		return false
+	}
+
+	switch pub := signer.verifier.(type) {
+	case *rsa.PublicKey:
+		if len(sig.mpis) != 1 {
+			return false
+		}
+		s := leftPad(sig.mpis[0], (pub.N.BitLen()+7)/8)
+		return rsa.VerifyPKCS1v15(pub, hash, digest, s) == nil
+	case *ecdsa.PublicKey:
+		if len(sig.mpis) != 2 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig.mpis[0]), new(big.Int).SetBytes(sig.mpis[1])
+		return ecdsa.Verify(pub, digest, r, s)
+	case ed25519.PublicKey:
+		if len(sig.mpis) != 2 || len(sig.mpis[0]) > 32 || len(sig.mpis[1]) > 32 {
+			return false
+		}
+		rs := append(leftPad(sig.mpis[0], 32), leftPad(sig.mpis[1], 32)...)
+		return ed25519.Verify(pub, digest, rs)
+	}
+	return false
+}
+
+// leftPad pads b with leading zeros to n bytes.
+func leftPad(b []byte, n int) []byte {
+	if len(b) >= n {
+		return b
+	}
+	return append(make([]byte, n-len(b)), b...)
+}
+
+// keyCreated returns the creation time in a key packet body.
+func keyCreated(body []byte) time.Time {
+	return time.Unix(int64(uint32(body[1])<<24|uint32(body[2])<<16|uint32(body[3])<<8|uint32(body[4])), 0)
+}
+
+// authCandidate tracks the newest binding signature on a key and
+// whether it has been revoked.
+type authCandidate struct {
+	body    []byte
+	binding *rawSignature
+	revoked bool
+}
+
+func (c *authCandidate) bind(sig *rawSignature) {
+	if c.binding == nil || !sig.created.Before(c.binding.created) {
+		c.binding = sig
+	}
+}
+
+// usable returns true if the key's newest binding signature allows
+// authentication and the key hasn't been revoked or expired.
+func (c *authCandidate) usable(now time.Time) bool {
+	if c.revoked || c.binding == nil || !c.binding.flagsOK || c.binding.flags&keyFlagAuthenticate == 0 {
+		return false
+	}
+	if c.binding.expiry == 0 {
+		return true
+	}
+	expires := keyCreated(c.body).Add(time.Duration(c.binding.expiry) * time.Second)
+	return now.Before(expires)
+}
+
+// SSHKeys returns the authentication keys in the first armoured public
+// key in armoured, as SSH keys, along with its primary key's
+// fingerprint. Only keys whose binding to the primary key verifies
+// and which haven't been revoked or expired at now are returned; the
+// primary key is returned if its self-signature allows authentication.
+func SSHKeys(armoured string, now time.Time) (fpr string, keys []*SSHKey, err error) {
+	data, err := dearmour([]byte(armoured))
+	if err != nil {
+		return
+	}
+	pkts, err := splitPackets(data)
+	if err != nil {
+		return
+	} else if len(pkts) == 0 || packetTag(pkts[0]) != tagPublicKey {
+		err = ErrInvalidPublicKey
+		return
+	}
+
+	primaryBody, err := packetBody(pkts[0])
+	if err != nil {
+		return
+	}
+	primaryKey, err := parseSSHKey(primaryBody)
+	if err != nil {
+		return
+	}
+	fpr = primaryKey.Fingerprint
+	primary := &authCandidate{body: primaryBody}
+
+	var uid []byte
+	var subkeys []*authCandidate
+	var current *authCandidate
+	for _, pkt := range pkts[1:] {
+		var body []byte
+		body, err = packetBody(pkt)
+		if err != nil {
+			return
+		}
+
+		tag := packetTag(pkt)
+		if tag == tagPublicKey {
+			// Only the first key is read.
+			break
+		}
+		switch tag {
+		case tagUserID:
+			uid, current = body, nil
+			continue
+		case tagPublicSubkey:
+			current = &authCandidate{body: body}
+			subkeys = append(subkeys, current)
+			uid = nil
+			continue
+		case tagSignature:
+		default:
+			continue
+		}
+
+		sig, perr := parseSignature(body)
+		if perr != nil {
+			continue
+		}
+		switch {
+		case sig.sigType == sigTypeKeyRevocation:
+			if sig.verify(primaryKey, primaryBody, nil, nil) {
+				err = ErrKeyRevoked
+				return
+			}
+		case sig.sigType == sigTypeDirectKey && current == nil && uid == nil:
+			if sig.verify(primaryKey, primaryBody, nil, nil) {
+				primary.bind(sig)
+			}
+		case sig.sigType >= sigTypeGenericCert && sig.sigType <= sigTypePositiveCert && uid != nil:
+			if sig.verify(primaryKey, primaryBody, uid, nil) {
+				primary.bind(sig)
+			}
+		case sig.sigType == sigTypeSubkeyBinding && current != nil:
+			if sig.verify(primaryKey, primaryBody, nil, current.body) {
+				current.bind(sig)
+			}
+		case sig.sigType == sigTypeSubkeyRevocation && current != nil:
+			if sig.verify(primaryKey, primaryBody, nil, current.body) {
+				current.revoked = true
+			}
+		}
+	}
+
+	if primary.binding == nil {
+		err = ErrNoSelfSig
+		return
+	} else if primary.binding.expiry != 0 {
+		expires := keyCreated(primaryBody).Add(time.Duration(primary.binding.expiry) * time.Second)
+		if !now.Before(expires) {
+			err = ErrKeyExpired
+			return
+		}
+	}
+
+	if primary.usable(now) {
+		keys = append(keys, primaryKey)
+	}
+	for _, subkey := range subkeys {
+		if !subkey.usable(now) {
+			continue
+		}
+		key, perr := parseSSHKey(subkey.body)
+		if perr != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		err = ErrNoAuthKey
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package openpgp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// The test keys were made by GnuPG 2.2. The Ed25519 key has Ed25519,
+// RSA and NIST P-256 authentication subkeys, an encryption subkey, a
+// revoked authentication subkey, and one that expires a day after it
+// was created; the RSA key has a NIST P-384 authentication subkey.
+// The expected keys are as exported by gpg --export-ssh-key.
+const (
+	testSSHEd25519Path = "testdata/ssh-ed25519.asc"
+	testSSHRSAPath     = "testdata/ssh-rsa.asc"
+	testSSHCreated     = 1792340272
+)
+
+var testSSHKeys = []struct {
+	fpr, line string
+}{
+	{"d0fe7731cb1867d109ba0c3edb99c7ae2b2e0525", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAINopmxPqS5XF8du9miXJ42A83nz8XP0GNFJ0cG8GSxjJ"},
+	{"5e1515c290c6fbe8fb2a6bbbf731bbed6a6300c5", "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQDfvFI9U50txwLG3MDUNJA7jsMNHdP8PU594nNyuK9ETxiRTEokQB3+I8jA/5ORf8Yn/9ksPD2Fm3MCKqC4zQPjrcHz9DMYjawEaNPX7My1f7cQwQTWJWhuQ/9xaij7N0lE0IFLF38/NVU2+qZ9q9SIbBkgu5l3PnD1mRNcX+AApAzjYucyw2K9GDfMNUmXgrRv1m3rTxfncCakwUlBV0296eMmO7er0j7+NlIJzGLaYxnH9WXlootBf/n8wzcb65J6TU0r2XvNiUqYS6eoVLQ7v/8l/s6fa3IrFtEy0H5jcwn206TPZMkVoUzzERsylh6sZGAjE4nlIz3QlhQrThor"},
+	{"db0f808911d25f9be34a33ee20d5e3cd61111bfe", "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBMfEurDYjtS1g8oiTNkuyVflh9K5qM3iFS8jpltPkP7Yds7s90UKWQ7LHhYe0TXnrgj/oxEpUfrs14OvTMPAWM8="},
+	{"413723738d86d4427e62eb83d79c3613fcaaac31", "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAILtd5bw/BG3sZCKffxCHnMtEvsajvWY19eYzTFG8w7Ed"},
+}
+
+// TestSSHKeys validates that authentication subkeys are converted to
+// the same SSH keys GnuPG exports, and that revoked, expired and
+// non-authentication subkeys are left out.
+func TestSSHKeys(t *testing.T) {
+	armoured, err := ioutil.ReadFile(testSSHEd25519Path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	fpr, keys, err := SSHKeys(string(armoured), time.Unix(testSSHCreated+60, 0))
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if fpr != "5af4839b32f7c9c0ad1882852ea52d55ed6490d6" {
+		t.Fatalf("wrong primary key %s", fpr)
+	} else if len(keys) != len(testSSHKeys) {
+		t.Fatalf("found %d authentication keys, expected %d", len(keys), len(testSSHKeys))
+	}
+	for i, key := range keys {
+		if key.Fingerprint != testSSHKeys[i].fpr {
+			t.Fatalf("wrong authentication key %s", key.Fingerprint)
+		} else if line := key.AuthorizedKey(""); line != testSSHKeys[i].line {
+			t.Fatalf("wrong SSH key for %s:\n%s", key.Fingerprint, line)
+		}
+	}
+
+	_, keys, err = SSHKeys(string(armoured), time.Unix(testSSHCreated+2*86400, 0))
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(keys) != len(testSSHKeys)-1 {
+		t.Fatal("expired authentication key was used")
+	}
+}
+
+// TestSSHKeysVerify validates that an authentication subkey bound by
+// an RSA primary key is used, but not once its binding is corrupted.
+func TestSSHKeysVerify(t *testing.T) {
+	armoured, err := ioutil.ReadFile(testSSHRSAPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	_, keys, err := SSHKeys(string(armoured), time.Now())
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(keys) != 1 || keys[0].Type != "ecdsa-sha2-nistp384" {
+		t.Fatal("authentication subkey wasn't found")
+	}
+
+	data, err := dearmour(armoured)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	pkts, err := splitPackets(data)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	binding := pkts[len(pkts)-1]
+	binding[len(binding)-1] ^= 1
+
+	buf := new(bytes.Buffer)
+	w, err := armor.Encode(buf, "PGP PUBLIC KEY BLOCK", nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	w.Write(bytes.Join(pkts, nil))
+	w.Close()
+
+	if _, _, err = SSHKeys(buf.String(), time.Now()); err != ErrNoAuthKey {
+		t.Fatal("subkey with a corrupt binding signature was used")
+	}
+}
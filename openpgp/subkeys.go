@@ -0,0 +1,230 @@
+package openpgp
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+var (
+	ErrNoSigningKey    = errors.New("openpgp: no usable signing key")
+	ErrNoEncryptionKey = errors.New("openpgp: no usable encryption key")
+)
+
+// s2kGNU is the S2K type GnuPG uses for its extensions; mode 1
+// (gnu-dummy) marks a secret key that isn't present, and mode 2 one
+// that is on a smartcard.
+const s2kGNU = 101
+
+// isStub returns true if body, the body of a secret key packet
+// following the public key in pub, is a GnuPG stub.
+func isStub(body []byte, pub int) bool {
+	rest := body[pub:]
+	if len(rest) < 7 || (rest[0] != 254 && rest[0] != 255) {
+		return false
+	}
+	return rest[2] == s2kGNU && bytes.Equal(rest[4:7], []byte("GNU"))
+}
+
+// stripStubs replaces the GnuPG stubs in a secret keyring with public
+// key packets, which the openpgp package can read. This is how GnuPG
+// stores keys whose primary key is kept offline. The stubs are
+// returned indexed by fingerprint so they can be written back.
+func stripStubs(data []byte) (out []byte, stubs map[string][]byte) {
+	stubs = map[string][]byte{}
+	pkts, err := splitPackets(data)
+	if err != nil {
+		return data, stubs
+	}
+
+	buf := new(bytes.Buffer)
+	for _, pkt := range pkts {
+		tag := packetTag(pkt)
+		if tag != tagSecretKey && tag != tagSecretSubkey {
+			buf.Write(pkt)
+			continue
+		}
+
+		pubTag := byte(tagPublicKey)
+		if tag == tagSecretSubkey {
+			pubTag = tagPublicSubkey
+		}
+
+		body, err := packetBody(pkt)
+		if err != nil {
+			buf.Write(pkt)
+			continue
+		}
+
+		// The public key packet parser ignores the secret key
+		// material that follows the public key.
+		pubPkt := new(bytes.Buffer)
+		serializePacket(pubPkt, pubTag, body)
+		p, err := packet.Read(pubPkt)
+		if err != nil {
+			buf.Write(pkt)
+			continue
+		}
+		pub, ok := p.(*packet.PublicKey)
+		if !ok {
+			buf.Write(pkt)
+			continue
+		}
+		pubBody, err := publicKeyBody(pub)
+		if err != nil || !isStub(body, len(pubBody)) {
+			buf.Write(pkt)
+			continue
+		}
+
+		stubs[fingerprint(pub)] = pkt
+		serializePacket(buf, pubTag, pubBody)
+	}
+	out = buf.Bytes()
+	return
+}
+
+// hasSecretKey returns true if any of the entity's keys has its secret
+// key available.
+func hasSecretKey(e *openpgp.Entity) bool {
+	if e.PrivateKey != nil {
+		return true
+	}
+	for _, subkey := range e.Subkeys {
+		if subkey.PrivateKey != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// subkeyValidity returns an error if the subkey isn't valid at the
+// given time.
+func subkeyValidity(subkey *openpgp.Subkey, now time.Time) error {
+	if subkey.Sig.SigType == packet.SigTypeSubkeyRevocation {
+		return ErrKeyRevoked
+	} else if expiry := keyExpiry(subkey.PublicKey, subkey.Sig); !expiry.IsZero() && now.After(expiry) {
+		return ErrKeyExpired
+	} else if subkey.PublicKey.CreationTime.After(now) {
+		return ErrKeyNotYetUsed
+	}
+	return nil
+}
+
+// usableSubkeys returns the subkeys that match use, newest first. If
+// force is false, subkeys that aren't valid are left out, and invalid
+// is the reason the last one was; otherwise they come after the valid
+// subkeys.
+func usableSubkeys(e *openpgp.Entity, now time.Time, force bool, use func(*openpgp.Subkey) bool) (subkeys []openpgp.Subkey, invalid error) {
+	var valid []bool
+	for _, subkey := range e.Subkeys {
+		if subkey.Sig == nil || !use(&subkey) {
+			continue
+		}
+
+		err := subkeyValidity(&subkey, now)
+		if err != nil && !force {
+			invalid = err
+			continue
+		}
+
+		ok := err == nil
+		i := len(subkeys)
+		for i > 0 {
+			newer := subkey.PublicKey.CreationTime.After(subkeys[i-1].PublicKey.CreationTime)
+			if !(ok && !valid[i-1]) && !(ok == valid[i-1] && newer) {
+				break
+			}
+			i--
+		}
+		subkeys = append(subkeys, openpgp.Subkey{})
+		copy(subkeys[i+1:], subkeys[i:])
+		subkeys[i] = subkey
+		valid = append(valid, false)
+		copy(valid[i+1:], valid[i:])
+		valid[i] = ok
+	}
+	return
+}
+
+// primaryUsable returns true if the key flags on the entity's
+// self-signature allow its primary key to be used as flag says.
+func primaryUsable(e *openpgp.Entity, flag func(*packet.Signature) bool) bool {
+	sig := validSelfSignature(e)
+	if sig == nil {
+		return false
+	}
+	return !sig.FlagsValid || flag(sig)
+}
+
+// signingKey chooses the key to sign with: the newest valid signing
+// subkey whose secret key is available, falling back to the primary
+// key. A key with an offline primary key can still sign with a
+// subkey. Expired and revoked keys are only used if force is true.
+func signingKey(e *openpgp.Entity, now time.Time, force bool) (pub *packet.PublicKey, priv *packet.PrivateKey, err error) {
+	if !force {
+		if err = checkPrimary(e, now); err != nil {
+			return
+		}
+	}
+
+	subkeys, invalid := usableSubkeys(e, now, force, func(subkey *openpgp.Subkey) bool {
+		return subkey.PrivateKey != nil && subkey.Sig.FlagsValid && subkey.Sig.FlagSign &&
+			subkey.PublicKey.PubKeyAlgo.CanSign()
+	})
+	if len(subkeys) > 0 {
+		return subkeys[0].PublicKey, subkeys[0].PrivateKey, nil
+	}
+
+	if e.PrivateKey != nil && primaryUsable(e, func(sig *packet.Signature) bool { return sig.FlagSign }) {
+		return e.PrimaryKey, e.PrivateKey, nil
+	}
+
+	err = ErrNoSigningKey
+	if invalid != nil {
+		err = invalid
+	}
+	return
+}
+
+// encryptionKey chooses the key to encrypt to: the newest valid
+// encryption subkey, falling back to the primary key if it may be
+// used for encryption. Expired and revoked keys are only used if
+// force is true.
+func encryptionKey(e *openpgp.Entity, now time.Time, force bool) (pub *packet.PublicKey, err error) {
+	canEncrypt := func(pub *packet.PublicKey) bool {
+		switch pub.PubKeyAlgo {
+		case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoElGamal:
+			return true
+		}
+		return false
+	}
+
+	if !force {
+		if err = checkPrimary(e, now); err != nil {
+			return
+		}
+	}
+
+	subkeys, invalid := usableSubkeys(e, now, force, func(subkey *openpgp.Subkey) bool {
+		return subkey.Sig.FlagsValid && (subkey.Sig.FlagEncryptCommunications || subkey.Sig.FlagEncryptStorage) &&
+			canEncrypt(subkey.PublicKey)
+	})
+	if len(subkeys) > 0 {
+		return subkeys[0].PublicKey, nil
+	}
+
+	if canEncrypt(e.PrimaryKey) && primaryUsable(e, func(sig *packet.Signature) bool {
+		return sig.FlagEncryptCommunications || sig.FlagEncryptStorage
+	}) {
+		return e.PrimaryKey, nil
+	}
+
+	err = ErrNoEncryptionKey
+	if invalid != nil {
+		err = invalid
+	}
+	return
+}
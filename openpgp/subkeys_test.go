@@ -0,0 +1,166 @@
+package openpgp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// offlineKey is a key whose primary key is kept offline, exported
+// with gpg --export-secret-subkeys; it has signing and encryption
+// subkeys.
+const (
+	offlineKey      = "A1FA330B3BB96AFD8D5919F34638B6D44F052AEC"
+	offlineSigner   = "D0A4C51AF87499853693BAA363597EFC1C20FD3E"
+	offlineEncrypts = "2E3ED5C4455EEAA554C4266EC9B0B92546483865"
+)
+
+// loadOfflineKey imports the offline key into a new secret keyring
+// that will be stored at path.
+func loadOfflineKey(t *testing.T, path string) (keyRing *KeyRing) {
+	armoured, err := ioutil.ReadFile("testdata/offline.asc")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	keyRing = NewKeyRing(path, true)
+	if _, err = keyRing.Import(string(armoured)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return
+}
+
+// readArmoredMessage reads an armoured message, returning its
+// contents so that any signature is checked.
+func readArmoredMessage(armoured []byte, keys openpgp.EntityList) (md *openpgp.MessageDetails, body []byte, err error) {
+	block, err := armor.Decode(bytes.NewReader(armoured))
+	if err != nil {
+		return
+	}
+	md, err = openpgp.ReadMessage(block.Body, keys, nil, nil)
+	if err != nil {
+		return
+	}
+	body, err = ioutil.ReadAll(md.UnverifiedBody)
+	return
+}
+
+// TestOfflinePrimaryKey validates that a secret keyring whose primary
+// key is a GnuPG stub keeps the stub, and that signing and encryption
+// use the subkeys.
+func TestOfflinePrimaryKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keybase")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secring.gpg")
+	secRing := loadOfflineKey(t, path)
+	stub, ok := secRing.stubs[strings.ToLower(offlineKey)]
+	if !ok {
+		t.Fatal("stub wasn't kept on import")
+	} else if err = secRing.Store(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !bytes.HasPrefix(data, stub) {
+		t.Fatal("stub wasn't stored")
+	}
+
+	secRing, err = LoadKeyRing(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !secRing.Private() {
+		t.Fatal("keyring with secret subkeys should be private")
+	}
+	stored := secRing.Entity(offlineKey)
+	if stored == nil {
+		t.Fatal("key with a stub wasn't loaded")
+	} else if stored.PrivateKey != nil || !hasSecretKey(stored) {
+		t.Fatal("only the subkeys should have secret keys")
+	}
+
+	if err = secRing.Unlock(offlineKey); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	message := []byte("signed by a subkey\n")
+	signed, err := secRing.Sign(message, offlineKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	md, _, err := readArmoredMessage(signed, openpgp.EntityList{stored})
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if fmt.Sprintf("%016X", md.SignedByKeyId) != offlineSigner[24:] {
+		t.Fatalf("message was signed by %X, not the signing subkey", md.SignedByKeyId)
+	} else if md.SignatureError != nil {
+		t.Fatalf("%v", md.SignatureError)
+	}
+
+	encrypted, err := secRing.Encrypt(message, "offline@example.net")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	md, body, err := readArmoredMessage(encrypted, openpgp.EntityList{stored})
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(md.EncryptedToKeyIds) != 1 || fmt.Sprintf("%016X", md.EncryptedToKeyIds[0]) != offlineEncrypts[24:] {
+		t.Fatal("message wasn't encrypted to the encryption subkey")
+	} else if !bytes.Equal(body, message) {
+		t.Fatal("decrypted message doesn't match")
+	}
+
+	if _, err = secRing.Encrypt(message, "no-such-key@example.net"); err != ErrKeyNotFound {
+		t.Fatal("encrypting to an unknown key should fail")
+	}
+}
+
+// TestSubkeySelection validates that expired and revoked subkeys
+// aren't chosen.
+func TestSubkeySelection(t *testing.T) {
+	e := loadOfflineKey(t, "").Entity(offlineKey)
+	now := DefaultConfig.Now()
+	signer, encrypter := e.Subkeys[0], e.Subkeys[1]
+	if fingerprint(signer.PublicKey) != strings.ToLower(offlineSigner) {
+		signer, encrypter = encrypter, signer
+	}
+
+	pub, priv, err := signingKey(e, now, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if pub != signer.PublicKey || priv != signer.PrivateKey {
+		t.Fatal("the signing subkey should be chosen")
+	}
+
+	lifetime := uint32(60)
+	signer.Sig.KeyLifetimeSecs = &lifetime
+	if _, _, err = signingKey(e, signer.PublicKey.CreationTime.Add(time.Hour), false); err != ErrKeyExpired {
+		t.Fatal("an expired subkey shouldn't be used for signing")
+	}
+
+	pub, err = encryptionKey(e, now, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if pub != encrypter.PublicKey {
+		t.Fatal("the encryption subkey should be chosen")
+	}
+
+	encrypter.Sig.SigType = packet.SigTypeSubkeyRevocation
+	if _, err = encryptionKey(e, now, false); err != ErrKeyRevoked {
+		t.Fatal("a revoked subkey shouldn't be used for encryption")
+	}
+}
@@ -0,0 +1,219 @@
+package openpgp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+var (
+	ErrKeyExpired    = errors.New("openpgp: key has expired")
+	ErrKeyRevoked    = errors.New("openpgp: key has been revoked")
+	ErrNoSelfSig     = errors.New("openpgp: key has no valid self-signature")
+	ErrKeyNotYetUsed = errors.New("openpgp: key was created in the future")
+)
+
+// A KeyValidity reports whether a primary key or subkey is usable at a
+// given time, and why not.
+type KeyValidity struct {
+	Fingerprint string
+	Subkey      bool
+	Created     time.Time
+	Expires     time.Time // zero if the key doesn't expire
+	Revoked     bool
+	Reasons     []string // empty if the key is valid
+	err         error
+}
+
+// Valid returns true if the key is usable.
+func (v *KeyValidity) Valid() bool {
+	return len(v.Reasons) == 0
+}
+
+// Err returns the main reason the key isn't usable, or nil.
+func (v *KeyValidity) Err() error {
+	return v.err
+}
+
+// ExpiresWithin returns true if the key expires within d of at.
+func (v *KeyValidity) ExpiresWithin(at time.Time, d time.Duration) bool {
+	return !v.Expires.IsZero() && v.Expires.Before(at.Add(d))
+}
+
+func (v *KeyValidity) String() string {
+	what := "key"
+	if v.Subkey {
+		what = "subkey"
+	}
+	if v.Valid() {
+		if v.Expires.IsZero() {
+			return fmt.Sprintf("%s %s: valid", what, v.Fingerprint)
+		}
+		return fmt.Sprintf("%s %s: valid until %s", what, v.Fingerprint,
+			v.Expires.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("%s %s: %s", what, v.Fingerprint, strings.Join(v.Reasons, ", "))
+}
+
+func (v *KeyValidity) invalid(err error, reason string) {
+	if v.err == nil {
+		v.err = err
+	}
+	v.Reasons = append(v.Reasons, reason)
+}
+
+// revocationReason describes a revocation signature.
+func revocationReason(sig *packet.Signature) string {
+	reason := "revoked"
+	if sig.RevocationReason != nil {
+		switch *sig.RevocationReason {
+		case RevokeSuperseded:
+			reason += " (superseded)"
+		case RevokeCompromise:
+			reason += " (compromised)"
+		case RevokeRetired:
+			reason += " (no longer used)"
+		}
+	}
+	if sig.RevocationReasonText != "" {
+		reason += ": " + sig.RevocationReasonText
+	}
+	return reason
+}
+
+// keyExpiry returns when the key bound by sig expires, or the zero
+// time if it doesn't.
+func keyExpiry(pub *packet.PublicKey, sig *packet.Signature) time.Time {
+	if sig == nil || sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return time.Time{}
+	}
+	return pub.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+}
+
+// identityValid returns true if the named user ID has a valid
+// self-signature and hasn't been revoked since it was made. Only
+// revocations signed by the primary key count; a later
+// self-signature restores a revoked user ID.
+func identityValid(e *openpgp.Entity, name string) bool {
+	id, ok := e.Identities[name]
+	if !ok || id.SelfSignature == nil || isRevocation(id.SelfSignature) {
+		return false
+	}
+	if e.PrimaryKey.VerifyUserIdSignature(name, e.PrimaryKey, id.SelfSignature) != nil {
+		return false
+	}
+
+	for _, sig := range id.Signatures {
+		if !sig.CreationTime.Before(id.SelfSignature.CreationTime) && userIDRevocation(e, name, sig) {
+			return false
+		}
+	}
+	return true
+}
+
+// validSelfSignature returns the self-signature of the first valid
+// user ID, preferring the primary user ID.
+func validSelfSignature(e *openpgp.Entity) *packet.Signature {
+	for _, name := range identityNames(e) {
+		if identityValid(e, name) {
+			return e.Identities[name].SelfSignature
+		}
+	}
+	return nil
+}
+
+// CheckEntity reports the validity of the entity's primary key, which
+// comes first, and each of its subkeys at the given time.
+func CheckEntity(e *openpgp.Entity, at time.Time) (validity []*KeyValidity) {
+	primary := &KeyValidity{
+		Fingerprint: fingerprint(e.PrimaryKey),
+		Created:     e.PrimaryKey.CreationTime,
+	}
+	validity = append(validity, primary)
+
+	if len(e.Revocations) > 0 {
+		primary.Revoked = true
+		primary.invalid(ErrKeyRevoked, revocationReason(e.Revocations[0]))
+	}
+
+	selfSig := validSelfSignature(e)
+	if selfSig == nil {
+		primary.invalid(ErrNoSelfSig, "no valid self-signature")
+	} else {
+		primary.Expires = keyExpiry(e.PrimaryKey, selfSig)
+	}
+
+	if primary.Created.After(at) {
+		primary.invalid(ErrKeyNotYetUsed, "created in the future")
+	} else if !primary.Expires.IsZero() && at.After(primary.Expires) {
+		primary.invalid(ErrKeyExpired, "expired on "+primary.Expires.Format("2006-01-02"))
+	}
+
+	for _, subkey := range e.Subkeys {
+		v := &KeyValidity{
+			Fingerprint: fingerprint(subkey.PublicKey),
+			Subkey:      true,
+			Created:     subkey.PublicKey.CreationTime,
+		}
+		validity = append(validity, v)
+
+		switch {
+		case subkey.Sig == nil:
+			v.invalid(ErrNoSelfSig, "no binding signature")
+		case subkey.Sig.SigType == packet.SigTypeSubkeyRevocation:
+			v.Revoked = true
+			v.invalid(ErrKeyRevoked, revocationReason(subkey.Sig))
+		default:
+			v.Expires = keyExpiry(subkey.PublicKey, subkey.Sig)
+		}
+
+		if v.Created.After(at) {
+			v.invalid(ErrKeyNotYetUsed, "created in the future")
+		} else if !v.Expires.IsZero() && at.After(v.Expires) {
+			v.invalid(ErrKeyExpired, "expired on "+v.Expires.Format("2006-01-02"))
+		}
+
+		// A subkey can't outlive its primary key.
+		if !primary.Valid() {
+			v.invalid(primary.err, "primary key is not valid")
+		}
+	}
+	return
+}
+
+// Validity reports the validity of the named key and its subkeys at
+// the given time; the primary key comes first.
+func (keyRing *KeyRing) Validity(keyID string, at time.Time) (validity []*KeyValidity, err error) {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	e, err := keyRing.find(keyID)
+	if err != nil {
+		return
+	}
+	validity = CheckEntity(e, at)
+	return
+}
+
+// CheckAll reports the validity of every key in the keyring at the
+// given time, indexed by fingerprint.
+func (keyRing *KeyRing) CheckAll(at time.Time) (validity map[string][]*KeyValidity) {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	validity = map[string][]*KeyValidity{}
+	for fpr, e := range keyRing.entities {
+		validity[fpr] = CheckEntity(e, at)
+	}
+	return
+}
+
+// checkPrimary returns an error if the entity's primary key isn't
+// valid at the given time.
+func checkPrimary(e *openpgp.Entity, at time.Time) error {
+	return CheckEntity(e, at)[0].Err()
+}
@@ -0,0 +1,135 @@
+package openpgp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// TestCheckEntity validates that expiry and revocation are reported,
+// and that revoked keys are refused for signing and upload unless
+// forced.
+func TestCheckEntity(t *testing.T) {
+	e, err := NewEntity(&KeyOptions{
+		Name:      "Expiring Key",
+		Email:     "expiring@example.net",
+		Algorithm: AlgoECC,
+		Lifetime:  24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	now := e.PrimaryKey.CreationTime
+	v := CheckEntity(e, now)[0]
+	if !v.Valid() {
+		t.Fatalf("new key isn't valid: %s", v)
+	} else if !v.Expires.Equal(now.Add(24 * time.Hour)) {
+		t.Fatalf("wrong expiry %v", v.Expires)
+	} else if !v.ExpiresWithin(now, 48*time.Hour) || v.ExpiresWithin(now, time.Hour) {
+		t.Fatal("near expiry wasn't reported correctly")
+	}
+
+	v = CheckEntity(e, now.Add(48*time.Hour))[0]
+	if v.Valid() || v.Err() != ErrKeyExpired {
+		t.Fatal("expired key should be reported as expired")
+	}
+
+	secRing := NewKeyRing("", true)
+	if err = secRing.Add(e, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err = secRing.Sign([]byte("message"), "expiring@example.net"); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	pkt, err := keyRevocation(e.PrivateKey, RevokeRetired, "testing")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	p, err := packet.Read(bytes.NewReader(pkt))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e.Revocations = append(e.Revocations, p.(*packet.Signature))
+
+	validity, err := secRing.Validity("expiring@example.net", now)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !validity[0].Revoked || validity[0].Err() != ErrKeyRevoked {
+		t.Fatal("revoked key should be reported as revoked")
+	} else if validity[0].Reasons[0] != "revoked (no longer used): testing" {
+		t.Fatalf("wrong revocation reason %q", validity[0].Reasons[0])
+	}
+
+	if _, err = secRing.Sign([]byte("message"), "expiring@example.net"); err != ErrKeyRevoked {
+		t.Fatal("revoked key was used for signing")
+	} else if _, err = secRing.ExportForUpload("expiring@example.net"); err != ErrKeyRevoked {
+		t.Fatal("revoked key was exported for upload")
+	}
+
+	secRing.Force = true
+	if _, err = secRing.Sign([]byte("message"), "expiring@example.net"); err != nil {
+		t.Fatalf("%v", err)
+	} else if _, err = secRing.ExportForUpload("expiring@example.net"); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+// signUserID makes a signature of the given type over a user ID of e,
+// issued by e's primary key but signed with priv.
+func signUserID(t *testing.T, e *openpgp.Entity, name string, sigType packet.SignatureType,
+	at time.Time, priv *packet.PrivateKey) *packet.Signature {
+	sig := &packet.Signature{
+		CreationTime: at,
+		SigType:      sigType,
+		PubKeyAlgo:   priv.PubKeyAlgo,
+		Hash:         DefaultConfig.Hash(),
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+	if err := sig.SignUserId(name, e.PrimaryKey, priv, DefaultConfig); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return sig
+}
+
+// TestIdentityRevocation validates that a user ID revocation is only
+// honoured if the primary key signed it, and only until the user ID is
+// certified again.
+func TestIdentityRevocation(t *testing.T) {
+	newKey := func(email string) *openpgp.Entity {
+		e, err := NewEntity(&KeyOptions{Name: "Test Key", Email: email, Algorithm: AlgoECC})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return e
+	}
+	e := newKey("test@example.net")
+	other := newKey("other@example.net")
+
+	var name string
+	for name = range e.Identities {
+	}
+	id := e.Identities[name]
+	now := id.SelfSignature.CreationTime
+
+	forged := signUserID(t, e, name, sigTypeCertRevocation, now.Add(time.Hour), other.PrivateKey)
+	id.Signatures = append(id.Signatures, forged)
+	if v := CheckEntity(e, now)[0]; !v.Valid() {
+		t.Fatalf("a forged revocation was honoured: %s", v)
+	}
+
+	revoked := signUserID(t, e, name, sigTypeCertRevocation, now.Add(time.Hour), e.PrivateKey)
+	id.Signatures = append(id.Signatures, revoked)
+	if v := CheckEntity(e, now)[0]; v.Err() != ErrNoSelfSig {
+		t.Fatalf("a revoked user ID is still valid: %s", v)
+	}
+
+	id.SelfSignature = signUserID(t, e, name, packet.SigTypePositiveCert, now.Add(2*time.Hour), e.PrivateKey)
+	if v := CheckEntity(e, now.Add(2*time.Hour))[0]; !v.Valid() {
+		t.Fatalf("a recertified user ID isn't valid: %s", v)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/gokyle/keybase/api"
+	"github.com/gokyle/keybase/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+)
+
+// Exit statuses. Failures that scripts are likely to want to tell
+// apart have their own; anything else exits with exitFailure.
+const (
+	exitFailure  = 1
+	exitUsage    = 2 // a command line mistake, as the flag package uses
+	exitNotFound = 3 // no such user or key
+	exitAuth     = 4 // the login, session or passphrase was refused
+	exitNetwork  = 5 // keybase.io couldn't (or with -offline, mustn't) be reached
+	exitVerify   = 6 // a key or signature didn't check out
+)
+
+// exitCodes names the exit statuses in JSON errors.
+var exitCodes = map[int]string{
+	exitFailure:  "failure",
+	exitUsage:    "usage",
+	exitNotFound: "not_found",
+	exitAuth:     "auth_failure",
+	exitNetwork:  "network_failure",
+	exitVerify:   "verification_failure",
+}
+
+// jsonOutput is set by -json, or output = "json" in the config. Each
+// command then prints a single JSON document on standard output, and
+// progress messages and prompts go to standard error.
+var jsonOutput bool
+
+// A statusError is an error that causes a particular exit status.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// errNoPublicKey reports a keybase user without a public key.
+func errNoPublicKey(name string) error {
+	return &statusError{exitNotFound, name + " hasn't uploaded a public key yet"}
+}
+
+// errMismatch reports a key served by keybase.io that doesn't match
+// the fingerprint on the account.
+func errMismatch(fpr, want string) error {
+	return &statusError{exitVerify, fmt.Sprintf("the key served (%s) doesn't match the fingerprint on the account (%s)",
+		fpr, want)}
+}
+
+// exitStatus returns the exit status for a failure caused by err.
+func exitStatus(err error) int {
+	var se *statusError
+	var netErr net.Error
+	var sigErr pgperrors.SignatureError
+	switch {
+	case errors.As(err, &se):
+		return se.status
+	case err == api.ErrInvalidFingerprint, err == api.ErrInvalidKID, err == api.ErrUnknownService:
+		return exitUsage
+	case api.IsNotFound(err), err == openpgp.ErrKeyNotFound, err == openpgp.ErrNoAuthKey:
+		return exitNotFound
+	case api.IsAuthError(err), err == openpgp.ErrBadPassphrase:
+		return exitAuth
+	case errors.As(err, &netErr), err == api.ErrOffline:
+		return exitNetwork
+	case errors.As(err, &sigErr), err == api.ErrKeyMismatch, err == openpgp.ErrKeyRevoked,
+		err == openpgp.ErrKeyExpired, err == openpgp.ErrNoSelfSig, err == openpgp.ErrKeyNotYetUsed:
+		return exitVerify
+	}
+	return exitFailure
+}
+
+// An errorDoc is the document printed for a failure with -json.
+type errorDoc struct {
+	Error struct {
+		Code       string `json:"code"`
+		ExitStatus int    `json:"exit_status"`
+		Message    string `json:"message"`
+	} `json:"error"`
+}
+
+func newErrorDoc(status int, msg string) *errorDoc {
+	doc := new(errorDoc)
+	doc.Error.Code = exitCodes[status]
+	doc.Error.ExitStatus = status
+	doc.Error.Message = msg
+	return doc
+}
+
+// fail reports a failure, as a JSON error with -json, and exits with
+// status.
+func fail(status int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !jsonOutput {
+		fmt.Println(msg)
+		os.Exit(status)
+	}
+	emit(newErrorDoc(status, msg))
+	os.Exit(status)
+}
+
+// failErr reports a failure caused by err, e.g. "Lookup failed: ...",
+// exiting with the status for err.
+func failErr(err error, what string) {
+	fail(exitStatus(err), "%s: %v", what, err)
+}
+
+// info prints a progress message: to standard output normally, and to
+// standard error with -json so it doesn't get mixed up with the
+// document.
+func info(format string, args ...interface{}) {
+	if jsonOutput {
+		fmt.Fprintf(os.Stderr, format, args...)
+		return
+	}
+	fmt.Printf(format, args...)
+}
+
+// emit prints a command's JSON document.
+func emit(doc interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't encode the output: %v\n", err)
+		os.Exit(exitFailure)
+	}
+}
+
+// unixTime converts an API timestamp for JSON output.
+func unixTime(ts int) time.Time {
+	return time.Unix(int64(ts), 0).UTC()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/gokyle/keybase/api"
+	"github.com/gokyle/keybase/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+)
+
+// TestExitStatus validates the exit status each kind of failure gets,
+// which scripts depend on.
+func TestExitStatus(t *testing.T) {
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{errors.New("something else"), exitFailure},
+
+		{api.ErrInvalidFingerprint, exitUsage},
+		{api.ErrInvalidKID, exitUsage},
+		{api.ErrUnknownService, exitUsage},
+		{&statusError{exitUsage, "bad flag"}, exitUsage},
+
+		{api.ErrUserNotFound, exitNotFound},
+		{&api.Status{Code: api.StatusNotFound, Desc: "user not found"}, exitNotFound},
+		{openpgp.ErrKeyNotFound, exitNotFound},
+		{errNoPublicKey("alice"), exitNotFound},
+
+		{&api.Status{Code: api.StatusLoginRequired, Desc: "login required"}, exitAuth},
+		{&api.Status{Code: api.StatusBadLoginPassword, Desc: "bad password"}, exitAuth},
+		{openpgp.ErrBadPassphrase, exitAuth},
+
+		{netErr, exitNetwork},
+		{fmt.Errorf("lookup failed: %w", netErr), exitNetwork},
+		{api.ErrOffline, exitNetwork},
+
+		{pgperrors.SignatureError("bad signature"), exitVerify},
+		{api.ErrKeyMismatch, exitVerify},
+		{openpgp.ErrKeyRevoked, exitVerify},
+		{openpgp.ErrKeyExpired, exitVerify},
+		{errMismatch("aaaa", "bbbb"), exitVerify},
+		{errPinChanged(&pin{Username: "alice"}, "key has changed"), exitVerify},
+	}
+
+	for _, test := range tests {
+		if status := exitStatus(test.err); status != test.status {
+			t.Fatalf("%v: expected exit status %d, got %d", test.err, test.status, status)
+		}
+	}
+}
+
+// TestErrorDoc validates the JSON error document, and that every exit
+// status has a code.
+func TestErrorDoc(t *testing.T) {
+	data, err := json.Marshal(newErrorDoc(exitNotFound, "Lookup failed: 205: user not found"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var doc map[string]map[string]interface{}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("%v", err)
+	}
+	expected := map[string]map[string]interface{}{
+		"error": {
+			"code":        "not_found",
+			"exit_status": float64(3),
+			"message":     "Lookup failed: 205: user not found",
+		},
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Fatalf("expected %v, got %s", expected, data)
+	}
+
+	for status := exitFailure; status <= exitVerify; status++ {
+		if exitCodes[status] == "" {
+			t.Fatalf("exit status %d has no code", status)
+		}
+	}
+}
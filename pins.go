@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokyle/keybase/api"
+)
+
+// A pin records a user's key and the tail of their signature chain as
+// they were first seen, so that lookups notice if keybase.io
+// starts serving something else. The chain is expected to grow, and
+// the pin follows it; a different key, or a chain that's gone back or
+// been rewritten, has to be accepted with pin accept.
+type pin struct {
+	Username    string    `json:"username"`
+	Fingerprint string    `json:"fingerprint"`
+	KID         string    `json:"kid"`
+	SeqNo       int       `json:"seqno,omitempty"`
+	PayloadHash string    `json:"payload_hash,omitempty"`
+	Pinned      time.Time `json:"pinned"`
+}
+
+// pinFields are the parts of a user that pins are checked against.
+var pinFields = []string{"public_keys", "sigs"}
+
+// A pinStore is the pin file, a JSON list of pins.
+type pinStore struct {
+	path    string
+	pins    map[string]*pin // by lowercased username
+	changed bool
+}
+
+func defaultPinFile() string {
+	return filepath.Join(dataDir(), "pins.json")
+}
+
+// loadPins reads the pin file at path; a missing file has no pins.
+func loadPins(path string) (ps *pinStore, err error) {
+	ps = &pinStore{path: path, pins: map[string]*pin{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+
+	var pins []*pin
+	err = json.Unmarshal(data, &pins)
+	if err != nil {
+		return
+	}
+	for _, p := range pins {
+		ps.pins[strings.ToLower(p.Username)] = p
+	}
+	return
+}
+
+// loadPinsOrExit loads the configured pin file, exiting if it can't
+// be read.
+func loadPinsOrExit() *pinStore {
+	path := expandHome(cfg.value("pin_file"))
+	ps, err := loadPins(path)
+	if err != nil {
+		failErr(err, "Couldn't read the pins in "+path)
+	}
+	return ps
+}
+
+// list returns the pins sorted by username.
+func (ps *pinStore) list() (pins []*pin) {
+	for _, p := range ps.pins {
+		pins = append(pins, p)
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].Username < pins[j].Username })
+	return
+}
+
+// store writes the pin file if any pins have changed.
+func (ps *pinStore) store() (err error) {
+	if !ps.changed {
+		return
+	}
+	data, err := json.MarshalIndent(ps.list(), "", "  ")
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(ps.path), 0700)
+	if err != nil {
+		return
+	}
+	err = writeFileAtomic(ps.path, append(data, '\n'), 0600)
+	if err == nil {
+		ps.changed = false
+	}
+	return
+}
+
+// storeOrExit writes the pin file, exiting if it can't be.
+func (ps *pinStore) storeOrExit() {
+	if err := ps.store(); err != nil {
+		failErr(err, "Couldn't write the pins to "+ps.path)
+	}
+}
+
+func newPin(user *api.User) *pin {
+	pub := user.PublicKeys.Primary
+	p := &pin{
+		Username:    user.Basics.Username,
+		Fingerprint: strings.ToLower(pub.Fingerprint),
+		KID:         strings.ToLower(pub.KeyID),
+		Pinned:      time.Now().UTC(),
+	}
+	if last := user.Sigs.Last; last != nil {
+		p.SeqNo, p.PayloadHash = last.SeqNo, last.PayloadHash
+	}
+	return p
+}
+
+// errPinChanged reports a user who no longer matches their pin.
+func errPinChanged(p *pin, format string, args ...interface{}) error {
+	msg := fmt.Sprintf("%s's %s since it was pinned on %s; if that's expected, run 'keybase pin accept %s'",
+		p.Username, fmt.Sprintf(format, args...), p.Pinned.Format(displayTime), p.Username)
+	return &statusError{exitVerify, msg}
+}
+
+// check compares a user against their pin, pinning them if this is the
+// first time they've been seen with a key, and moving the pin along if
+// their signature chain has grown.
+func (ps *pinStore) check(user *api.User) error {
+	pub := user.PublicKeys.Primary
+	p := ps.pins[strings.ToLower(user.Basics.Username)]
+	switch {
+	case p == nil && (pub == nil || pub.Fingerprint == ""):
+		return nil
+	case p == nil:
+		p = newPin(user)
+		ps.pins[strings.ToLower(p.Username)] = p
+		ps.changed = true
+		// Standard output may be a key or an authorized_keys file.
+		fmt.Fprintf(os.Stderr, "Pinned %s's key %s.\n", p.Username, groupFingerprint(p.Fingerprint))
+		return nil
+	case pub == nil || pub.Fingerprint == "":
+		return errPinChanged(p, "key %s has been removed", groupFingerprint(p.Fingerprint))
+	case !strings.EqualFold(pub.Fingerprint, p.Fingerprint) || !strings.EqualFold(pub.KeyID, p.KID):
+		return errPinChanged(p, "key has changed from %s to %s", groupFingerprint(p.Fingerprint),
+			groupFingerprint(pub.Fingerprint))
+	}
+
+	// Pins made before chains were pinned have neither a seqno nor a
+	// hash, and take the chain as it is now.
+	last := user.Sigs.Last
+	switch {
+	case last == nil && (p.SeqNo > 0 || p.PayloadHash != ""):
+		return errPinChanged(p, "signature chain has gone back from link %d to none", p.SeqNo)
+	case last == nil:
+	case last.SeqNo < p.SeqNo:
+		return errPinChanged(p, "signature chain has gone back from link %d to %d", p.SeqNo, last.SeqNo)
+	case p.PayloadHash != "" && last.SeqNo == p.SeqNo && !strings.EqualFold(last.PayloadHash, p.PayloadHash):
+		return errPinChanged(p, "signature chain has been rewritten at link %d", p.SeqNo)
+	case last.SeqNo != p.SeqNo || p.PayloadHash == "":
+		p.SeqNo, p.PayloadHash = last.SeqNo, last.PayloadHash
+		ps.changed = true
+	}
+	return nil
+}
+
+// checkPin checks a looked up user against their pin, saving the pin
+// file if that pinned them.
+func checkPin(user *api.User) (err error) {
+	ps := loadPinsOrExit()
+	err = ps.check(user)
+	ps.storeOrExit()
+	return
+}
+
+// A pinDoc describes a pin in JSON output.
+type pinDoc struct {
+	*pin
+	Previous *pin `json:"previous,omitempty"`
+}
+
+// acceptPins looks the users up again and pins what keybase.io serves
+// now, replacing their old pins.
+func acceptPins(names []string) {
+	ps := loadPinsOrExit()
+	doc := struct {
+		Pins   []*pinDoc    `json:"pins"`
+		Failed []*failedDoc `json:"failed,omitempty"`
+	}{Pins: []*pinDoc{}}
+	var status int
+	for _, result := range api.LookupUsers(names, append([]string{"basics"}, pinFields...)) {
+		err := result.Err
+		if err == nil && (result.User.PublicKeys.Primary == nil || result.User.PublicKeys.Primary.Fingerprint == "") {
+			err = errNoPublicKey(result.Query)
+		}
+		if err != nil {
+			if status == 0 {
+				status = exitStatus(err)
+			}
+			doc.Failed = append(doc.Failed, newFailedDoc(result.Query, err))
+			continue
+		}
+
+		p := newPin(result.User)
+		name := strings.ToLower(p.Username)
+		doc.Pins = append(doc.Pins, &pinDoc{p, ps.pins[name]})
+		ps.pins[name] = p
+		ps.changed = true
+	}
+	ps.storeOrExit()
+
+	if jsonOutput {
+		emit(doc)
+	} else {
+		for _, d := range doc.Pins {
+			if d.Previous != nil && d.Previous.Fingerprint != d.Fingerprint {
+				fmt.Printf("Pinned %s's key %s, replacing %s.\n", d.Username, groupFingerprint(d.Fingerprint),
+					groupFingerprint(d.Previous.Fingerprint))
+			} else {
+				fmt.Printf("Pinned %s's key %s.\n", d.Username, groupFingerprint(d.Fingerprint))
+			}
+		}
+		for _, failed := range doc.Failed {
+			fmt.Fprintf(os.Stderr, "Couldn't pin %s: %s\n", failed.Username, failed.Message)
+		}
+	}
+	if status != 0 {
+		os.Exit(status)
+	}
+}
+
+func listPins() {
+	pins := loadPinsOrExit().list()
+	if jsonOutput {
+		emit(struct {
+			Pins []*pin `json:"pins"`
+		}{append([]*pin{}, pins...)})
+		return
+	}
+	for _, p := range pins {
+		fmt.Printf("%s\t%s\tpinned %s", p.Username, groupFingerprint(p.Fingerprint), p.Pinned.Format(displayTime))
+		if p.PayloadHash != "" {
+			fmt.Printf(", signature chain at link %d", p.SeqNo)
+		}
+		fmt.Println()
+	}
+}
+
+func pinListCommand() *command {
+	cmd := newCommand("pin list", "", 0, 0,
+		"list the pinned keys",
+		`List prints each pinned user, the fingerprint of their key and
+when it was pinned. Pins are kept in the file given by the pin_file
+setting.`)
+	cmd.run = func(args []string) {
+		listPins()
+	}
+	return cmd
+}
+
+func pinAcceptCommand() *command {
+	cmd := newCommand("pin accept", "<users...>", 1, -1,
+		"accept a change to users' keys",
+		`Accept looks the users up and pins the key and signature chain that
+keybase.io serves for them now, replacing their old pins. Use it once
+you've confirmed that a change lookup or fetch reported is
+legitimate, such as a user replacing their key.`)
+	cmd.run = func(args []string) {
+		acceptPins(args)
+	}
+	return cmd
+}
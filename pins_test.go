@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gokyle/keybase/api"
+)
+
+// pinUser returns a user with the given key and the given last link in
+// their signature chain; an empty fingerprint means no key, and a zero
+// seqno an empty chain.
+func pinUser(fpr, kid string, seqno int, hash string) *api.User {
+	user := new(api.User)
+	user.Basics.Username = "Alice"
+	if fpr != "" {
+		user.PublicKeys.Primary = &api.Key{KeyID: kid, Fingerprint: fpr}
+	}
+	if seqno != 0 {
+		user.Sigs.Last = &api.SigchainLink{SeqNo: seqno, PayloadHash: hash}
+	}
+	return user
+}
+
+// TestCheckPin validates that users are pinned the first time they're
+// seen with a key, that the pin follows a growing signature chain, and
+// that any other change is refused.
+func TestCheckPin(t *testing.T) {
+	const fpr, kid = "aaaa1111", "0101aaaa"
+	tests := []struct {
+		name    string
+		pin     *pin      // nil if the user isn't pinned
+		user    *api.User // as keybase.io serves them
+		ok      bool
+		changed bool
+		after   *pin // the pin afterwards, if there is one
+	}{
+		{"first pin", nil, pinUser(fpr, kid, 3, "h3"), true, true,
+			&pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"}},
+		{"first pin, mixed case", nil, pinUser("AAAA1111", "0101AAAA", 0, ""), true, true,
+			&pin{Fingerprint: fpr, KID: kid}},
+		{"no key", nil, pinUser("", "", 3, "h3"), true, false, nil},
+		{"unchanged", &pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"},
+			pinUser("AAAA1111", kid, 3, "H3"), true, false,
+			&pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"}},
+		{"key removed", &pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"},
+			pinUser("", "", 4, "h4"), false, false, nil},
+		{"fingerprint changed", &pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"},
+			pinUser("bbbb2222", kid, 4, "h4"), false, false, nil},
+		{"KID changed", &pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"},
+			pinUser(fpr, "0101bbbb", 4, "h4"), false, false, nil},
+		{"chain gone back", &pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"},
+			pinUser(fpr, kid, 2, "h2"), false, false, nil},
+		{"chain rewritten", &pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"},
+			pinUser(fpr, kid, 3, "x3"), false, false, nil},
+		{"chain grew", &pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"},
+			pinUser(fpr, kid, 5, "h5"), true, true,
+			&pin{Fingerprint: fpr, KID: kid, SeqNo: 5, PayloadHash: "h5"}},
+		{"chain emptied", &pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"},
+			pinUser(fpr, kid, 0, ""), false, false, nil},
+		{"old pin without chain", &pin{Fingerprint: fpr, KID: kid},
+			pinUser(fpr, kid, 3, "h3"), true, true,
+			&pin{Fingerprint: fpr, KID: kid, SeqNo: 3, PayloadHash: "h3"}},
+		{"old pin without chain, chain empty", &pin{Fingerprint: fpr, KID: kid},
+			pinUser(fpr, kid, 0, ""), true, false,
+			&pin{Fingerprint: fpr, KID: kid}},
+		{"pin without hash, chain gone back", &pin{Fingerprint: fpr, KID: kid, SeqNo: 5},
+			pinUser(fpr, kid, 3, "h3"), false, false, nil},
+		{"pin without hash, chain emptied", &pin{Fingerprint: fpr, KID: kid, SeqNo: 5},
+			pinUser(fpr, kid, 0, ""), false, false, nil},
+		{"pin without hash, same link", &pin{Fingerprint: fpr, KID: kid, SeqNo: 5},
+			pinUser(fpr, kid, 5, "h5"), true, true,
+			&pin{Fingerprint: fpr, KID: kid, SeqNo: 5, PayloadHash: "h5"}},
+	}
+
+	for _, test := range tests {
+		ps := &pinStore{pins: map[string]*pin{}}
+		var before pin
+		if test.pin != nil {
+			test.pin.Username = "Alice"
+			test.pin.Pinned = time.Now()
+			ps.pins["alice"] = test.pin
+			before = *test.pin
+		}
+
+		err := ps.check(test.user)
+		if test.ok && err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		} else if !test.ok && (err == nil || exitStatus(err) != exitVerify) {
+			t.Fatalf("%s: expected a verification error, got %v", test.name, err)
+		} else if ps.changed != test.changed {
+			t.Fatalf("%s: expected changed to be %v", test.name, test.changed)
+		}
+
+		p := ps.pins["alice"]
+		if !test.ok {
+			// A refused change leaves the pin alone.
+			if p != test.pin || *p != before {
+				t.Fatalf("%s: the pin was changed: %+v", test.name, p)
+			}
+			continue
+		}
+		switch {
+		case test.after == nil && p != nil:
+			t.Fatalf("%s: unexpected pin %+v", test.name, p)
+		case test.after == nil:
+		case p == nil:
+			t.Fatalf("%s: no pin", test.name)
+		case p.Username != "Alice" || p.Fingerprint != test.after.Fingerprint || p.KID != test.after.KID ||
+			p.SeqNo != test.after.SeqNo || p.PayloadHash != test.after.PayloadHash:
+			t.Fatalf("%s: expected pin %+v, have %+v", test.name, test.after, p)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package qr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestReedSolomon validates the error correction codewords against the
+// "HELLO WORLD" example at version 1-M.
+func TestReedSolomon(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	ecc := rsRemainder(data, rsDivisor(len(expected)))
+	if !bytes.Equal(ecc, expected) {
+		t.Fatalf("wrong error correction codewords %v", ecc)
+	}
+}
+
+// TestFormatBits validates the format and version information against
+// the values tabulated in the standard.
+func TestFormatBits(t *testing.T) {
+	c := newCode(7)
+	c.drawFunctionPatterns()
+
+	c.drawFormatBits(M, 0)
+	if bits := c.readFormatBits(); bits != 0x5412 {
+		t.Fatalf("wrong format bits for M, mask 0: %015b", bits)
+	}
+	c.drawFormatBits(L, 0)
+	if bits := c.readFormatBits(); bits != 0x77c4 {
+		t.Fatalf("wrong format bits for L, mask 0: %015b", bits)
+	}
+
+	var version int
+	for i := 17; i >= 0; i-- {
+		version <<= 1
+		if c.Dark(c.Size-11+i%3, i/3) {
+			version |= 1
+		}
+	}
+	if version != 0x07c94 {
+		t.Fatalf("wrong version information %018b", version)
+	}
+}
+
+// readFormatBits reads the copy of the format information around the
+// top left finder pattern.
+func (c *Code) readFormatBits() (bits int) {
+	set := func(i int, dark bool) {
+		if dark {
+			bits |= 1 << uint(i)
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		set(i, c.Dark(8, i))
+	}
+	set(6, c.Dark(8, 7))
+	set(7, c.Dark(8, 8))
+	set(8, c.Dark(7, 8))
+	for i := 9; i < 15; i++ {
+		set(i, c.Dark(14-i, 8))
+	}
+	return
+}
+
+// TestEncode validates that encoded data can be read back out of the
+// code, and that every block's error correction checks out.
+func TestEncode(t *testing.T) {
+	for _, size := range []int{0, 11, 100, 400, 1200} {
+		data := bytes.Repeat([]byte{0x99, 0x01, 0xa2, 0x04}, size/4+1)[:size]
+		c, err := Encode(data, M)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if c.Size != c.Version*4+17 {
+			t.Fatalf("version %d code has size %d", c.Version, c.Size)
+		}
+
+		// Recover the level and mask, and undo the mask.
+		format := c.readFormatBits() ^ 0x5412
+		if format>>13 != formatBits[M] {
+			t.Fatalf("wrong level in format bits %015b", format)
+		}
+		c.applyMask(format >> 10 & 7)
+
+		codewords := c.readCodewords()
+		level, version := M, c.Version
+		numBlocks := eccBlocks[level][version]
+		eccLen := eccPerBlock[level][version]
+		raw := rawCodewords(version)
+		numShort := numBlocks - raw%numBlocks
+		shortLen := raw / numBlocks
+
+		// Deinterleave the blocks.
+		blocks := make([][]byte, numBlocks)
+		k := 0
+		for i := 0; i <= shortLen; i++ {
+			for j := range blocks {
+				if i != shortLen-eccLen || j >= numShort {
+					blocks[j] = append(blocks[j], codewords[k])
+					k++
+				}
+			}
+		}
+
+		var decoded []byte
+		divisor := rsDivisor(eccLen)
+		for _, block := range blocks {
+			n := len(block) - eccLen
+			if !bytes.Equal(rsRemainder(block[:n], divisor), block[n:]) {
+				t.Fatalf("block fails error correction")
+			}
+			decoded = append(decoded, block[:n]...)
+		}
+
+		if decoded[0]>>4 != 0x4 {
+			t.Fatalf("not in byte mode")
+		}
+		var length, off int
+		if version < 10 {
+			length = int(decoded[0]&0xf)<<4 | int(decoded[1]>>4)
+			off = 1
+		} else {
+			length = int(decoded[0]&0xf)<<12 | int(decoded[1])<<4 | int(decoded[2]>>4)
+			off = 2
+		}
+		if length != len(data) {
+			t.Fatalf("encoded %d bytes, decoded %d", len(data), length)
+		}
+		out := make([]byte, length)
+		for i := range out {
+			out[i] = decoded[off+i]<<4 | decoded[off+i+1]>>4
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("decoded data doesn't match")
+		}
+	}
+}
+
+// readCodewords reads the codewords back out of an unmasked code.
+func (c *Code) readCodewords() (data []byte) {
+	var bits []bool
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunction[y][x] {
+					bits = append(bits, c.modules[y][x])
+				}
+			}
+		}
+	}
+	data = make([]byte, len(bits)/8)
+	for i := range data {
+		for j := 0; j < 8; j++ {
+			if bits[i*8+j] {
+				data[i] |= 0x80 >> uint(j)
+			}
+		}
+	}
+	return
+}
+
+// TestTooLarge validates that data which won't fit is refused.
+func TestTooLarge(t *testing.T) {
+	if _, err := Encode(make([]byte, 2332), M); err != ErrTooLarge {
+		t.Fatal("oversized data was encoded")
+	}
+	if _, err := Encode(make([]byte, 2331), M); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+// TestTerminal validates that the rendering has a line for every two
+// rows of modules, including the quiet zone.
+func TestTerminal(t *testing.T) {
+	c, err := Encode([]byte("keybase"), L)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(c.Terminal(false), "\n"), "\n")
+	if len(lines) != (c.Size+2*quietZone+1)/2 {
+		t.Fatalf("rendered %d lines for a %d module code", len(lines), c.Size)
+	}
+	if strings.Count(lines[0], "█") != c.Size+2*quietZone {
+		t.Fatal("quiet zone isn't light")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gokyle/keybase/api"
+	"github.com/gokyle/keybase/openpgp"
+)
+
+// An sshUserDoc lists a keybase user's SSH keys in JSON output.
+type sshUserDoc struct {
+	Username    string       `json:"username"`
+	Fingerprint string       `json:"fingerprint"`
+	Keys        []*sshKeyDoc `json:"keys"`
+}
+
+type sshKeyDoc struct {
+	Fingerprint   string `json:"fingerprint"`
+	Type          string `json:"type"`
+	AuthorizedKey string `json:"authorized_key"`
+}
+
+// userSSHKeys checks that a keybase user's public key matches the
+// fingerprint on their account, and returns its authentication keys
+// with authorized_keys lines annotated with the username and the key's
+// fingerprint.
+func userSSHKeys(user *api.User) (doc *sshUserDoc, err error) {
+	pub := user.PublicKeys.Primary
+	if pub == nil || pub.Bundle == "" {
+		err = errNoPublicKey(user.Basics.Username)
+		return
+	}
+
+	fpr, keys, err := openpgp.SSHKeys(pub.Bundle, time.Now())
+	if err != nil {
+		return
+	} else if !strings.EqualFold(fpr, pub.Fingerprint) {
+		err = errMismatch(fpr, pub.Fingerprint)
+		return
+	}
+
+	doc = &sshUserDoc{Username: user.Basics.Username, Fingerprint: fpr}
+	for _, key := range keys {
+		comment := fmt.Sprintf("keybase:%s openpgp:0x%s", doc.Username, strings.ToUpper(key.Fingerprint))
+		doc.Keys = append(doc.Keys, &sshKeyDoc{key.Fingerprint, key.Type, key.AuthorizedKey(comment)})
+	}
+	return
+}
+
+// authorizedKeys writes an authorized_keys file granting access to the
+// authentication keys of the named keybase users, to outFile or
+// standard output. Users whose keys can't be fetched or verified, or
+// don't match their pins, are left out, and the command fails once the rest have been written,
+// with the exit status for the first failure.
+func authorizedKeys(names []string, outFile string) {
+	buf := new(bytes.Buffer)
+	doc := struct {
+		Users   []*sshUserDoc `json:"users"`
+		Skipped []*failedDoc  `json:"skipped,omitempty"`
+		File    string        `json:"file,omitempty"`
+	}{Users: []*sshUserDoc{}}
+	pins := loadPinsOrExit()
+	var status int
+	for _, result := range api.LookupUsers(names, append([]string{"basics"}, pinFields...)) {
+		var user *sshUserDoc
+		err := result.Err
+		if err == nil {
+			user, err = userSSHKeys(result.User)
+		}
+		if err == nil {
+			err = pins.check(result.User)
+		}
+		if err != nil {
+			if status == 0 {
+				status = exitStatus(err)
+			}
+			doc.Skipped = append(doc.Skipped, newFailedDoc(result.Query, err))
+			if !jsonOutput {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", result.Query, err)
+			}
+			continue
+		}
+		doc.Users = append(doc.Users, user)
+		fmt.Fprintf(buf, "# keybase:%s %s\n", user.Username, strings.ToUpper(user.Fingerprint))
+		for _, key := range user.Keys {
+			fmt.Fprintln(buf, key.AuthorizedKey)
+		}
+	}
+	pins.storeOrExit()
+
+	if outFile != "" && outFile != "-" {
+		if err := ioutil.WriteFile(outFile, buf.Bytes(), 0644); err != nil {
+			failErr(err, "Couldn't write "+outFile)
+		}
+		doc.File = outFile
+	} else if !jsonOutput {
+		os.Stdout.Write(buf.Bytes())
+	}
+	if jsonOutput {
+		emit(doc)
+	}
+	if status != 0 {
+		os.Exit(status)
+	}
+}
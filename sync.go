@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokyle/keybase/api"
+	"github.com/gokyle/keybase/openpgp"
+)
+
+// syncOptions holds the sync flags.
+type syncOptions struct {
+	roster  string
+	keyring string
+	dryRun  bool
+}
+
+// A syncChange is a line of sync's summary: what happened to one
+// member's key.
+type syncChange struct {
+	Username    string                `json:"username"`
+	Change      string                `json:"change"` // added, updated, replaced, removed or unchanged
+	Fingerprint string                `json:"fingerprint"`
+	Previous    string                `json:"previous,omitempty"` // the key replaced
+	Import      *openpgp.ImportResult `json:"import,omitempty"`
+}
+
+// A syncMember is a roster member whose key has been verified.
+type syncMember struct {
+	username    string
+	fingerprint string
+	armoured    string
+}
+
+// loadSyncManifest reads the manifest kept beside a synced keyring,
+// which maps each member's lowercased username to the fingerprint of
+// the key sync imported for them. Only keys in the manifest are ever
+// removed. A missing manifest is empty.
+//
+// The manifest is written after the keyring, so a failure in between
+// leaves it behind the keyring rather than ahead of it: keys it
+// doesn't list are kept, never removed by mistake, and the next sync
+// records each current member's key again.
+func loadSyncManifest(path string) (manifest map[string]string, err error) {
+	manifest = map[string]string{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &manifest)
+	return
+}
+
+func storeSyncManifest(path string, manifest map[string]string) (err error) {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0600)
+}
+
+// verifyMember checks the key keybase.io serves for a roster member:
+// it must be the key on their account, be usable, and match their pin.
+func verifyMember(user *api.User, pins *pinStore) (member *syncMember, err error) {
+	e, err := servedKey(user)
+	if err != nil {
+		return
+	}
+	if v := openpgp.CheckEntity(e, time.Now())[0]; !v.Valid() {
+		err = &statusError{exitVerify, v.String()}
+		return
+	}
+	if err = pins.check(user); err != nil {
+		return
+	}
+
+	member = &syncMember{
+		username:    strings.ToLower(user.Basics.Username),
+		fingerprint: fmt.Sprintf("%x", e.PrimaryKey.Fingerprint),
+		armoured:    user.PublicKeys.Primary.Bundle,
+	}
+	return
+}
+
+// syncKeyRing brings a keyring into line with the verified members:
+// their keys are imported or merged, keys they've replaced are
+// removed, and so are the keys of anyone in the manifest who isn't on
+// the roster. The manifest is updated to match.
+func syncKeyRing(keyRing *openpgp.KeyRing, members []*syncMember, onRoster map[string]bool,
+	manifest map[string]string) (changes []*syncChange, err error) {
+	// Keys still held by a member, including those who couldn't be
+	// verified this time, are never removed.
+	verified := map[string]bool{}
+	keep := map[string]bool{}
+	for _, m := range members {
+		verified[m.username] = true
+		keep[m.fingerprint] = true
+	}
+	for name, fpr := range manifest {
+		if onRoster[name] && !verified[name] {
+			keep[fpr] = true
+		}
+	}
+	remove := func(fpr string) error {
+		if keep[fpr] {
+			return nil
+		}
+		_, err := keyRing.Delete(fpr)
+		if err == openpgp.ErrKeyNotFound {
+			err = nil
+		}
+		return err
+	}
+
+	for _, m := range members {
+		var results []*openpgp.ImportResult
+		results, err = keyRing.Import(m.armoured)
+		if err != nil {
+			err = fmt.Errorf("couldn't import %s's key: %v", m.username, err)
+			return
+		}
+
+		change := &syncChange{Username: m.username, Change: "unchanged", Fingerprint: m.fingerprint}
+		if r := results[0]; r.New {
+			change.Change, change.Import = "added", r
+		} else if r.Changed() {
+			change.Change, change.Import = "updated", r
+		}
+		if old := manifest[m.username]; old != "" && old != m.fingerprint {
+			if err = remove(old); err != nil {
+				return
+			}
+			change.Change, change.Previous = "replaced", old
+		}
+		manifest[m.username] = m.fingerprint
+		changes = append(changes, change)
+	}
+
+	var gone []string
+	for name := range manifest {
+		if !onRoster[name] {
+			gone = append(gone, name)
+		}
+	}
+	sort.Strings(gone)
+	for _, name := range gone {
+		if err = remove(manifest[name]); err != nil {
+			return
+		}
+		changes = append(changes, &syncChange{Username: name, Change: "removed", Fingerprint: manifest[name]})
+		delete(manifest, name)
+	}
+	return
+}
+
+// syncRoster makes the keyring hold the current, verified key of each
+// user on the roster, and no others that it put there. Members that
+// can't be looked up or verified keep whatever key they had, and are
+// reported once the rest have been synced; the command then exits with
+// the status for the first of them. With dryRun, nothing is written.
+func syncRoster(opts *syncOptions) {
+	names, err := readUserList(opts.roster)
+	if err != nil {
+		failErr(err, "Couldn't read the roster")
+	}
+	manifestPath := opts.keyring + ".sync.json"
+	manifest, err := loadSyncManifest(manifestPath)
+	if err != nil {
+		failErr(err, "Couldn't read "+manifestPath)
+	}
+	pins := loadPinsOrExit()
+
+	var members []*syncMember
+	var failed []*failedDoc
+	var status int
+	onRoster := map[string]bool{}
+	for _, result := range api.LookupUsers(names, []string{"basics", "public_keys", "sigs"}) {
+		onRoster[strings.ToLower(result.Query)] = true
+		err := result.Err
+		var member *syncMember
+		if err == nil {
+			member, err = verifyMember(result.User, pins)
+		}
+		if err != nil {
+			if status == 0 {
+				status = exitStatus(err)
+			}
+			failed = append(failed, newFailedDoc(result.Query, err))
+			continue
+		}
+		members = append(members, member)
+	}
+
+	var changes []*syncChange
+	if opts.dryRun {
+		keyRing, err := openpgp.LoadKeyRing(opts.keyring)
+		if os.IsNotExist(err) {
+			keyRing, err = openpgp.NewKeyRing(opts.keyring, false), nil
+		}
+		if err != nil {
+			failErr(err, "Couldn't open "+opts.keyring)
+		}
+		changes, err = syncKeyRing(keyRing, members, onRoster, manifest)
+		if err != nil {
+			failErr(err, "Sync failed")
+		}
+	} else {
+		err = os.MkdirAll(filepath.Dir(opts.keyring), 0700)
+		if err == nil {
+			err = openpgp.UpdateKeyRing(opts.keyring, false, func(keyRing *openpgp.KeyRing) (err error) {
+				changes, err = syncKeyRing(keyRing, members, onRoster, manifest)
+				return
+			})
+		}
+		if err != nil {
+			failErr(err, "Sync failed")
+		}
+		if err = storeSyncManifest(manifestPath, manifest); err != nil {
+			fail(exitStatus(err), "Synced %s, but couldn't write %s: %v\nRun sync again to record its keys.",
+				opts.keyring, manifestPath, err)
+		}
+		pins.storeOrExit()
+	}
+
+	printSync(opts, changes, failed)
+	if status != 0 {
+		os.Exit(status)
+	}
+}
+
+// printSync prints sync's summary: a line for each key added, updated,
+// replaced or removed, then a count of each.
+func printSync(opts *syncOptions, changes []*syncChange, failed []*failedDoc) {
+	if jsonOutput {
+		emit(struct {
+			Keyring string        `json:"keyring"`
+			DryRun  bool          `json:"dry_run"`
+			Changes []*syncChange `json:"changes"`
+			Failed  []*failedDoc  `json:"failed,omitempty"`
+		}{opts.keyring, opts.dryRun, append([]*syncChange{}, changes...), failed})
+		return
+	}
+
+	counts := map[string]int{}
+	for _, c := range changes {
+		counts[c.Change]++
+		switch c.Change {
+		case "added":
+			fmt.Printf("+ %s %s\n", c.Username, groupFingerprint(c.Fingerprint))
+		case "updated":
+			fmt.Printf("~ %s %s\n", c.Username, c.Import)
+		case "replaced":
+			fmt.Printf("~ %s %s, replacing %s\n", c.Username, groupFingerprint(c.Fingerprint),
+				groupFingerprint(c.Previous))
+		case "removed":
+			fmt.Printf("- %s %s (no longer on the roster)\n", c.Username, groupFingerprint(c.Fingerprint))
+		}
+	}
+	for _, f := range failed {
+		fmt.Fprintf(os.Stderr, "! %s: %s\n", f.Username, f.Message)
+	}
+
+	summary := fmt.Sprintf("%d added, %d updated, %d replaced, %d removed, %d unchanged and %d failed",
+		counts["added"], counts["updated"], counts["replaced"], counts["removed"], counts["unchanged"], len(failed))
+	if opts.dryRun {
+		fmt.Printf("Dry run, so %s was left alone: %s.\n", opts.keyring, summary)
+	} else {
+		fmt.Printf("Synced %s: %s.\n", opts.keyring, summary)
+	}
+}
+
+func syncCommand() *command {
+	cmd := newCommand("sync", "", 0, 0,
+		"keep a keyring of a team's keys in step with a roster",
+		`Sync fetches the public key of each keybase user listed in the
+-roster file (one per line; # starts a comment), checks that it's the
+key on their account, that it's usable and that it matches their pin,
+and imports it into the -keyring, merging it with the copy already
+there. Keys that members have replaced, and the keys of anyone who's
+left the roster, are removed; only keys that sync imported are ever
+removed, as recorded in <keyring>.sync.json. A summary of the changes
+is printed.
+
+Members who can't be looked up or verified keep the key they had, and
+sync exits with the status for the first of them after syncing the
+rest. -dry-run does all the checks and prints the summary without
+changing the keyring, for checking a roster in CI.`)
+	opts := &syncOptions{}
+	cmd.flags.StringVar(&opts.roster, "roster", "", "`file` listing the team's keybase usernames")
+	cmd.flags.StringVar(&opts.keyring, "keyring", filepath.Join(dataDir(), "team.gpg"), "`keyring` to keep the team's keys in")
+	cmd.flags.BoolVar(&opts.dryRun, "dry-run", false, "show what would change without changing anything")
+	cmd.run = func(args []string) {
+		if opts.roster == "" {
+			fail(exitUsage, "Usage: %s\nsync needs a -roster file.", cmd.usageLine())
+		}
+		syncRoster(opts)
+	}
+	return cmd
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gokyle/keybase/openpgp"
+	xopenpgp "golang.org/x/crypto/openpgp"
+)
+
+// newSyncMember generates a key for a roster member.
+func newSyncMember(t *testing.T, username string) (*syncMember, *xopenpgp.Entity) {
+	e, err := openpgp.NewEntity(&openpgp.KeyOptions{
+		Name:      username,
+		Email:     username + "@example.net",
+		Algorithm: openpgp.AlgoECC,
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fpr := fmt.Sprintf("%x", e.PrimaryKey.Fingerprint)
+	keyRing := openpgp.NewKeyRing("", false)
+	if err = keyRing.Add(e, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	armoured, err := keyRing.Export(fpr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return &syncMember{username, fpr, armoured}, e
+}
+
+// TestSyncKeyRing validates that sync adds, updates, replaces and
+// removes keys, and keeps the keys of members who couldn't be verified
+// and of anyone sync didn't add.
+func TestSyncKeyRing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keybase-sync")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	alice, aliceKey := newSyncMember(t, "alice")
+	bob, _ := newSyncMember(t, "bob")
+	newBob, _ := newSyncMember(t, "bob")
+	carol, _ := newSyncMember(t, "carol")
+	dave, _ := newSyncMember(t, "dave")
+	eve, _ := newSyncMember(t, "eve")
+
+	keyRing := openpgp.NewKeyRing(filepath.Join(dir, "team.gpg"), false)
+	if _, err = keyRing.Import(eve.armoured); err != nil {
+		t.Fatalf("%v", err)
+	}
+	manifest := map[string]string{}
+	roster := func(names ...string) map[string]bool {
+		onRoster := map[string]bool{}
+		for _, name := range names {
+			onRoster[name] = true
+		}
+		return onRoster
+	}
+	summary := func(changes []*syncChange) (s []string) {
+		for _, c := range changes {
+			s = append(s, c.Username+" "+c.Change)
+		}
+		return
+	}
+
+	changes, err := syncKeyRing(keyRing, []*syncMember{alice, bob, carol, dave},
+		roster("alice", "bob", "carol", "dave"), manifest)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if s := summary(changes); !reflect.DeepEqual(s, []string{"alice added", "bob added", "carol added", "dave added"}) {
+		t.Fatalf("wrong changes %q", s)
+	} else if len(manifest) != 4 || manifest["bob"] != bob.fingerprint {
+		t.Fatalf("wrong manifest %v", manifest)
+	}
+
+	// Alice's key is revoked, Bob replaces his, Carol can't be
+	// verified, Dave leaves, and an old account of Alice's that
+	// shared her key leaves too.
+	revocation, err := openpgp.NewRevocation(aliceKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	source := openpgp.NewKeyRing("", false)
+	if _, err = source.Import(alice.armoured); err != nil {
+		t.Fatalf("%v", err)
+	} else if _, err = source.Import(revocation); err != nil {
+		t.Fatalf("%v", err)
+	}
+	revoked := *alice
+	if revoked.armoured, err = source.Export(alice.fingerprint); err != nil {
+		t.Fatalf("%v", err)
+	}
+	manifest["alice-old"] = alice.fingerprint
+
+	changes, err = syncKeyRing(keyRing, []*syncMember{&revoked, newBob},
+		roster("alice", "bob", "carol"), manifest)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	expected := []string{"alice updated", "bob replaced", "alice-old removed", "dave removed"}
+	if s := summary(changes); !reflect.DeepEqual(s, expected) {
+		t.Fatalf("expected changes %q, got %q", expected, s)
+	} else if changes[1].Previous != bob.fingerprint {
+		t.Fatalf("wrong replaced key %s", changes[1].Previous)
+	}
+
+	for _, test := range []struct {
+		member  *syncMember
+		present bool
+	}{
+		{alice, true}, {bob, false}, {newBob, true}, {carol, true}, {dave, false}, {eve, true},
+	} {
+		if present := keyRing.Entity(test.member.fingerprint) != nil; present != test.present {
+			t.Fatalf("%s's key %s: expected present to be %v", test.member.username, test.member.fingerprint,
+				test.present)
+		}
+	}
+	expectedManifest := map[string]string{
+		"alice": alice.fingerprint,
+		"bob":   newBob.fingerprint,
+		"carol": carol.fingerprint,
+	}
+	if !reflect.DeepEqual(manifest, expectedManifest) {
+		t.Fatalf("expected manifest %v, got %v", expectedManifest, manifest)
+	}
+
+	// Syncing again changes nothing.
+	changes, err = syncKeyRing(keyRing, []*syncMember{&revoked, newBob, carol},
+		roster("alice", "bob", "carol"), manifest)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if s := summary(changes); !reflect.DeepEqual(s, []string{"alice unchanged", "bob unchanged", "carol unchanged"}) {
+		t.Fatalf("wrong changes %q", s)
+	}
+}
+
+// TestSyncManifest validates that the manifest is stored and loaded,
+// and that a missing one is empty.
+func TestSyncManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keybase-sync")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "team.gpg.sync.json")
+	manifest, err := loadSyncManifest(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(manifest) != 0 {
+		t.Fatalf("expected an empty manifest, got %v", manifest)
+	}
+
+	manifest["alice"] = "aaaa"
+	if err = storeSyncManifest(path, manifest); err != nil {
+		t.Fatalf("%v", err)
+	}
+	loaded, err := loadSyncManifest(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !reflect.DeepEqual(loaded, manifest) {
+		t.Fatalf("expected %v, got %v", manifest, loaded)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokyle/keybase/api"
+)
+
+// A watchState is what watch last saw of a user: their key, the tail
+// of their signature chain, and the state of each of their proofs.
+type watchState struct {
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	KID         string            `json:"kid,omitempty"`
+	SeqNo       int               `json:"seqno,omitempty"`
+	PayloadHash string            `json:"payload_hash,omitempty"`
+	Proofs      map[string]string `json:"proofs,omitempty"` // service:name to state
+	Checked     time.Time         `json:"checked"`
+}
+
+func newWatchState(user *api.User) *watchState {
+	state := &watchState{Proofs: map[string]string{}, Checked: time.Now().UTC()}
+	if pub := user.PublicKeys.Primary; pub != nil {
+		state.Fingerprint = strings.ToLower(pub.Fingerprint)
+		state.KID = strings.ToLower(pub.KeyID)
+	}
+	if last := user.Sigs.Last; last != nil {
+		state.SeqNo, state.PayloadHash = last.SeqNo, last.PayloadHash
+	}
+	for _, proof := range user.Proofs.All {
+		state.Proofs[proof.Type+":"+proof.Nametag] = proof.StateName()
+	}
+	return state
+}
+
+// Watch event types.
+const (
+	eventKeyAdded          = "key_added"
+	eventKeyRemoved        = "key_removed"
+	eventKeyChanged        = "key_changed"
+	eventProofAdded        = "proof_added"
+	eventProofRemoved      = "proof_removed"
+	eventProofRevoked      = "proof_revoked"
+	eventProofState        = "proof_state_changed"
+	eventSigchainGrew      = "sigchain_grew"
+	eventSigchainRewritten = "sigchain_rewritten"
+)
+
+// A watchEvent is a change watch has seen in a user, printed as a line
+// of JSON.
+type watchEvent struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Username string    `json:"username"`
+	Proof    string    `json:"proof,omitempty"` // service:name, for proof events
+	Old      string    `json:"old,omitempty"`
+	New      string    `json:"new,omitempty"`
+}
+
+// diffStates returns the events between what was last seen of a user
+// and what's seen now.
+func diffStates(username string, old, cur *watchState) (events []*watchEvent) {
+	event := func(typ, proof, oldValue, newValue string) {
+		events = append(events, &watchEvent{cur.Checked, typ, username, proof, oldValue, newValue})
+	}
+
+	switch {
+	case old.Fingerprint == cur.Fingerprint && old.KID == cur.KID:
+	case old.Fingerprint == "":
+		event(eventKeyAdded, "", "", cur.Fingerprint)
+	case cur.Fingerprint == "":
+		event(eventKeyRemoved, "", old.Fingerprint, "")
+	default:
+		event(eventKeyChanged, "", old.Fingerprint, cur.Fingerprint)
+	}
+
+	var proofs []string
+	for proof := range old.Proofs {
+		proofs = append(proofs, proof)
+	}
+	for proof := range cur.Proofs {
+		if _, ok := old.Proofs[proof]; !ok {
+			proofs = append(proofs, proof)
+		}
+	}
+	sort.Strings(proofs)
+	for _, proof := range proofs {
+		oldState, wasThere := old.Proofs[proof]
+		curState, isThere := cur.Proofs[proof]
+		switch {
+		case !wasThere:
+			event(eventProofAdded, proof, "", curState)
+		case !isThere:
+			event(eventProofRemoved, proof, oldState, "")
+		case oldState == curState:
+		case curState == "revoked":
+			event(eventProofRevoked, proof, oldState, curState)
+		default:
+			event(eventProofState, proof, oldState, curState)
+		}
+	}
+
+	oldSeq, curSeq := strconv.Itoa(old.SeqNo), strconv.Itoa(cur.SeqNo)
+	switch {
+	case cur.SeqNo > old.SeqNo:
+		event(eventSigchainGrew, "", oldSeq, curSeq)
+	case cur.SeqNo < old.SeqNo || cur.PayloadHash != old.PayloadHash:
+		event(eventSigchainRewritten, "", oldSeq, curSeq)
+	}
+	return
+}
+
+// readUserList reads a file of usernames, one per line. Blank lines,
+// and anything after a #, are ignored.
+func readUserList(path string) (names []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if name := strings.TrimSpace(line); name != "" {
+			names = append(names, name)
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// loadWatchState reads watch's state file, a JSON object mapping each
+// lowercased username to what was last seen of them. A missing file
+// has no state.
+func loadWatchState(path string) (states map[string]*watchState, err error) {
+	states = map[string]*watchState{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &states)
+	return
+}
+
+func storeWatchState(path string, states map[string]*watchState) (err error) {
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0600)
+}
+
+// runHook runs the hook command with the shell, giving it the event on
+// standard input and its type and user in $KEYBASE_EVENT and
+// $KEYBASE_EVENT_USER. The hook's output goes to standard error, to
+// keep it apart from the events.
+func runHook(hook string, event *watchEvent, data []byte) error {
+	cmd := exec.Command("sh", "-c", hook)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "KEYBASE_EVENT="+event.Type, "KEYBASE_EVENT_USER="+event.Username)
+	return cmd.Run()
+}
+
+// watchOptions holds the watch flags.
+type watchOptions struct {
+	usersFile string
+	stateFile string
+	hook      string
+	interval  time.Duration
+	once      bool
+}
+
+// watch looks up each user in the users file every interval, printing
+// an event for each change since the last time they were seen. The
+// first time a user is seen, what's seen is only recorded.
+func watch(opts *watchOptions) {
+	states, err := loadWatchState(opts.stateFile)
+	if err != nil {
+		failErr(err, "Couldn't read "+opts.stateFile)
+	}
+	// Watching is about noticing changes as soon as possible, so
+	// always ask keybase.io, if only whether a cached user has
+	// changed.
+	if api.UserCache != nil {
+		api.UserCache.TTL = 0
+	}
+
+	for {
+		names, err := readUserList(opts.usersFile)
+		if err != nil {
+			failErr(err, "Couldn't read the users to watch")
+		}
+
+		var status int
+		for _, name := range names {
+			user, err := api.LookupUser(name)
+			if err != nil {
+				if status == 0 {
+					status = exitStatus(err)
+				}
+				fmt.Fprintf(os.Stderr, "Lookup failed for %s: %v\n", name, err)
+				continue
+			}
+
+			key := strings.ToLower(name)
+			cur := newWatchState(user)
+			if old := states[key]; old != nil {
+				for _, event := range diffStates(user.Basics.Username, old, cur) {
+					reportEvent(event, opts.hook)
+				}
+			}
+			states[key] = cur
+			if err = storeWatchState(opts.stateFile, states); err != nil {
+				failErr(err, "Couldn't write "+opts.stateFile)
+			}
+		}
+
+		if opts.once {
+			if status != 0 {
+				os.Exit(status)
+			}
+			return
+		}
+		time.Sleep(opts.interval)
+	}
+}
+
+// reportEvent prints an event and runs the hook for it, if there is
+// one. A failing hook is reported, but doesn't stop watch.
+func reportEvent(event *watchEvent, hook string) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		failErr(err, "Couldn't encode an event")
+	}
+	fmt.Printf("%s\n", data)
+	if hook == "" {
+		return
+	}
+	if err = runHook(hook, event, data); err != nil {
+		fmt.Fprintf(os.Stderr, "Hook failed for %s %s: %v\n", event.Username, event.Type, err)
+	}
+}
+
+func watchCommand() *command {
+	cmd := newCommand("watch", "", 0, 0,
+		"watch keybase users for changes to their identities",
+		`Watch looks up each of the users listed in the -users file (one per
+line; # starts a comment) every -interval, and compares them with
+what it saw last time. Each change is printed on standard output as a
+line of JSON: a key added, removed or changed, a proof added, removed,
+revoked or changing state, or the signature chain growing or being
+rewritten. Events look like
+
+	{"time":"...","type":"key_changed","username":"alice","old":"<fingerprint>","new":"<fingerprint>"}
+
+with "proof" naming the proof, as service:name, for proof events.
+
+-hook runs a shell command for each event, with the event on its
+standard input and its type and user in $KEYBASE_EVENT and
+$KEYBASE_EVENT_USER. What was last seen of each user is kept in the
+-state file, so that changes made while watch wasn't running are
+reported when it starts again; users are only recorded the first time
+they're seen. The users file is read again every round. With -once,
+watch checks the users once and exits, with the status for the first
+user that couldn't be looked up.`)
+	opts := &watchOptions{}
+	cmd.flags.StringVar(&opts.usersFile, "users", "", "`file` listing the users to watch")
+	cmd.flags.StringVar(&opts.stateFile, "state", filepath.Join(dataDir(), "watch.json"),
+		"`file` to keep what was last seen in")
+	cmd.flags.StringVar(&opts.hook, "hook", "", "shell `command` to run for each event")
+	cmd.flags.DurationVar(&opts.interval, "interval", time.Hour, "how long to wait between checks")
+	cmd.flags.BoolVar(&opts.once, "once", false, "check the users once and exit")
+	cmd.run = func(args []string) {
+		if opts.usersFile == "" {
+			fail(exitUsage, "Usage: %s\nwatch needs a -users file.", cmd.usageLine())
+		} else if opts.interval <= 0 {
+			fail(exitUsage, "The -interval must be positive.")
+		} else if api.UserCache != nil && api.UserCache.Offline {
+			// Nothing would ever change.
+			fail(exitUsage, "watch needs to ask keybase.io, so it can't be used with -offline.")
+		}
+		watch(opts)
+	}
+	return cmd
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestDiffStates validates the events reported for each change to a
+// user.
+func TestDiffStates(t *testing.T) {
+	base := func() *watchState {
+		return &watchState{
+			Fingerprint: "aaaa",
+			KID:         "0101aaaa",
+			SeqNo:       3,
+			PayloadHash: "h3",
+			Proofs:      map[string]string{"twitter:alice": "ok", "dns:example.com": "ok"},
+		}
+	}
+	type event struct{ typ, proof, old, new string }
+	tests := []struct {
+		name   string
+		change func(s *watchState)
+		from   func(s *watchState) // changes to the old state
+		events []event
+	}{
+		{"unchanged", func(s *watchState) {}, nil, nil},
+		{"key added", func(s *watchState) {}, func(s *watchState) { s.Fingerprint, s.KID = "", "" },
+			[]event{{eventKeyAdded, "", "", "aaaa"}}},
+		{"key removed", func(s *watchState) { s.Fingerprint, s.KID = "", "" }, nil,
+			[]event{{eventKeyRemoved, "", "aaaa", ""}}},
+		{"key changed", func(s *watchState) { s.Fingerprint, s.KID = "bbbb", "0101bbbb" }, nil,
+			[]event{{eventKeyChanged, "", "aaaa", "bbbb"}}},
+		{"KID changed", func(s *watchState) { s.KID = "0101bbbb" }, nil,
+			[]event{{eventKeyChanged, "", "aaaa", "aaaa"}}},
+		{"proof added", func(s *watchState) { s.Proofs["github:alice"] = "ok" }, nil,
+			[]event{{eventProofAdded, "github:alice", "", "ok"}}},
+		{"proof removed", func(s *watchState) { delete(s.Proofs, "dns:example.com") }, nil,
+			[]event{{eventProofRemoved, "dns:example.com", "ok", ""}}},
+		{"proof revoked", func(s *watchState) { s.Proofs["twitter:alice"] = "revoked" }, nil,
+			[]event{{eventProofRevoked, "twitter:alice", "ok", "revoked"}}},
+		{"proof state changed", func(s *watchState) { s.Proofs["dns:example.com"] = "temporary failure" }, nil,
+			[]event{{eventProofState, "dns:example.com", "ok", "temporary failure"}}},
+		{"sigchain grew", func(s *watchState) { s.SeqNo, s.PayloadHash = 5, "h5" }, nil,
+			[]event{{eventSigchainGrew, "", "3", "5"}}},
+		{"sigchain went back", func(s *watchState) { s.SeqNo, s.PayloadHash = 2, "h2" }, nil,
+			[]event{{eventSigchainRewritten, "", "3", "2"}}},
+		{"sigchain rewritten", func(s *watchState) { s.PayloadHash = "x3" }, nil,
+			[]event{{eventSigchainRewritten, "", "3", "3"}}},
+		{"several", func(s *watchState) {
+			s.Fingerprint = "bbbb"
+			s.Proofs["github:alice"] = "ok"
+			delete(s.Proofs, "dns:example.com")
+			s.SeqNo, s.PayloadHash = 4, "h4"
+		}, nil, []event{
+			{eventKeyChanged, "", "aaaa", "bbbb"},
+			{eventProofRemoved, "dns:example.com", "ok", ""},
+			{eventProofAdded, "github:alice", "", "ok"},
+			{eventSigchainGrew, "", "3", "4"},
+		}},
+	}
+
+	for _, test := range tests {
+		old, cur := base(), base()
+		if test.from != nil {
+			test.from(old)
+		}
+		test.change(cur)
+
+		var events []event
+		for _, e := range diffStates("alice", old, cur) {
+			if e.Username != "alice" || !e.Time.Equal(cur.Checked) {
+				t.Fatalf("%s: wrong event %+v", test.name, e)
+			}
+			events = append(events, event{e.Type, e.Proof, e.Old, e.New})
+		}
+		if !reflect.DeepEqual(events, test.events) {
+			t.Fatalf("%s: expected events %v, got %v", test.name, test.events, events)
+		}
+	}
+}
+
+// TestReadUserList validates that blank lines and comments are
+// skipped.
+func TestReadUserList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keybase-watch")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "users")
+	data := "# the team\nalice\n\n  bob  # on leave\n\t\n#carol\ndave#\n"
+	if err = ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	names, err := readUserList(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if expected := []string{"alice", "bob", "dave"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %q, got %q", expected, names)
+	}
+
+	if _, err = readUserList(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("expected a missing file to fail, got %v", err)
+	}
+}